@@ -3,19 +3,38 @@ package go_moonraker
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/creachadair/jrpc2"
+	"github.com/creachadair/jrpc2/channel"
 	"github.com/creachadair/wschannel"
 	log "github.com/sirupsen/logrus"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"net/url"
+	"sync"
 )
 
 type MoonClient struct {
 	Conn *jrpc2.Client
 	Host string
+	// HTTPClient is used for file uploads and downloads. It defaults to
+	// http.DefaultClient when nil.
+	HTTPClient *http.Client
+	// APIKey is sent as X-Api-Key with file uploads and downloads when set.
+	APIKey string
+	// ThumbnailCache keeps downloaded thumbnails when set.
+	ThumbnailCache *ThumbnailCache
+
+	notifyMu      sync.Mutex
+	notifyID      int
+	notifyHandler func(*jrpc2.Request)
+	subscribers   map[string]map[int]func(*jrpc2.Request)
+	notifyCh      *notifyChannel
+	notifyCond    *sync.Cond
+	notifyQueue   []*jrpc2.Request
+	notifyStopped bool
 }
 
 func logger(text string) {
@@ -23,22 +42,53 @@ func logger(text string) {
 }
 
 func NewClient(host, path string, notifyHandler func(*jrpc2.Request)) (*MoonClient, error) {
-	var client MoonClient
-	opts := &jrpc2.ClientOptions{
-		Logger:   logger,
-		OnNotify: notifyHandler,
+	u := url.URL{Scheme: "ws", Host: host, Path: path}
+	ch, err := wschannel.Dial(u.String(), nil)
+	if err != nil {
+		return &MoonClient{}, err
 	}
+	return NewClientFromChannel(ch, host, notifyHandler), nil
+}
+
+// NewClientWithAPIKey connects like NewClient, authenticating the websocket
+// and file transfers with a Moonraker API key.
+func NewClientWithAPIKey(host, path, apiKey string, notifyHandler func(*jrpc2.Request)) (*MoonClient, error) {
 	u := url.URL{Scheme: "ws", Host: host, Path: path}
-	channel, err := wschannel.Dial(u.String(), nil)
+	ch, err := wschannel.Dial(u.String(), &wschannel.DialOptions{Header: http.Header{"X-Api-Key": {apiKey}}})
 	if err != nil {
 		return &MoonClient{}, err
 	}
-	client.Conn = jrpc2.NewClient(channel, opts)
-	client.Host = host
-	return &client, nil
+	client := NewClientFromChannel(ch, host, notifyHandler)
+	client.APIKey = apiKey
+	return client, nil
+}
+
+func NewClientFromChannel(ch channel.Channel, host string, notifyHandler func(*jrpc2.Request)) *MoonClient {
+	client := &MoonClient{
+		Host:          host,
+		notifyHandler: notifyHandler,
+		notifyCh:      newNotifyChannel(ch),
+	}
+	client.notifyCond = sync.NewCond(&client.notifyMu)
+	opts := &jrpc2.ClientOptions{
+		Logger:   logger,
+		OnNotify: client.dispatchNotification,
+	}
+	client.Conn = jrpc2.NewClient(client.notifyCh, opts)
+	go client.deliverNotifications()
+	return client
+}
+
+// Channel returns the channel the client was created with, before the client
+// wrapped it.
+func (c *MoonClient) Channel() channel.Channel {
+	return c.notifyCh.Channel
 }
 
+// Close closes the connection and stops notification delivery. Notifications
+// still queued are dropped.
 func (c *MoonClient) Close() (err error) {
+	c.stopNotifications()
 	if err := c.Conn.Close(); err != nil {
 		return err
 	}
@@ -134,6 +184,23 @@ func (c *MoonClient) QueryObject(params QueryObjectParams, results interface{})
 	return nil
 }
 
+type PrinterObjectsStatus struct {
+	EventTime float64        `json:"eventtime"`
+	Status    PrinterObjects `json:"status"`
+}
+
+func (c *MoonClient) QueryPrinterObjects(objects ...string) (*PrinterObjects, error) {
+	params := QueryObjectParams{Objects: make(map[string]interface{}, len(objects))}
+	for _, object := range objects {
+		params.Objects[object] = nil
+	}
+	var resp PrinterObjectsStatus
+	if err := c.QueryObject(params, &resp); err != nil {
+		return &resp.Status, err
+	}
+	return &resp.Status, nil
+}
+
 func (c *MoonClient) Subscribe(params QueryObjectParams, results interface{}) error {
 	ctx := context.Background()
 	if err := c.Conn.CallResult(ctx, "printer.objects.subscribe", params, results); err != nil {
@@ -142,6 +209,43 @@ func (c *MoonClient) Subscribe(params QueryObjectParams, results interface{}) er
 	return nil
 }
 
+// StatusUpdate maps printer object names to their fields. Updates only carry
+// the fields that changed since the previous one.
+type StatusUpdate map[string]json.RawMessage
+
+// ApplyTo merges the update into objects, leaving fields it doesn't mention
+// untouched.
+func (u StatusUpdate) ApplyTo(objects *PrinterObjects) error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, objects)
+}
+
+// SubscribeObjects subscribes to all fields of the given printer objects and
+// returns their current state. Later changes are delivered to OnStatusUpdate
+// handlers.
+func (c *MoonClient) SubscribeObjects(objects ...string) (StatusUpdate, error) {
+	params := QueryObjectParams{Objects: make(map[string]interface{}, len(objects))}
+	for _, object := range objects {
+		params.Objects[object] = nil
+	}
+	var resp struct {
+		Status StatusUpdate `json:"status"`
+	}
+	if err := c.Subscribe(params, &resp); err != nil {
+		return resp.Status, err
+	}
+	return resp.Status, nil
+}
+
+func (c *MoonClient) OnStatusUpdate(fn func(StatusUpdate)) func() {
+	return onTypedNotification(c, "notify_status_update", func(update *StatusUpdate) {
+		fn(*update)
+	})
+}
+
 type Endstops struct {
 	X string `json:"x"`
 	Y string `json:"y"`
@@ -200,7 +304,9 @@ type GcodeStoreEntry struct {
 func (c *MoonClient) GcodeStore(count int) (*GcodeStore, error) {
 	ctx := context.Background()
 	var resp GcodeStore
-	if err := c.Conn.CallResult(ctx, "server.gcode_store", struct{ count int }{count: count}, &resp); err != nil {
+	if err := c.Conn.CallResult(ctx, "server.gcode_store", struct {
+		Count int `json:"count"`
+	}{count}, &resp); err != nil {
 		return &GcodeStore{}, err
 	}
 	return &resp, nil
@@ -216,7 +322,9 @@ func (c *MoonClient) Restart() error {
 
 func (c *MoonClient) RunGcode(code string) error {
 	ctx := context.Background()
-	if _, err := c.Conn.Call(ctx, "printer.gcode.script", struct{ script string }{script: code}); err != nil {
+	if _, err := c.Conn.Call(ctx, "printer.gcode.script", struct {
+		Script string `json:"script"`
+	}{code}); err != nil {
 		return err
 	}
 	return nil
@@ -233,7 +341,9 @@ func (c *MoonClient) GcodeHelp() (*map[string]string, error) {
 
 func (c *MoonClient) Print(file string) error {
 	ctx := context.Background()
-	if _, err := c.Conn.Call(ctx, "printer.print.start", struct{ filename string }{filename: file}); err != nil {
+	if _, err := c.Conn.Call(ctx, "printer.print.start", struct {
+		Filename string `json:"filename"`
+	}{file}); err != nil {
 		return err
 	}
 	return nil
@@ -272,6 +382,18 @@ func (c *MoonClient) MachineInfo() (*MachineInfo, error) {
 	return &resp, nil
 }
 
+func (c *MoonClient) PrimaryIP() (string, error) {
+	info, err := c.MachineInfo()
+	if err != nil {
+		return "", err
+	}
+	ip, ok := info.SystemInfo.Network.PrimaryIP()
+	if !ok {
+		return "", fmt.Errorf("no routable address reported by %s", c.Host)
+	}
+	return ip, nil
+}
+
 func (c *MoonClient) ShutdownOS() error {
 	ctx := context.Background()
 	if _, err := c.Conn.Call(ctx, "machine.shutdown", nil); err != nil {
@@ -288,9 +410,13 @@ func (c *MoonClient) RebootOS() error {
 	return nil
 }
 
+type serviceParams struct {
+	Service string `json:"service"`
+}
+
 func (c *MoonClient) RestartService(service string) error {
 	ctx := context.Background()
-	if _, err := c.Conn.Call(ctx, "machine.services.restart", struct{ service string }{service: service}); err != nil {
+	if _, err := c.Conn.Call(ctx, "machine.services.restart", serviceParams{service}); err != nil {
 		return err
 	}
 	return nil
@@ -298,7 +424,7 @@ func (c *MoonClient) RestartService(service string) error {
 
 func (c *MoonClient) StopService(service string) error {
 	ctx := context.Background()
-	if _, err := c.Conn.Call(ctx, "machine.services.stop", struct{ service string }{service: service}); err != nil {
+	if _, err := c.Conn.Call(ctx, "machine.services.stop", serviceParams{service}); err != nil {
 		return err
 	}
 	return nil
@@ -306,32 +432,216 @@ func (c *MoonClient) StopService(service string) error {
 
 func (c *MoonClient) StartService(service string) error {
 	ctx := context.Background()
-	if _, err := c.Conn.Call(ctx, "machine.services.start", struct{ service string }{service: service}); err != nil {
+	if _, err := c.Conn.Call(ctx, "machine.services.start", serviceParams{service}); err != nil {
 		return err
 	}
 	return nil
 }
 
+func (c *MoonClient) OnServiceStateChanged(fn func(map[string]StateReport)) func() {
+	return onTypedNotification(c, "notify_service_state_changed", func(states *map[string]StateReport) {
+		fn(*states)
+	})
+}
+
+type ServiceTransition struct {
+	Service string
+	From    StateReport
+	To      StateReport
+}
+
+// OnServiceTransition reports each service whose state differs from the last
+// state seen for it on this subscription.
+func (c *MoonClient) OnServiceTransition(fn func(ServiceTransition)) func() {
+	var mu sync.Mutex
+	last := make(map[string]StateReport)
+	return c.OnServiceStateChanged(func(states map[string]StateReport) {
+		mu.Lock()
+		var transitions []ServiceTransition
+		for service, state := range states {
+			if prev, ok := last[service]; !ok || prev != state {
+				transitions = append(transitions, ServiceTransition{service, prev, state})
+			}
+			last[service] = state
+		}
+		mu.Unlock()
+		for _, t := range transitions {
+			fn(t)
+		}
+	})
+}
+
+func (c *MoonClient) SudoInfo(checkAccess bool) (*SudoInfo, error) {
+	ctx := context.Background()
+	var resp SudoInfo
+	if err := c.Conn.CallResult(ctx, "machine.sudo.info", struct {
+		CheckAccess bool `json:"check_access"`
+	}{checkAccess}, &resp); err != nil {
+		return &resp, err
+	}
+	return &resp, nil
+}
+
+func (c *MoonClient) SetSudoPassword(password string) (*SudoPasswordResp, error) {
+	ctx := context.Background()
+	var resp SudoPasswordResp
+	if err := c.Conn.CallResult(ctx, "machine.sudo.password", struct {
+		Password string `json:"password"`
+	}{password}, &resp); err != nil {
+		return &resp, err
+	}
+	return &resp, nil
+}
+
+func (c *MoonClient) USBDevices() ([]USBDevice, error) {
+	ctx := context.Background()
+	var resp struct {
+		USBDevices []USBDevice `json:"usb_devices"`
+	}
+	if err := c.Conn.CallResult(ctx, "machine.peripherals.usb", nil, &resp); err != nil {
+		return resp.USBDevices, err
+	}
+	return resp.USBDevices, nil
+}
+
+func (c *MoonClient) SerialDevices() ([]SerialDevice, error) {
+	ctx := context.Background()
+	var resp struct {
+		SerialDevices []SerialDevice `json:"serial_devices"`
+	}
+	if err := c.Conn.CallResult(ctx, "machine.peripherals.serial", nil, &resp); err != nil {
+		return resp.SerialDevices, err
+	}
+	return resp.SerialDevices, nil
+}
+
+func (c *MoonClient) VideoDevices() (*VideoDevices, error) {
+	ctx := context.Background()
+	var resp VideoDevices
+	if err := c.Conn.CallResult(ctx, "machine.peripherals.video", nil, &resp); err != nil {
+		return &resp, err
+	}
+	return &resp, nil
+}
+
+func (c *MoonClient) CanbusUUIDs(iface string) ([]CanbusUUID, error) {
+	ctx := context.Background()
+	var resp struct {
+		CanUUIDs []CanbusUUID `json:"can_uuids"`
+	}
+	if err := c.Conn.CallResult(ctx, "machine.peripherals.canbus", struct {
+		Interface string `json:"interface,omitempty"`
+	}{iface}, &resp); err != nil {
+		return resp.CanUUIDs, err
+	}
+	return resp.CanUUIDs, nil
+}
+
 type ProcStats struct {
-	MoonrakerStats       []MoonrakerStats `json:"moonraker_stats"`
-	ThrottledState       ThrottledState   `json:"throttled_state"`
-	CpuTemp              float32          `json:"cpu_temp"`
-	Network              interface{}      `json:"network"`
-	SystemCpuUsage       interface{}      `json:"system_cpu_usage"`
-	WebsocketConnections int              `json:"websocket_connections"`
+	MoonrakerStats       []MoonrakerStats        `json:"moonraker_stats"`
+	ThrottledState       ThrottledState          `json:"throttled_state"`
+	CpuTemp              float32                 `json:"cpu_temp"`
+	Network              map[string]NetworkStats `json:"network"`
+	SystemCpuUsage       CPUUsage                `json:"system_cpu_usage"`
+	SystemMemory         SystemMemory            `json:"system_memory"`
+	SystemUptime         float64                 `json:"system_uptime"`
+	WebsocketConnections int                     `json:"websocket_connections"`
 }
+
 type MoonrakerStats struct {
 	Time     float64 `json:"time"`
-	CPUUsage float32 `json:"CPUUsage"`
+	CPUUsage float32 `json:"cpu_usage"`
 	Memory   int     `json:"memory"`
 	MemUnits string  `json:"mem_units"`
 }
 
+type NetworkStats struct {
+	RxBytes   int64   `json:"rx_bytes"`
+	TxBytes   int64   `json:"tx_bytes"`
+	RxPackets int64   `json:"rx_packets"`
+	TxPackets int64   `json:"tx_packets"`
+	RxErrs    int64   `json:"rx_errs"`
+	TxErrs    int64   `json:"tx_errs"`
+	RxDrop    int64   `json:"rx_drop"`
+	TxDrop    int64   `json:"tx_drop"`
+	Bandwidth float64 `json:"bandwidth"`
+}
+
+// CPUUsage maps "cpu" to the overall usage and "cpu0", "cpu1", ... to each
+// core, in percent.
+type CPUUsage map[string]float64
+
+func (u CPUUsage) Total() float64 {
+	return u["cpu"]
+}
+
+func (u CPUUsage) Cores() []float64 {
+	var cores []float64
+	for i := 0; ; i++ {
+		usage, ok := u[fmt.Sprintf("cpu%d", i)]
+		if !ok {
+			return cores
+		}
+		cores = append(cores, usage)
+	}
+}
+
+type SystemMemory struct {
+	Total     int `json:"total"`
+	Available int `json:"available"`
+	Used      int `json:"used"`
+}
+
 type ThrottledState struct {
 	Bits  int      `json:"bits"`
 	Flags []string `json:"flags"`
 }
 
+type ThrottledCondition int
+
+const (
+	UnderVoltage               ThrottledCondition = 0
+	FrequencyCapped            ThrottledCondition = 1
+	Throttled                  ThrottledCondition = 2
+	TemperatureLimit           ThrottledCondition = 3
+	PreviouslyUnderVolted      ThrottledCondition = 16
+	PreviouslyFrequencyCapped  ThrottledCondition = 17
+	PreviouslyThrottled        ThrottledCondition = 18
+	PreviouslyTemperatureLimit ThrottledCondition = 19
+)
+
+var throttledConditionNames = map[ThrottledCondition]string{
+	UnderVoltage:               "Under-Voltage Detected",
+	FrequencyCapped:            "Frequency Capped",
+	Throttled:                  "Currently Throttled",
+	TemperatureLimit:           "Temperature Limit Active",
+	PreviouslyUnderVolted:      "Previously Under-Volted",
+	PreviouslyFrequencyCapped:  "Previously Frequency Capped",
+	PreviouslyThrottled:        "Previously Throttled",
+	PreviouslyTemperatureLimit: "Previously Temperature Limited",
+}
+
+func (t ThrottledCondition) String() string {
+	if name, ok := throttledConditionNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("Unknown Throttled Condition (bit %d)", int(t))
+}
+
+func (s ThrottledState) Has(cond ThrottledCondition) bool {
+	return s.Bits&(1<<uint(cond)) != 0
+}
+
+func (s ThrottledState) Conditions() []ThrottledCondition {
+	var conds []ThrottledCondition
+	for bit := 0; bit < 32; bit++ {
+		if s.Has(ThrottledCondition(bit)) {
+			conds = append(conds, ThrottledCondition(bit))
+		}
+	}
+	return conds
+}
+
 func (c *MoonClient) ProcStats() (*ProcStats, error) {
 	ctx := context.Background()
 	var resp ProcStats
@@ -341,6 +651,23 @@ func (c *MoonClient) ProcStats() (*ProcStats, error) {
 	return &resp, nil
 }
 
+type ProcStatUpdate struct {
+	MoonrakerStats       MoonrakerStats          `json:"moonraker_stats"`
+	CpuTemp              float32                 `json:"cpu_temp"`
+	Network              map[string]NetworkStats `json:"network"`
+	SystemCpuUsage       CPUUsage                `json:"system_cpu_usage"`
+	SystemMemory         SystemMemory            `json:"system_memory"`
+	WebsocketConnections int                     `json:"websocket_connections"`
+}
+
+func (c *MoonClient) OnProcStatUpdate(fn func(*ProcStatUpdate)) func() {
+	return onTypedNotification(c, "notify_proc_stat_update", fn)
+}
+
+func (c *MoonClient) OnCPUThrottled(fn func(*ThrottledState)) func() {
+	return onTypedNotification(c, "notify_cpu_throttled", fn)
+}
+
 type MoonrakerFile struct {
 	Path        string  `json:"path"`
 	Modified    float64 `json:"modified"`
@@ -351,15 +678,20 @@ type MoonrakerFile struct {
 func (c *MoonClient) ListFiles(root string) (*[]*MoonrakerFile, error) {
 	ctx := context.Background()
 	var resp []*MoonrakerFile
-	if err := c.Conn.CallResult(ctx, "server.files.list", struct{ root string }{root: root}, &resp); err != nil {
+	if err := c.Conn.CallResult(ctx, "server.files.list", struct {
+		Root string `json:"root"`
+	}{root}, &resp); err != nil {
 		return &resp, err
 	}
 	return &resp, nil
 }
 
+// GcodeMetadata is the slicer metadata Moonraker extracts from a G-code file.
+// PrintStartTime and JobId are only set once the file has been printed; the
+// job id is the hex string used by the job history.
 type GcodeMetadata struct {
-	PrintStartTime     int         `json:"print_start_time"`
-	JobId              int         `json:"job_id"`
+	PrintStartTime     *float64    `json:"print_start_time,omitempty"`
+	JobId              *string     `json:"job_id,omitempty"`
 	Size               int         `json:"size"`
 	Modified           float64     `json:"modified"`
 	Slicer             string      `json:"slicer"`
@@ -368,10 +700,10 @@ type GcodeMetadata struct {
 	FirstLayerHeight   float32     `json:"first_layer_height"`
 	ObjectHeight       float32     `json:"object_height"`
 	FilamentTotal      float32     `json:"filament_total"`
-	EstimatedTime      int         `json:"estimated_time"`
+	EstimatedTime      float64     `json:"estimated_time"`
 	Thumbnails         []Thumbnail `json:"thumbnails"`
-	FirstLayerBedTemp  int         `json:"first_layer_bed_temp"`
-	FirstLayerExtrTemp int         `json:"first_layer_extr_temp"`
+	FirstLayerBedTemp  float64     `json:"first_layer_bed_temp"`
+	FirstLayerExtrTemp float64     `json:"first_layer_extr_temp"`
 	GcodeStartByte     int         `json:"gcode_start_byte"`
 	GcodeEndByte       int         `json:"gcode_end_byte"`
 	Filename           string      `json:"filename"`
@@ -387,12 +719,65 @@ type Thumbnail struct {
 func (c *MoonClient) GcodeMetadata(file string) (*GcodeMetadata, error) {
 	ctx := context.Background()
 	var resp GcodeMetadata
-	if err := c.Conn.CallResult(ctx, "server.files.metadata", struct{ filename string }{filename: file}, &resp); err != nil {
+	if err := c.Conn.CallResult(ctx, "server.files.metadata", struct {
+		Filename string `json:"filename"`
+	}{file}, &resp); err != nil {
 		return &resp, err
 	}
 	return &resp, nil
 }
 
+// MetascanFile forces Moonraker to extract the metadata of a file, for
+// example right after an upload, and returns the result.
+func (c *MoonClient) MetascanFile(file string) (*GcodeMetadata, error) {
+	ctx := context.Background()
+	var resp GcodeMetadata
+	if err := c.Conn.CallResult(ctx, "server.files.metascan", struct {
+		Filename string `json:"filename"`
+	}{file}, &resp); err != nil {
+		return &resp, err
+	}
+	return &resp, nil
+}
+
+// OnMetadataUpdate registers fn for the metadata Moonraker reports after
+// scanning a new or changed file.
+func (c *MoonClient) OnMetadataUpdate(fn func(*GcodeMetadata)) func() {
+	return onTypedNotification(c, "notify_metadata_update", fn)
+}
+
+type FileListAction string
+
+const (
+	FileCreated      FileListAction = "create_file"
+	FileDeleted      FileListAction = "delete_file"
+	FileMoved        FileListAction = "move_file"
+	FileModified     FileListAction = "modify_file"
+	DirectoryCreated FileListAction = "create_dir"
+	DirectoryDeleted FileListAction = "delete_dir"
+	DirectoryMoved   FileListAction = "move_dir"
+	RootUpdated      FileListAction = "root_update"
+)
+
+type FileListItem struct {
+	Root        string  `json:"root"`
+	Path        string  `json:"path"`
+	Size        int     `json:"size"`
+	Modified    float64 `json:"modified"`
+	Permissions string  `json:"permissions"`
+}
+
+type FileListChange struct {
+	Action FileListAction `json:"action"`
+	Item   FileListItem   `json:"item"`
+	// SourceItem is where a moved file or directory came from.
+	SourceItem *FileListItem `json:"source_item,omitempty"`
+}
+
+func (c *MoonClient) OnFileListChanged(fn func(*FileListChange)) func() {
+	return onTypedNotification(c, "notify_filelist_changed", fn)
+}
+
 type DirInfo struct {
 	Dirs      []Dir           `json:"dirs"`
 	Files     []MoonrakerFile `json:"files"`
@@ -422,9 +807,9 @@ func (c *MoonClient) DirectoryInfo(path string, extended bool) (*[]*DirInfo, err
 	ctx := context.Background()
 	var resp []*DirInfo
 	if err := c.Conn.CallResult(ctx, "server.files.get_directory", struct {
-		path     string
-		extended bool
-	}{path: path, extended: extended}, &resp); err != nil {
+		Path     string `json:"path"`
+		Extended bool   `json:"extended"`
+	}{path, extended}, &resp); err != nil {
 		return &resp, err
 	}
 	return &resp, nil
@@ -432,7 +817,9 @@ func (c *MoonClient) DirectoryInfo(path string, extended bool) (*[]*DirInfo, err
 
 func (c *MoonClient) CreateDirectory(path string) error {
 	ctx := context.Background()
-	if _, err := c.Conn.Call(ctx, "server.files.post_directory", struct{ path string }{path: path}); err != nil {
+	if _, err := c.Conn.Call(ctx, "server.files.post_directory", struct {
+		Path string `json:"path"`
+	}{path}); err != nil {
 		return err
 	}
 	return nil
@@ -441,9 +828,9 @@ func (c *MoonClient) CreateDirectory(path string) error {
 func (c *MoonClient) DeleteDirectory(path string, force bool) error {
 	ctx := context.Background()
 	if _, err := c.Conn.Call(ctx, "server.files.delete_directory", struct {
-		path  string
-		force bool
-	}{path: path, force: force}); err != nil {
+		Path  string `json:"path"`
+		Force bool   `json:"force"`
+	}{path, force}); err != nil {
 		return err
 	}
 	return nil
@@ -452,9 +839,9 @@ func (c *MoonClient) DeleteDirectory(path string, force bool) error {
 func (c *MoonClient) MoveFile(source string, dest string) error {
 	ctx := context.Background()
 	if _, err := c.Conn.Call(ctx, "server.files.move", struct {
-		source string
-		dest   string
-	}{source: source, dest: dest}); err != nil {
+		Source string `json:"source"`
+		Dest   string `json:"dest"`
+	}{source, dest}); err != nil {
 		return err
 	}
 	return nil
@@ -463,21 +850,42 @@ func (c *MoonClient) MoveFile(source string, dest string) error {
 func (c *MoonClient) CopyFile(source string, dest string) error {
 	ctx := context.Background()
 	if _, err := c.Conn.Call(ctx, "server.files.copy", struct {
-		source string
-		dest   string
-	}{source: source, dest: dest}); err != nil {
+		Source string `json:"source"`
+		Dest   string `json:"dest"`
+	}{source, dest}); err != nil {
 		return err
 	}
 	return nil
 }
 
+func (c *MoonClient) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func (c *MoonClient) authorize(r *http.Request) {
+	if c.APIKey != "" {
+		r.Header.Set("X-Api-Key", c.APIKey)
+	}
+}
+
 func (c *MoonClient) DownloadFile(filename string, dest io.Writer) error {
 	u := url.URL{Scheme: "http", Host: c.Host, Path: fmt.Sprintf("/server/files/%s", filename)}
-	resp, err := http.Get(u.String())
-	defer resp.Body.Close()
+	r, err := http.NewRequest("GET", u.String(), nil)
 	if err != nil {
 		return err
 	}
+	c.authorize(r)
+	resp, err := c.httpClient().Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("download of %s failed: %s", filename, resp.Status)
+	}
 	if _, err := io.Copy(dest, resp.Body); err != nil {
 		return err
 	}
@@ -503,24 +911,40 @@ func (c *MoonClient) UploadFile(filename string, data io.Reader, startPrint stri
 		return err
 	}
 	r.Header.Add("Content-Type", writer.FormDataContentType())
-	client := &http.Client{}
-	if _, err := client.Do(r); err != nil {
+	c.authorize(r)
+	resp, err := c.httpClient().Do(r)
+	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("upload of %s failed: %s", filename, resp.Status)
+	}
 	return nil
 }
 
 func (c *MoonClient) DeleteFile(filename string) error {
 	ctx := context.Background()
-	if _, err := c.Conn.Call(ctx, "server.files.delete_file", struct{ path string }{path: filename}); err != nil {
+	if _, err := c.Conn.Call(ctx, "server.files.delete_file", struct {
+		Path string `json:"path"`
+	}{filename}); err != nil {
 		return err
 	}
 	return nil
 }
 
+type QueueState string
+
+const (
+	QueueReady    QueueState = "ready"
+	QueueLoading  QueueState = "loading"
+	QueueStarting QueueState = "starting"
+	QueuePaused   QueueState = "paused"
+)
+
 type JobQueueItems struct {
 	QueuedJobs []JobQueueItem `json:"queued_jobs"`
-	QueueState string         `json:"queue_state"`
+	QueueState QueueState     `json:"queue_state"`
 }
 
 type JobQueueItem struct {
@@ -539,19 +963,45 @@ func (c *MoonClient) ListJobQueue() (*JobQueueItems, error) {
 	return &resp, nil
 }
 
+type QueueJobsParams struct {
+	Filenames []string `json:"filenames"`
+	Reset     bool     `json:"reset,omitempty"`
+}
+
 func (c *MoonClient) QueueJobs(jobs []string) (*JobQueueItems, error) {
+	return c.postQueueJobs(QueueJobsParams{Filenames: jobs})
+}
+
+func (c *MoonClient) ReplaceJobQueue(jobs []string) (*JobQueueItems, error) {
+	return c.postQueueJobs(QueueJobsParams{Filenames: jobs, Reset: true})
+}
+
+func (c *MoonClient) postQueueJobs(params QueueJobsParams) (*JobQueueItems, error) {
 	ctx := context.Background()
 	var resp JobQueueItems
-	if err := c.Conn.CallResult(ctx, "server.job_queue.post_job", struct{ filenames []string }{filenames: jobs}, &resp); err != nil {
+	if err := c.Conn.CallResult(ctx, "server.job_queue.post_job", params, &resp); err != nil {
 		return &resp, err
 	}
 	return &resp, nil
 }
 
+type DeleteQueueJobsParams struct {
+	JobIds []string `json:"job_ids,omitempty"`
+	All    bool     `json:"all,omitempty"`
+}
+
 func (c *MoonClient) DeleteQueueJobs(jobIds []string) (*JobQueueItems, error) {
+	return c.deleteQueueJobs(DeleteQueueJobsParams{JobIds: jobIds})
+}
+
+func (c *MoonClient) ClearJobQueue() (*JobQueueItems, error) {
+	return c.deleteQueueJobs(DeleteQueueJobsParams{All: true})
+}
+
+func (c *MoonClient) deleteQueueJobs(params DeleteQueueJobsParams) (*JobQueueItems, error) {
 	ctx := context.Background()
 	var resp JobQueueItems
-	if err := c.Conn.CallResult(ctx, "server.job_queue.delete_job", struct{ job_ids []string }{job_ids: jobIds}, &resp); err != nil {
+	if err := c.Conn.CallResult(ctx, "server.job_queue.delete_job", params, &resp); err != nil {
 		return &resp, err
 	}
 	return &resp, nil
@@ -575,6 +1025,27 @@ func (c *MoonClient) StartJobQueue() (*JobQueueItems, error) {
 	return &resp, nil
 }
 
+func (c *MoonClient) JumpJobQueue(jobId string) (*JobQueueItems, error) {
+	ctx := context.Background()
+	var resp JobQueueItems
+	if err := c.Conn.CallResult(ctx, "server.job_queue.jump", struct {
+		JobId string `json:"job_id"`
+	}{jobId}, &resp); err != nil {
+		return &resp, err
+	}
+	return &resp, nil
+}
+
+type JobQueueEvent struct {
+	Action       string         `json:"action"`
+	UpdatedQueue []JobQueueItem `json:"updated_queue"`
+	QueueState   QueueState     `json:"queue_state"`
+}
+
+func (c *MoonClient) OnJobQueueChanged(fn func(*JobQueueEvent)) func() {
+	return onTypedNotification(c, "notify_job_queue_changed", fn)
+}
+
 type JobHistory struct {
 	Count int   `json:"count"`
 	Jobs  []Job `json:"jobs"`
@@ -597,17 +1068,37 @@ func (c *MoonClient) JobHistoryList(limit int, start int, since float64, before
 	ctx := context.Background()
 	var resp JobHistory
 	if err := c.Conn.CallResult(ctx, "server.history.list", struct {
-		limit  int
-		start  int
-		since  float64
-		before float64
-		order  string
+		Limit  int     `json:"limit,omitempty"`
+		Start  int     `json:"start,omitempty"`
+		Since  float64 `json:"since,omitempty"`
+		Before float64 `json:"before,omitempty"`
+		Order  string  `json:"order,omitempty"`
 	}{limit, start, since, before, order}, &resp); err != nil {
 		return &resp, err
 	}
 	return &resp, nil
 }
 
+func (c *MoonClient) WalkJobHistory(pageSize int, since float64, before float64, order string, fn func(*Job) error) error {
+	if pageSize <= 0 {
+		pageSize = 50
+	}
+	for start := 0; ; start += pageSize {
+		page, err := c.JobHistoryList(pageSize, start, since, before, order)
+		if err != nil {
+			return err
+		}
+		for i := range page.Jobs {
+			if err := fn(&page.Jobs[i]); err != nil {
+				return err
+			}
+		}
+		if len(page.Jobs) < pageSize || start+len(page.Jobs) >= page.Count {
+			return nil
+		}
+	}
+}
+
 type JobHistoryTotals struct {
 	JobTotals HistoryTotals `json:"job_totals"`
 }
@@ -645,7 +1136,9 @@ type JobHistorySingle struct {
 func (c *MoonClient) JobHistoryGetJob(uid string) (*JobHistorySingle, error) {
 	ctx := context.Background()
 	var resp JobHistorySingle
-	if err := c.Conn.CallResult(ctx, "server.history.get_job", struct{ uid string }{uid}, &resp); err != nil {
+	if err := c.Conn.CallResult(ctx, "server.history.get_job", struct {
+		Uid string `json:"uid"`
+	}{uid}, &resp); err != nil {
 		return &resp, err
 	}
 	return &resp, nil
@@ -653,7 +1146,9 @@ func (c *MoonClient) JobHistoryGetJob(uid string) (*JobHistorySingle, error) {
 
 func (c *MoonClient) JobHistoryDeleteJob(uid string) error {
 	ctx := context.Background()
-	if _, err := c.Conn.Call(ctx, "server.history.delete_job", struct{ uid string }{uid}); err != nil {
+	if _, err := c.Conn.Call(ctx, "server.history.delete_job", struct {
+		Uid string `json:"uid"`
+	}{uid}); err != nil {
 		return err
 	}
 	return nil
@@ -1,6 +1,7 @@
 package go_moonraker
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/creachadair/jrpc2"
 	"github.com/stretchr/testify/assert"
@@ -104,3 +105,24 @@ func TestMoonClient_GcodeStore(t *testing.T) {
 	assert.NotNil(store)
 	fmt.Printf("%#v\n", store)
 }
+
+func TestGcodeMetadata_Unmarshal(t *testing.T) {
+	assert := assert.New(t)
+	// Moonraker reports times and temperatures as floats, and the job id as a
+	// hex string once the file has been printed.
+	var meta GcodeMetadata
+	err := json.Unmarshal([]byte(`{"print_start_time": 1700000000.25, "job_id": "00000A", "estimated_time": 2256.0,
+		"first_layer_bed_temp": 60.0, "first_layer_extr_temp": 215.5, "size": 1024}`), &meta)
+	assert.NoError(err)
+	if assert.NotNil(meta.JobId) && assert.NotNil(meta.PrintStartTime) {
+		assert.Equal("00000A", *meta.JobId)
+		assert.Equal(1700000000.25, *meta.PrintStartTime)
+	}
+	assert.Equal(2256.0, meta.EstimatedTime)
+	assert.Equal(215.5, meta.FirstLayerExtrTemp)
+
+	var unprinted GcodeMetadata
+	assert.NoError(json.Unmarshal([]byte(`{"print_start_time": null, "job_id": null, "estimated_time": 120}`), &unprinted))
+	assert.Nil(unprinted.JobId)
+	assert.Nil(unprinted.PrintStartTime)
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	moonraker "github.com/derek-elliott/go-moonraker"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type env struct {
+	client *moonraker.MoonClient
+	out    *output
+}
+
+type command struct {
+	usage string
+	run   func(e *env, args []string) error
+}
+
+var commands = map[string]command{
+	"info":     {"info", info},
+	"status":   {"status", status},
+	"gcode":    {"gcode <script>...", gcode},
+	"print":    {"print start <file> | pause | resume | cancel", printCmd},
+	"file":     {"file ls [root] | upload [-print] <local> [name] | download [-root r] <path> [local] | rm [-root r] <path> | mv [-root r] <src> <dst>", file},
+	"queue":    {"queue [ls] | add <file>... | rm <job id>... | clear | pause | start | jump <job id>", queue},
+	"history":  {"history [ls] [-limit n] | totals", history},
+	"services": {"services [ls] | start | stop | restart <service>", services},
+	"power":    {"power [ls] | on | off | toggle <device>", power},
+}
+
+var errUsage = errors.New("usage")
+
+func requireArgs(args []string, n int) error {
+	if len(args) < n {
+		return errUsage
+	}
+	return nil
+}
+
+func timestamp(ts float64) string {
+	return moonraker.FormatTimestamp(ts, time.Local)
+}
+
+func info(e *env, args []string) error {
+	printer, err := e.client.Info()
+	if err != nil {
+		return err
+	}
+	server, err := e.client.QueryServerInfo()
+	if err != nil {
+		return err
+	}
+	return e.out.render(map[string]interface{}{"printer": printer, "server": server}, keyValues(
+		"Hostname", printer.Hostname,
+		"State", printer.State,
+		"Klipper", printer.SoftwareVersion,
+		"Moonraker", server.MoonrakerVersion,
+		"API", server.APIVersionString,
+		"Klippy", server.KlippyState,
+		"Warnings", len(server.Warnings),
+	))
+}
+
+func status(e *env, args []string) error {
+	objects, err := e.client.QueryPrinterObjects("webhooks", "print_stats", "virtual_sdcard", "display_status", "extruder", "heater_bed", "toolhead")
+	if err != nil {
+		return err
+	}
+	t := &table{}
+	if objects.Webhooks != nil {
+		t.add("Klippy", objects.Webhooks.State)
+	}
+	if s := objects.PrintStats; s != nil {
+		t.add("State", s.State)
+		t.add("File", s.Filename)
+		t.add("Duration", moonraker.FormatDuration(float64(s.PrintDuration)))
+		t.add("Filament", fmt.Sprintf("%.1f mm", s.FilamentUsed))
+		if s.Message != "" {
+			t.add("Message", s.Message)
+		}
+	}
+	if objects.VirtualSdcard != nil {
+		t.add("Progress", fmt.Sprintf("%.1f%%", objects.VirtualSdcard.Progress*100))
+	}
+	if h := objects.Extruder; h != nil {
+		t.add("Extruder", fmt.Sprintf("%.1f / %.1f °C", h.Temperature, h.Target))
+	}
+	if h := objects.HeaterBed; h != nil {
+		t.add("Bed", fmt.Sprintf("%.1f / %.1f °C", h.Temperature, h.Target))
+	}
+	if th := objects.Toolhead; th != nil && len(th.Position) >= 3 {
+		t.add("Position", fmt.Sprintf("X%.2f Y%.2f Z%.2f", th.Position[0], th.Position[1], th.Position[2]))
+	}
+	return e.out.render(objects, t)
+}
+
+func gcode(e *env, args []string) error {
+	if err := requireArgs(args, 1); err != nil {
+		return err
+	}
+	if err := e.client.RunGcode(strings.Join(args, " ")); err != nil {
+		return err
+	}
+	return e.out.render(map[string]string{"result": "ok"}, nil)
+}
+
+func printCmd(e *env, args []string) error {
+	if err := requireArgs(args, 1); err != nil {
+		return err
+	}
+	var err error
+	switch args[0] {
+	case "start":
+		if err := requireArgs(args, 2); err != nil {
+			return err
+		}
+		err = e.client.Print(args[1])
+	case "pause":
+		err = e.client.PausePrint()
+	case "resume":
+		err = e.client.ResumePrint()
+	case "cancel":
+		err = e.client.CancelPrint()
+	default:
+		return errUsage
+	}
+	if err != nil {
+		return err
+	}
+	return e.out.render(map[string]string{"result": "ok"}, nil)
+}
+
+func file(e *env, args []string) error {
+	if err := requireArgs(args, 1); err != nil {
+		return err
+	}
+	switch args[0] {
+	case "ls":
+		root := "gcodes"
+		if len(args) > 1 {
+			root = args[1]
+		}
+		files, err := e.client.ListFiles(root)
+		if err != nil {
+			return err
+		}
+		sort.Slice(*files, func(i, j int) bool { return (*files)[i].Path < (*files)[j].Path })
+		t := &table{header: []string{"PATH", "SIZE", "MODIFIED"}}
+		for _, f := range *files {
+			t.add(f.Path, f.Size, timestamp(f.Modified))
+		}
+		return e.out.render(files, t)
+	case "upload":
+		fs := flag.NewFlagSet("file upload", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		start := fs.Bool("print", false, "start printing the file after the upload")
+		if err := fs.Parse(args[1:]); err != nil {
+			return errUsage
+		}
+		if err := requireArgs(fs.Args(), 1); err != nil {
+			return err
+		}
+		local := fs.Arg(0)
+		name := filepath.Base(local)
+		if fs.NArg() > 1 {
+			name = fs.Arg(1)
+		}
+		f, err := os.Open(local)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := e.client.UploadFile(name, f, fmt.Sprint(*start)); err != nil {
+			return err
+		}
+		return e.out.render(map[string]interface{}{"result": "ok", "file": name, "print": *start}, nil)
+	case "download":
+		root, args, err := rootFlag("file download", args[1:])
+		if err != nil {
+			return err
+		}
+		if err := requireArgs(args, 1); err != nil {
+			return err
+		}
+		local := path.Base(args[0])
+		if len(args) > 1 {
+			local = args[1]
+		}
+		f, err := os.Create(local)
+		if err != nil {
+			return err
+		}
+		if err := e.client.DownloadFile(path.Join(root, args[0]), f); err != nil {
+			f.Close()
+			os.Remove(local)
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		return e.out.render(map[string]string{"result": "ok", "file": local}, nil)
+	case "rm":
+		root, args, err := rootFlag("file rm", args[1:])
+		if err != nil {
+			return err
+		}
+		if err := requireArgs(args, 1); err != nil {
+			return err
+		}
+		if err := e.client.DeleteFile(path.Join(root, args[0])); err != nil {
+			return err
+		}
+		return e.out.render(map[string]string{"result": "ok"}, nil)
+	case "mv":
+		root, args, err := rootFlag("file mv", args[1:])
+		if err != nil {
+			return err
+		}
+		if err := requireArgs(args, 2); err != nil {
+			return err
+		}
+		if err := e.client.MoveFile(path.Join(root, args[0]), path.Join(root, args[1])); err != nil {
+			return err
+		}
+		return e.out.render(map[string]string{"result": "ok"}, nil)
+	default:
+		return errUsage
+	}
+}
+
+// rootFlag parses the -root flag of file commands taking paths as printed by
+// "file ls", which are relative to a root such as gcodes or config.
+func rootFlag(name string, args []string) (string, []string, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	root := fs.String("root", "gcodes", "root the paths are relative to")
+	if err := fs.Parse(args); err != nil {
+		return "", nil, errUsage
+	}
+	return *root, fs.Args(), nil
+}
+
+func queue(e *env, args []string) error {
+	if len(args) == 0 {
+		args = []string{"ls"}
+	}
+	var items *moonraker.JobQueueItems
+	var err error
+	switch args[0] {
+	case "ls":
+		items, err = e.client.ListJobQueue()
+	case "add":
+		if err := requireArgs(args, 2); err != nil {
+			return err
+		}
+		items, err = e.client.QueueJobs(args[1:])
+	case "rm":
+		if err := requireArgs(args, 2); err != nil {
+			return err
+		}
+		items, err = e.client.DeleteQueueJobs(args[1:])
+	case "clear":
+		items, err = e.client.ClearJobQueue()
+	case "pause":
+		items, err = e.client.PauseJobQueue()
+	case "start":
+		items, err = e.client.StartJobQueue()
+	case "jump":
+		if err := requireArgs(args, 2); err != nil {
+			return err
+		}
+		items, err = e.client.JumpJobQueue(args[1])
+	default:
+		return errUsage
+	}
+	if err != nil {
+		return err
+	}
+	t := &table{header: []string{"JOB ID", "FILE", "ADDED", "WAITING"}}
+	for _, job := range items.QueuedJobs {
+		t.add(job.JobId, job.Filename, timestamp(job.TimeAdded), moonraker.FormatDuration(job.TimeInQueue))
+	}
+	t.rows = append(t.rows, []string{"", "", "", ""}, []string{"State:", string(items.QueueState), "", ""})
+	return e.out.render(items, t)
+}
+
+func history(e *env, args []string) error {
+	if len(args) == 0 {
+		args = []string{"ls"}
+	}
+	switch args[0] {
+	case "ls":
+		fs := flag.NewFlagSet("history ls", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		limit := fs.Int("limit", 20, "number of jobs to list")
+		if err := fs.Parse(args[1:]); err != nil {
+			return errUsage
+		}
+		jobs, err := e.client.JobHistoryList(*limit, 0, 0, 0, "desc")
+		if err != nil {
+			return err
+		}
+		t := &table{header: []string{"JOB ID", "FILE", "STATUS", "STARTED", "DURATION", "FILAMENT"}}
+		for _, job := range jobs.Jobs {
+			t.add(job.JobId, job.Filename, job.Status, timestamp(job.StartTime),
+				moonraker.FormatDuration(job.PrintDuration), fmt.Sprintf("%.0f mm", job.FilamentUsed))
+		}
+		return e.out.render(jobs, t)
+	case "totals":
+		totals, err := e.client.JobHistoryTotals()
+		if err != nil {
+			return err
+		}
+		jt := totals.JobTotals
+		return e.out.render(totals, keyValues(
+			"Jobs", jt.TotalJobs,
+			"Total time", moonraker.FormatDuration(jt.TotalTime),
+			"Print time", moonraker.FormatDuration(jt.TotalPrintTime),
+			"Filament", fmt.Sprintf("%.1f m", jt.TotalFilamentUsed/1000),
+			"Longest job", moonraker.FormatDuration(jt.LongestJob),
+			"Longest print", moonraker.FormatDuration(jt.LongestPrint),
+		))
+	default:
+		return errUsage
+	}
+}
+
+func services(e *env, args []string) error {
+	if len(args) == 0 || args[0] == "ls" {
+		info, err := e.client.MachineInfo()
+		if err != nil {
+			return err
+		}
+		sys := info.SystemInfo
+		t := &table{header: []string{"SERVICE", "ACTIVE", "SUB"}}
+		for _, service := range sys.AvailableServices {
+			state := sys.ServiceState[service]
+			t.add(service, state.ActiveState, state.SubState)
+		}
+		return e.out.render(sys.ServiceState, t)
+	}
+	if err := requireArgs(args, 2); err != nil {
+		return err
+	}
+	var err error
+	switch args[0] {
+	case "start":
+		err = e.client.StartService(args[1])
+	case "stop":
+		err = e.client.StopService(args[1])
+	case "restart":
+		err = e.client.RestartService(args[1])
+	default:
+		return errUsage
+	}
+	if err != nil {
+		return err
+	}
+	return e.out.render(map[string]string{"result": "ok"}, nil)
+}
+
+func power(e *env, args []string) error {
+	if len(args) == 0 || args[0] == "ls" {
+		devices, err := e.client.ListPowerDevices()
+		if err != nil {
+			return err
+		}
+		t := &table{header: []string{"DEVICE", "STATUS", "TYPE", "LOCKED WHILE PRINTING"}}
+		for _, d := range devices {
+			t.add(d.Device, d.Status, d.Type, d.LockedWhilePrinting)
+		}
+		return e.out.render(devices, t)
+	}
+	if err := requireArgs(args, 2); err != nil {
+		return err
+	}
+	var action moonraker.PowerAction
+	switch args[0] {
+	case "on":
+		action = moonraker.PowerActionOn
+	case "off":
+		action = moonraker.PowerActionOff
+	case "toggle":
+		action = moonraker.PowerActionToggle
+	default:
+		return errUsage
+	}
+	status, err := e.client.SetPowerDevice(args[1], action)
+	if err != nil {
+		return err
+	}
+	return e.out.render(map[string]moonraker.PowerStatus{args[1]: status}, keyValues(args[1], status))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+const defaultHost = "localhost:7125"
+
+// config holds the connection settings. Flags take precedence over the
+// MOONCTL_* environment variables, which take precedence over the config
+// file.
+type config struct {
+	Host   string `json:"host"`
+	APIKey string `json:"api_key"`
+	Output string `json:"output"`
+}
+
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "moonctl", "config.json")
+}
+
+// loadConfig reads a JSON config file. A missing file is only an error when
+// the path was given explicitly.
+func loadConfig(path string, explicit bool) (config, error) {
+	var cfg config
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	err = json.Unmarshal(data, &cfg)
+	return cfg, err
+}
+
+func (c config) override(other config) config {
+	if other.Host != "" {
+		c.Host = other.Host
+	}
+	if other.APIKey != "" {
+		c.APIKey = other.APIKey
+	}
+	if other.Output != "" {
+		c.Output = other.Output
+	}
+	return c
+}
+
+func resolveConfig(file config, getenv func(string) string, flags config) config {
+	cfg := config{Host: defaultHost, Output: "table"}
+	cfg = cfg.override(file)
+	cfg = cfg.override(config{
+		Host:   getenv("MOONCTL_HOST"),
+		APIKey: getenv("MOONCTL_API_KEY"),
+		Output: getenv("MOONCTL_OUTPUT"),
+	})
+	return cfg.override(flags)
+}
@@ -0,0 +1,109 @@
+// Command moonctl controls a Moonraker instance from the command line.
+//
+//	moonctl [-host host:port] [-api-key key] [-config file] [-o table|json] <command> [args]
+//
+// Connection settings can also come from the MOONCTL_HOST, MOONCTL_API_KEY
+// and MOONCTL_OUTPUT environment variables, or from a JSON config file with
+// "host", "api_key" and "output" keys, by default moonctl/config.json in the
+// user's config directory.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	moonraker "github.com/derek-elliott/go-moonraker"
+	"io"
+	"os"
+	"sort"
+)
+
+type dialer func(cfg config) (*moonraker.MoonClient, error)
+
+func dial(cfg config) (*moonraker.MoonClient, error) {
+	if cfg.APIKey != "" {
+		return moonraker.NewClientWithAPIKey(cfg.Host, "/websocket", cfg.APIKey, nil)
+	}
+	return moonraker.NewClient(cfg.Host, "/websocket", nil)
+}
+
+func usage(w io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintln(w, "usage: moonctl [flags] <command> [args]")
+	fmt.Fprintln(w, "\nflags:")
+	fs.SetOutput(w)
+	fs.PrintDefaults()
+	fmt.Fprintln(w, "\ncommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s\n", commands[name].usage)
+	}
+}
+
+func run(args []string, stdout, stderr io.Writer, getenv func(string) string, dial dialer) int {
+	fs := flag.NewFlagSet("moonctl", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	var flags config
+	fs.StringVar(&flags.Host, "host", "", "Moonraker host and port (default "+defaultHost+")")
+	fs.StringVar(&flags.APIKey, "api-key", "", "Moonraker API key")
+	fs.StringVar(&flags.Output, "o", "", "output format: table or json (default table)")
+	configPath := fs.String("config", "", "config file (default "+defaultConfigPath()+")")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			usage(stdout, fs)
+			return 0
+		}
+		fmt.Fprintln(stderr, err)
+		usage(stderr, fs)
+		return 2
+	}
+	if fs.NArg() == 0 {
+		usage(stderr, fs)
+		return 2
+	}
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command: %s\n", fs.Arg(0))
+		usage(stderr, fs)
+		return 2
+	}
+
+	path, explicit := *configPath, *configPath != ""
+	if !explicit {
+		path = defaultConfigPath()
+	}
+	file, err := loadConfig(path, explicit)
+	if err != nil {
+		fmt.Fprintf(stderr, "reading config: %v\n", err)
+		return 1
+	}
+	cfg := resolveConfig(file, getenv, flags)
+	if cfg.Output != "table" && cfg.Output != "json" {
+		fmt.Fprintf(stderr, "unknown output format: %s\n", cfg.Output)
+		return 2
+	}
+
+	client, err := dial(cfg)
+	if err != nil {
+		fmt.Fprintf(stderr, "connecting to %s: %v\n", cfg.Host, err)
+		return 1
+	}
+	defer client.Close()
+	err = cmd.run(&env{client: client, out: &output{w: stdout, format: cfg.Output}}, fs.Args()[1:])
+	if errors.Is(err, errUsage) {
+		fmt.Fprintf(stderr, "usage: moonctl %s\n", cmd.usage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr, os.Getenv, dial))
+}
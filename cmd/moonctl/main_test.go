@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/creachadair/jrpc2"
+	"github.com/creachadair/jrpc2/channel"
+	"github.com/creachadair/jrpc2/handler"
+	moonraker "github.com/derek-elliott/go-moonraker"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type fakePrinter struct {
+	calls []string
+	cfg   config
+}
+
+func (p *fakePrinter) dialer(t *testing.T, httpHost string) dialer {
+	return func(cfg config) (*moonraker.MoonClient, error) {
+		p.cfg = cfg
+		record := func(call string) { p.calls = append(p.calls, call) }
+		cch, sch := channel.Direct()
+		srv := jrpc2.NewServer(handler.Map{
+			"printer.objects.query": handler.New(func(ctx context.Context, params moonraker.QueryObjectParams) (json.RawMessage, error) {
+				return json.RawMessage(`{"eventtime": 1, "status": {
+					"print_stats": {"state": "printing", "filename": "benchy.gcode", "print_duration": 754, "filament_used": 1523.4},
+					"virtual_sdcard": {"progress": 0.4213},
+					"extruder": {"temperature": 214.96, "target": 215}
+				}}`), nil
+			}),
+			"printer.print.start": handler.New(func(ctx context.Context, params map[string]string) (string, error) {
+				record("print " + params["filename"])
+				return "ok", nil
+			}),
+			"server.files.list": handler.New(func(ctx context.Context, params map[string]string) ([]moonraker.MoonrakerFile, error) {
+				record("list " + params["root"])
+				return []moonraker.MoonrakerFile{{Path: "voron/cube.gcode", Size: 2048}, {Path: "benchy.gcode", Size: 1024}}, nil
+			}),
+			"server.files.move": handler.New(func(ctx context.Context, params map[string]string) (json.RawMessage, error) {
+				record("move " + params["source"] + " " + params["dest"])
+				return json.RawMessage(`{}`), nil
+			}),
+			"machine.device_power.post_device": handler.New(func(ctx context.Context, params map[string]string) (map[string]string, error) {
+				record("power " + params["device"] + " " + params["action"])
+				return map[string]string{params["device"]: "on"}, nil
+			}),
+		}, nil).Start(sch)
+		t.Cleanup(func() {
+			srv.Stop()
+			srv.Wait()
+		})
+		client := moonraker.NewClientFromChannel(cch, httpHost, nil)
+		client.APIKey = cfg.APIKey
+		return client, nil
+	}
+}
+
+func runCmd(t *testing.T, p *fakePrinter, httpHost string, getenv func(string) string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	if getenv == nil {
+		getenv = func(string) string { return "" }
+	}
+	args = append([]string{"-config", filepath.Join(t.TempDir(), "none.json")}, args...)
+	os.WriteFile(args[1], []byte(`{}`), 0o600)
+	code := run(args, &stdout, &stderr, getenv, p.dialer(t, httpHost))
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRun_Commands(t *testing.T) {
+	assert := assert.New(t)
+	p := &fakePrinter{}
+
+	code, out, _ := runCmd(t, p, "", nil, "status")
+	assert.Equal(0, code)
+	assert.Contains(out, "State     printing\n")
+	assert.Contains(out, "Progress  42.1%\n")
+	assert.Contains(out, "Duration  12m34s\n")
+	assert.Contains(out, "Extruder  215.0 / 215.0 °C\n")
+
+	code, out, _ = runCmd(t, p, "", nil, "-o", "json", "file", "ls")
+	assert.Equal(0, code)
+	var files []moonraker.MoonrakerFile
+	assert.NoError(json.Unmarshal([]byte(out), &files))
+	assert.Equal("benchy.gcode", files[0].Path)
+
+	code, out, _ = runCmd(t, p, "", nil, "file", "ls", "config")
+	assert.Equal(0, code)
+	assert.Regexp(`^PATH +SIZE +MODIFIED\nbenchy.gcode +1024 +\nvoron/cube.gcode +2048`, out)
+
+	code, out, _ = runCmd(t, p, "", nil, "print", "start", "benchy.gcode")
+	assert.Equal(0, code)
+	assert.Equal("ok\n", out)
+	runCmd(t, p, "", nil, "file", "mv", "a.gcode", "voron/b.gcode")
+	runCmd(t, p, "", nil, "file", "mv", "-root", "config", "printer.cfg", "old/printer.cfg")
+	code, out, _ = runCmd(t, p, "", nil, "power", "on", "printer")
+	assert.Equal(0, code)
+	assert.Equal("printer  on\n", out)
+
+	assert.Equal([]string{"list gcodes", "list config", "print benchy.gcode", "move gcodes/a.gcode gcodes/voron/b.gcode",
+		"move config/printer.cfg config/old/printer.cfg", "power printer on"}, p.calls)
+
+	code, _, errOut := runCmd(t, p, "", nil, "print", "start")
+	assert.Equal(2, code)
+	assert.Contains(errOut, "usage: moonctl print start <file>")
+	code, _, errOut = runCmd(t, p, "", nil, "launch")
+	assert.Equal(2, code)
+	assert.Contains(errOut, "unknown command: launch")
+}
+
+func TestRun_Upload(t *testing.T) {
+	assert := assert.New(t)
+	var apiKey, filename, startPrint string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiKey = r.Header.Get("X-Api-Key")
+		f, header, err := r.FormFile("file")
+		if assert.NoError(err) {
+			io.Copy(io.Discard, f)
+			filename = header.Filename
+		}
+		startPrint = r.FormValue("print")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	local := filepath.Join(t.TempDir(), "cube.gcode")
+	os.WriteFile(local, []byte("G28\n"), 0o600)
+	p := &fakePrinter{}
+	code, _, errOut := runCmd(t, p, strings.TrimPrefix(srv.URL, "http://"), nil, "-api-key", "secret", "file", "upload", "-print", local, "renamed.gcode")
+	assert.Equal(0, code, errOut)
+	assert.Equal("secret", apiKey)
+	assert.Equal("renamed.gcode", filename)
+	assert.Equal("true", startPrint)
+}
+
+func TestResolveConfig(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{"host": "voron.local:7125", "api_key": "from-file", "output": "json"}`), 0o600)
+	file, err := loadConfig(path, true)
+	assert.NoError(err)
+
+	env := map[string]string{"MOONCTL_API_KEY": "from-env"}
+	cfg := resolveConfig(file, func(key string) string { return env[key] }, config{Output: "table"})
+	assert.Equal(config{Host: "voron.local:7125", APIKey: "from-env", Output: "table"}, cfg)
+	assert.Equal(config{Host: defaultHost, Output: "table"}, resolveConfig(config{}, func(string) string { return "" }, config{}))
+
+	_, err = loadConfig(filepath.Join(t.TempDir(), "missing.json"), false)
+	assert.NoError(err)
+	_, err = loadConfig(filepath.Join(t.TempDir(), "missing.json"), true)
+	assert.Error(err)
+}
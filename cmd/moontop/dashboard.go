@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	moonraker "github.com/derek-elliott/go-moonraker"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	historyLen = 120
+	consoleLen = 200
+)
+
+var statusObjects = []string{"webhooks", "print_stats", "virtual_sdcard", "display_status", "toolhead", "gcode_move"}
+
+// Klipper object types shown in the heater and fan sections. Additional
+// extruders (extruder1, ...) are matched by prefix.
+var heaterTypes = []string{"extruder", "heater_bed", "heater_generic", "temperature_sensor", "temperature_fan"}
+var fanTypes = []string{"fan", "heater_fan", "controller_fan", "fan_generic", "temperature_fan"}
+
+func objectType(object string) string {
+	kind, _, _ := strings.Cut(object, " ")
+	if strings.HasPrefix(kind, "extruder") {
+		return "extruder"
+	}
+	return kind
+}
+
+func isType(object string, types []string) bool {
+	kind := objectType(object)
+	for _, t := range types {
+		if kind == t {
+			return true
+		}
+	}
+	return false
+}
+
+// displayName drops the type prefix of objects like "heater_generic chamber".
+func displayName(object string) string {
+	if _, name, found := strings.Cut(object, " "); found {
+		return name
+	}
+	return object
+}
+
+// subscriptionObjects picks the objects the dashboard needs out of everything
+// the printer reports.
+func subscriptionObjects(available []string) (objects, heaters, fans []string) {
+	objects = append(objects, statusObjects...)
+	for _, object := range available {
+		heater, fan := isType(object, heaterTypes), isType(object, fanTypes)
+		if heater {
+			heaters = append(heaters, object)
+		}
+		if fan {
+			fans = append(fans, object)
+		}
+		if heater || fan {
+			objects = append(objects, object)
+		}
+	}
+	sort.Strings(heaters)
+	sort.Strings(fans)
+	return objects, heaters, fans
+}
+
+// sensorStatus holds the fields shared by heater, sensor and fan objects.
+type sensorStatus struct {
+	Temperature *float64 `json:"temperature"`
+	Target      *float64 `json:"target"`
+	Power       *float64 `json:"power"`
+	Speed       *float64 `json:"speed"`
+}
+
+type dashboard struct {
+	host    string
+	heaters []string
+	fans    []string
+
+	mu      sync.Mutex
+	objects moonraker.PrinterObjects
+	sensors map[string]*sensorStatus
+	history map[string][]float64
+	console []string
+	queue   *moonraker.JobQueueItems
+	message string
+	confirm string
+}
+
+func newDashboard(host string, heaters, fans []string) *dashboard {
+	return &dashboard{
+		host:    host,
+		heaters: heaters,
+		fans:    fans,
+		sensors: make(map[string]*sensorStatus),
+		history: make(map[string][]float64),
+	}
+}
+
+func (d *dashboard) apply(update moonraker.StatusUpdate) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	update.ApplyTo(&d.objects)
+	for object, raw := range update {
+		if !isType(object, heaterTypes) && !isType(object, fanTypes) {
+			continue
+		}
+		status, ok := d.sensors[object]
+		if !ok {
+			status = &sensorStatus{}
+			d.sensors[object] = status
+		}
+		// Decoding into the existing status keeps fields the update omits.
+		json.Unmarshal(raw, status)
+	}
+}
+
+// sample records the current temperatures for the sparklines.
+func (d *dashboard) sample() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, heater := range d.heaters {
+		status, ok := d.sensors[heater]
+		if !ok || status.Temperature == nil {
+			continue
+		}
+		h := append(d.history[heater], *status.Temperature)
+		if len(h) > historyLen {
+			h = h[len(h)-historyLen:]
+		}
+		d.history[heater] = h
+	}
+}
+
+func (d *dashboard) addConsole(lines ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, line := range lines {
+		d.console = append(d.console, strings.Split(strings.TrimRight(line, "\n"), "\n")...)
+	}
+	if len(d.console) > consoleLen {
+		d.console = d.console[len(d.console)-consoleLen:]
+	}
+}
+
+func (d *dashboard) setQueue(queue *moonraker.JobQueueItems) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.queue = queue
+}
+
+// queueChanged applies a job queue notification. The queue is only included
+// when its contents changed, otherwise just the state is updated.
+func (d *dashboard) queueChanged(event *moonraker.JobQueueEvent) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.queue == nil {
+		d.queue = &moonraker.JobQueueItems{}
+	}
+	if event.UpdatedQueue != nil {
+		d.queue.QueuedJobs = event.UpdatedQueue
+	}
+	d.queue.QueueState = event.QueueState
+}
+
+func (d *dashboard) setMessage(msg string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.message = msg
+}
+
+func (d *dashboard) progress() float64 {
+	if d.objects.DisplayStatus != nil && d.objects.DisplayStatus.Progress > 0 {
+		return float64(d.objects.DisplayStatus.Progress)
+	}
+	if d.objects.VirtualSdcard != nil {
+		return float64(d.objects.VirtualSdcard.Progress)
+	}
+	return 0
+}
+
+// eta extrapolates the remaining print time from the time spent printing so
+// far and the file progress, the way the web frontends' file estimate does.
+func eta(printDuration, progress float64) (time.Duration, bool) {
+	if progress <= 0 || progress >= 1 || printDuration <= 0 {
+		return 0, false
+	}
+	remaining := printDuration/progress - printDuration
+	return time.Duration(remaining * float64(time.Second)).Round(time.Second), true
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	moonraker "github.com/derek-elliott/go-moonraker"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSubscriptionObjects(t *testing.T) {
+	objects, heaters, fans := subscriptionObjects([]string{
+		"gcode_move", "heater_bed", "extruder1", "extruder", "fan", "heater_fan hotend_fan",
+		"temperature_fan chamber", "temperature_sensor mcu", "bed_mesh",
+	})
+	assert.Equal(t, []string{"extruder", "extruder1", "heater_bed", "temperature_fan chamber", "temperature_sensor mcu"}, heaters)
+	assert.Equal(t, []string{"fan", "heater_fan hotend_fan", "temperature_fan chamber"}, fans)
+	assert.NotContains(t, objects, "bed_mesh")
+	assert.Contains(t, objects, "print_stats")
+	assert.Contains(t, objects, "heater_fan hotend_fan")
+}
+
+func update(t *testing.T, raw string) moonraker.StatusUpdate {
+	var u moonraker.StatusUpdate
+	if err := json.Unmarshal([]byte(raw), &u); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestDashboard_Render(t *testing.T) {
+	assert := assert.New(t)
+	d := newDashboard("voron.local", []string{"extruder", "heater_bed"}, []string{"fan"})
+	d.apply(update(t, `{
+		"webhooks": {"state": "ready"},
+		"print_stats": {"state": "printing", "filename": "benchy.gcode", "print_duration": 600},
+		"virtual_sdcard": {"progress": 0.25},
+		"toolhead": {"position": [10, 20.5, 1.2, 0], "homed_axes": "xyz"},
+		"gcode_move": {"speed_factor": 1, "extrude_factor": 0.95},
+		"extruder": {"temperature": 200, "target": 215, "power": 0.5},
+		"heater_bed": {"temperature": 60, "target": 60, "power": 0.2},
+		"fan": {"speed": 0.8}
+	}`))
+	d.sample()
+	// A partial update keeps the fields it leaves out.
+	d.apply(update(t, `{"extruder": {"temperature": 215}}`))
+	d.sample()
+	d.addConsole("ok", "// probe at 10,10 is z=1.2\n// done\n")
+	d.setQueue(&moonraker.JobQueueItems{QueuedJobs: []moonraker.JobQueueItem{{Filename: "cube.gcode", TimeInQueue: 90}}, QueueState: "ready"})
+
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	lines := d.render(100, 30, now)
+	assert.Len(lines, 30)
+	screen := strings.Join(lines, "\n")
+	assert.Contains(screen, "voron.local  Klippy: ready  State: printing  12:00:00")
+	assert.Contains(screen, "File: benchy.gcode")
+	assert.Contains(screen, "25.0%  Elapsed 10m00s  ETA 30m00s (12:30)")
+	assert.Contains(screen, "Position X10.00 Y20.50 Z1.20  Homed XYZ  Speed 100%  Flow 95%")
+	assert.Regexp(`extruder +215\.0 +215\.0 +50%  ▁█`, screen)
+	assert.Contains(screen, "Fans: fan 80%")
+	assert.Contains(screen, "1. cube.gcode (waiting 1m30s)")
+	assert.Contains(screen, "// done")
+	assert.True(strings.HasPrefix(lines[29], "[p]ause [r]esume [c]ancel [q]uit"))
+	for _, line := range lines {
+		assert.Equal(100, len([]rune(line)))
+	}
+
+	// The console is cut from the top when the screen is short.
+	lines = d.render(40, 17, now)
+	assert.Len(lines, 17)
+	assert.Equal("// probe at 10,10 is z=1.2", strings.TrimSpace(lines[14]))
+	assert.Equal("// done", strings.TrimSpace(lines[15]))
+}
+
+func TestETA(t *testing.T) {
+	remaining, ok := eta(600, 0.25)
+	assert.True(t, ok)
+	assert.Equal(t, 30*time.Minute, remaining)
+	_, ok = eta(600, 0)
+	assert.False(t, ok)
+	_, ok = eta(600, 1)
+	assert.False(t, ok)
+}
+
+func TestSparkline(t *testing.T) {
+	assert.Equal(t, "▁▄█", sparkline([]float64{0, 50, 100}, 10))
+	assert.Equal(t, "▁█", sparkline([]float64{0, 50, 100}, 2))
+	assert.Equal(t, "▁▁", sparkline([]float64{5, 5}, 10))
+}
+
+type fakeController struct {
+	calls []string
+	err   error
+}
+
+func (c *fakeController) PausePrint() error  { c.calls = append(c.calls, "pause"); return c.err }
+func (c *fakeController) ResumePrint() error { c.calls = append(c.calls, "resume"); return c.err }
+func (c *fakeController) CancelPrint() error { c.calls = append(c.calls, "cancel"); return c.err }
+
+func TestDashboard_HandleKey(t *testing.T) {
+	assert := assert.New(t)
+	d := newDashboard("voron.local", nil, nil)
+	c := &fakeController{}
+
+	assert.False(d.handleKey('p', c))
+	assert.Equal("Pause sent", d.message)
+	assert.False(d.handleKey('c', c))
+	assert.Contains(d.render(80, 5, time.Now())[4], "Cancel the print? [y/N]")
+	assert.False(d.handleKey('n', c))
+	assert.Equal("Cancel aborted", d.message)
+	assert.False(d.handleKey('c', c))
+	assert.False(d.handleKey('y', c))
+	assert.Equal([]string{"pause", "cancel"}, c.calls)
+
+	c.err = errors.New("klippy not ready")
+	d.handleKey('r', c)
+	assert.Equal("Resume failed: klippy not ready", d.message)
+	assert.True(d.handleKey('q', c))
+	assert.True(d.handleKey(3, c))
+}
@@ -0,0 +1,49 @@
+package main
+
+import "fmt"
+
+// controller is the part of the client the key bindings drive.
+type controller interface {
+	PausePrint() error
+	ResumePrint() error
+	CancelPrint() error
+}
+
+// handleKey acts on a key press and reports whether moontop should exit.
+// Cancelling asks for confirmation first.
+func (d *dashboard) handleKey(key byte, c controller) bool {
+	d.mu.Lock()
+	confirm := d.confirm
+	d.confirm = ""
+	d.mu.Unlock()
+	if confirm != "" {
+		if key == 'y' || key == 'Y' {
+			d.run("Cancel", c.CancelPrint)
+		} else {
+			d.setMessage("Cancel aborted")
+		}
+		return false
+	}
+
+	switch key {
+	case 'q', 'Q', 3: // 3 is Ctrl-C in raw mode.
+		return true
+	case 'p', 'P':
+		d.run("Pause", c.PausePrint)
+	case 'r', 'R':
+		d.run("Resume", c.ResumePrint)
+	case 'c', 'C':
+		d.mu.Lock()
+		d.confirm = "Cancel"
+		d.mu.Unlock()
+	}
+	return false
+}
+
+func (d *dashboard) run(action string, fn func() error) {
+	if err := fn(); err != nil {
+		d.setMessage(fmt.Sprintf("%s failed: %v", action, err))
+		return
+	}
+	d.setMessage(action + " sent")
+}
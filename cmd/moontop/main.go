@@ -0,0 +1,135 @@
+// Command moontop shows a live dashboard of a Moonraker printer in the
+// terminal: temperatures with history, print progress and ETA, toolhead
+// position, fans, the job queue and the G-code console.
+//
+//	moontop [-host host:port] [-api-key key]
+//
+// The host and API key default to the MOONCTL_HOST and MOONCTL_API_KEY
+// environment variables shared with moonctl. Press p to pause, r to resume,
+// c to cancel the print and q to quit.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/creachadair/jrpc2"
+	moonraker "github.com/derek-elliott/go-moonraker"
+	"golang.org/x/term"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultHost = "localhost:7125"
+
+func connect(host, apiKey string) (*moonraker.MoonClient, *dashboard, error) {
+	var client *moonraker.MoonClient
+	var err error
+	if apiKey != "" {
+		client, err = moonraker.NewClientWithAPIKey(host, "/websocket", apiKey, nil)
+	} else {
+		client, err = moonraker.NewClient(host, "/websocket", nil)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	available, err := client.ListObjects()
+	if err != nil {
+		client.Close()
+		return nil, nil, err
+	}
+	objects, heaters, fans := subscriptionObjects(*available)
+	d := newDashboard(host, heaters, fans)
+
+	client.OnStatusUpdate(d.apply)
+	client.OnNotification("notify_gcode_response", func(req *jrpc2.Request) {
+		var line string
+		if err := moonraker.UnmarshalNotification(req, &line); err == nil {
+			d.addConsole(line)
+		}
+	})
+	client.OnJobQueueChanged(d.queueChanged)
+	initial, err := client.SubscribeObjects(objects...)
+	if err != nil {
+		client.Close()
+		return nil, nil, err
+	}
+	d.apply(initial)
+	d.sample()
+
+	// The console and queue are nice to have, so errors here are not fatal.
+	if store, err := client.GcodeStore(50); err == nil {
+		for _, entry := range store.GcodeStore {
+			d.addConsole(entry.Message)
+		}
+	}
+	if queue, err := client.ListJobQueue(); err == nil {
+		d.setQueue(queue)
+	}
+	return client, d, nil
+}
+
+func draw(d *dashboard) {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		width, height = 80, 24
+	}
+	lines := d.render(width, height, time.Now())
+	fmt.Print("\x1b[H" + strings.Join(lines, "\x1b[K\r\n") + "\x1b[J")
+}
+
+func main() {
+	host := flag.String("host", os.Getenv("MOONCTL_HOST"), "Moonraker host and port (default "+defaultHost+")")
+	apiKey := flag.String("api-key", os.Getenv("MOONCTL_API_KEY"), "Moonraker API key")
+	flag.Parse()
+	if *host == "" {
+		*host = defaultHost
+	}
+
+	client, d, err := connect(*host, *apiKey)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "connecting to %s: %v\n", *host, err)
+		os.Exit(1)
+	}
+	defer client.Close()
+
+	state, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "moontop needs a terminal: %v\n", err)
+		os.Exit(1)
+	}
+	defer term.Restore(int(os.Stdin.Fd()), state)
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\x1b[?1049l")
+
+	keys := make(chan byte)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := os.Stdin.Read(buf); err != nil {
+				close(keys)
+				return
+			}
+			keys <- buf[0]
+		}
+	}()
+
+	samples := time.NewTicker(time.Second)
+	defer samples.Stop()
+	frames := time.NewTicker(250 * time.Millisecond)
+	defer frames.Stop()
+	draw(d)
+	for {
+		select {
+		case key, ok := <-keys:
+			if !ok || d.handleKey(key, client) {
+				return
+			}
+			draw(d)
+		case <-samples.C:
+			d.sample()
+		case <-frames.C:
+			draw(d)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	moonraker "github.com/derek-elliott/go-moonraker"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline draws the last width values scaled between their minimum and
+// maximum.
+func sparkline(values []float64, width int) string {
+	if width <= 0 || len(values) == 0 {
+		return ""
+	}
+	if len(values) > width {
+		values = values[len(values)-width:]
+	}
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, v := range values {
+		lo, hi = math.Min(lo, v), math.Max(hi, v)
+	}
+	var b strings.Builder
+	for _, v := range values {
+		i := 0
+		if hi > lo {
+			i = int((v - lo) / (hi - lo) * float64(len(sparkBlocks)-1))
+		}
+		b.WriteRune(sparkBlocks[i])
+	}
+	return b.String()
+}
+
+func progressBar(progress float64, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	filled := int(math.Round(progress * float64(width)))
+	if filled > width {
+		filled = width
+	}
+	return strings.Repeat("█", filled) + strings.Repeat("░", width-filled)
+}
+
+// fit cuts or pads a line to exactly width columns.
+func fit(line string, width int) string {
+	n := utf8.RuneCountInString(line)
+	if n > width {
+		return string([]rune(line)[:width])
+	}
+	return line + strings.Repeat(" ", width-n)
+}
+
+func percent(v *float64) string {
+	if v == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%.0f%%", *v*100)
+}
+
+func celsius(v *float64) string {
+	if v == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f", *v)
+}
+
+// render lays the dashboard out in height lines of width columns. The console
+// gets whatever space the other sections leave.
+func (d *dashboard) render(width, height int, now time.Time) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var top []string
+	add := func(format string, args ...interface{}) {
+		top = append(top, fmt.Sprintf(format, args...))
+	}
+
+	klippy, state, filename, message := "-", "-", "", ""
+	var printDuration, filament float64
+	if d.objects.Webhooks != nil {
+		klippy = d.objects.Webhooks.State
+	}
+	if s := d.objects.PrintStats; s != nil {
+		state, filename, message = s.State, s.Filename, s.Message
+		printDuration, filament = float64(s.PrintDuration), float64(s.FilamentUsed)
+	}
+	add("moontop  %s  Klippy: %s  State: %s  %s", d.host, klippy, state, now.Format("15:04:05"))
+	if filename != "" {
+		add("File: %s", filename)
+	}
+	if message != "" {
+		add("Message: %s", message)
+	}
+	progress := d.progress()
+	line := fmt.Sprintf("Progress [%s] %5.1f%%  Elapsed %s", progressBar(progress, 30), progress*100, moonraker.FormatDuration(printDuration))
+	if remaining, ok := eta(printDuration, progress); ok {
+		line += fmt.Sprintf("  ETA %s (%s)", moonraker.FormatDuration(remaining.Seconds()), now.Add(remaining).Format("15:04"))
+	}
+	add("%s  Filament %.2f m", line, filament/1000)
+	if th := d.objects.Toolhead; th != nil && len(th.Position) >= 3 {
+		line = fmt.Sprintf("Position X%.2f Y%.2f Z%.2f  Homed %s", th.Position[0], th.Position[1], th.Position[2], strings.ToUpper(th.HomedAxes))
+		if gm := d.objects.GcodeMove; gm != nil {
+			line += fmt.Sprintf("  Speed %.0f%%  Flow %.0f%%", gm.SpeedFactor*100, gm.ExtrudeFactor*100)
+		}
+		add("%s", line)
+	}
+
+	add("")
+	add("%-20s %7s %7s %6s  %s", "HEATER", "TEMP", "TARGET", "POWER", "HISTORY")
+	sparkWidth := width - 45
+	for _, heater := range d.heaters {
+		s, ok := d.sensors[heater]
+		if !ok {
+			continue
+		}
+		add("%-20s %7s %7s %6s  %s", displayName(heater), celsius(s.Temperature), celsius(s.Target), percent(s.Power),
+			sparkline(d.history[heater], sparkWidth))
+	}
+	if len(d.fans) > 0 {
+		var fans []string
+		for _, fan := range d.fans {
+			if s, ok := d.sensors[fan]; ok {
+				fans = append(fans, fmt.Sprintf("%s %s", displayName(fan), percent(s.Speed)))
+			}
+		}
+		add("Fans: %s", strings.Join(fans, "  "))
+	}
+
+	if d.queue != nil {
+		add("")
+		add("QUEUE (%s)", d.queue.QueueState)
+		for i, job := range d.queue.QueuedJobs {
+			if i == 5 {
+				add("  ... %d more", len(d.queue.QueuedJobs)-i)
+				break
+			}
+			add("  %d. %s (waiting %s)", i+1, job.Filename, moonraker.FormatDuration(job.TimeInQueue))
+		}
+	}
+	add("")
+	add("CONSOLE")
+
+	footer := "[p]ause [r]esume [c]ancel [q]uit"
+	if d.confirm != "" {
+		footer = fmt.Sprintf("%s the print? [y/N]", d.confirm)
+	} else if d.message != "" {
+		footer += "  " + d.message
+	}
+
+	lines := top
+	if len(lines) > height-1 {
+		lines = lines[:height-1]
+	}
+	if space := height - 1 - len(lines); space > 0 {
+		console := d.console
+		if len(console) > space {
+			console = console[len(console)-space:]
+		}
+		lines = append(lines, console...)
+		for len(lines) < height-1 {
+			lines = append(lines, "")
+		}
+	}
+	lines = append(lines, footer)
+	for i := range lines {
+		lines[i] = fit(lines[i], width)
+	}
+	return lines
+}
@@ -0,0 +1,269 @@
+package go_moonraker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Database struct {
+	client *MoonClient
+}
+
+func (c *MoonClient) Database() *Database {
+	return &Database{client: c}
+}
+
+type DatabaseItem struct {
+	Namespace string          `json:"namespace"`
+	Key       interface{}     `json:"key,omitempty"`
+	Value     json.RawMessage `json:"value,omitempty"`
+}
+
+func (d *Database) Namespaces() ([]string, error) {
+	ctx := context.Background()
+	var resp struct {
+		Namespaces []string `json:"namespaces"`
+	}
+	if err := d.client.Conn.CallResult(ctx, "server.database.list", nil, &resp); err != nil {
+		return nil, err
+	}
+	sort.Strings(resp.Namespaces)
+	return resp.Namespaces, nil
+}
+
+func (d *Database) Namespace(name string) *Namespace {
+	return &Namespace{db: d, Name: name}
+}
+
+type Namespace struct {
+	db   *Database
+	Name string
+}
+
+// Keys are dotted paths into the namespace, so "general.theme" addresses the
+// theme field of the general object. Use KeyPath when a segment itself
+// contains a dot.
+type Key interface{}
+
+func KeyPath(segments ...string) Key {
+	return segments
+}
+
+func keyString(key Key) string {
+	switch k := key.(type) {
+	case []string:
+		return strings.Join(k, ".")
+	default:
+		return fmt.Sprint(k)
+	}
+}
+
+func (n *Namespace) call(method string, key Key, value interface{}) (json.RawMessage, error) {
+	ctx := context.Background()
+	params := struct {
+		Namespace string      `json:"namespace"`
+		Key       Key         `json:"key,omitempty"`
+		Value     interface{} `json:"value,omitempty"`
+	}{n.Name, key, value}
+	var resp DatabaseItem
+	if err := n.db.client.Conn.CallResult(ctx, method, params, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Value, nil
+}
+
+func (n *Namespace) Get(key Key) (json.RawMessage, error) {
+	return n.call("server.database.get_item", key, nil)
+}
+
+func (n *Namespace) Put(key Key, value interface{}) (json.RawMessage, error) {
+	if key == nil || keyString(key) == "" {
+		return nil, fmt.Errorf("a key is required to write to namespace %s", n.Name)
+	}
+	return n.call("server.database.post_item", key, value)
+}
+
+func (n *Namespace) Delete(key Key) (json.RawMessage, error) {
+	if key == nil || keyString(key) == "" {
+		return nil, fmt.Errorf("a key is required to delete from namespace %s", n.Name)
+	}
+	return n.call("server.database.delete_item", key, nil)
+}
+
+func (n *Namespace) Items() (map[string]json.RawMessage, error) {
+	value, err := n.Get(nil)
+	if err != nil {
+		return nil, err
+	}
+	items := make(map[string]json.RawMessage)
+	if len(value) == 0 {
+		return items, nil
+	}
+	if err := json.Unmarshal(value, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func GetItem[T any](n *Namespace, key Key) (T, error) {
+	var item T
+	value, err := n.Get(key)
+	if err != nil {
+		return item, err
+	}
+	if err := json.Unmarshal(value, &item); err != nil {
+		return item, fmt.Errorf("decoding %s.%s: %w", n.Name, keyString(key), err)
+	}
+	return item, nil
+}
+
+func PutItem[T any](n *Namespace, key Key, value T) (T, error) {
+	var stored T
+	raw, err := n.Put(key, value)
+	if err != nil {
+		return stored, err
+	}
+	if err := json.Unmarshal(raw, &stored); err != nil {
+		return stored, fmt.Errorf("decoding %s.%s: %w", n.Name, keyString(key), err)
+	}
+	return stored, nil
+}
+
+type NamespaceBackup struct {
+	Namespace string                     `json:"namespace"`
+	Created   time.Time                  `json:"created"`
+	Items     map[string]json.RawMessage `json:"items"`
+}
+
+func (n *Namespace) Backup() (*NamespaceBackup, error) {
+	items, err := n.Items()
+	if err != nil {
+		return nil, err
+	}
+	return &NamespaceBackup{Namespace: n.Name, Created: time.Now(), Items: items}, nil
+}
+
+// Restore writes every top level key of the backup back to the namespace.
+// With prune set, keys that are not part of the backup are deleted first so
+// the namespace ends up exactly as it was backed up. A namespace that doesn't
+// exist yet has nothing to prune.
+func (n *Namespace) Restore(backup *NamespaceBackup, prune bool) error {
+	if prune {
+		// Moonraker answers get_item on a missing namespace with an error.
+		existing, err := n.db.Namespaces()
+		if err != nil {
+			return err
+		}
+		current := make(map[string]json.RawMessage)
+		if containsString(existing, n.Name) {
+			if current, err = n.Items(); err != nil {
+				return err
+			}
+		}
+		for key := range current {
+			if _, ok := backup.Items[key]; !ok {
+				if _, err := n.Delete(KeyPath(key)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	keys := make([]string, 0, len(backup.Items))
+	for key := range backup.Items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if _, err := n.Put(KeyPath(key), backup.Items[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ProtectedNamespaces are the namespaces Moonraker keeps for itself and its
+// components. They are read-only or hidden over the API, so they can't be
+// restored.
+var ProtectedNamespaces = []string{"moonraker", "update_manager", "announcements", "webcams", "history", "authorized_users"}
+
+// BackupToFile writes the given namespaces to path. Without namespaces it
+// backs up every namespace except ProtectedNamespaces.
+func (d *Database) BackupToFile(path string, namespaces ...string) error {
+	if len(namespaces) == 0 {
+		all, err := d.Namespaces()
+		if err != nil {
+			return err
+		}
+		for _, name := range all {
+			if !containsString(ProtectedNamespaces, name) {
+				namespaces = append(namespaces, name)
+			}
+		}
+	}
+	backups := make([]*NamespaceBackup, 0, len(namespaces))
+	for _, name := range namespaces {
+		backup, err := d.Namespace(name).Backup()
+		if err != nil {
+			return fmt.Errorf("backing up namespace %s: %w", name, err)
+		}
+		backups = append(backups, backup)
+	}
+	data, err := json.MarshalIndent(backups, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+type RestoreError struct {
+	Errors map[string]error
+}
+
+func (e *RestoreError) Error() string {
+	names := make([]string, 0, len(e.Errors))
+	for name := range e.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	msgs := make([]string, len(names))
+	for i, name := range names {
+		msgs[i] = fmt.Sprintf("%s: %s", name, e.Errors[name])
+	}
+	return fmt.Sprintf("%d namespace(s) not restored: %s", len(names), strings.Join(msgs, "; "))
+}
+
+// RestoreFromFile restores the namespaces in the backup at path, or only the
+// given ones. Namespaces that fail, including ProtectedNamespaces, are skipped
+// and reported per namespace in a *RestoreError once the others are restored.
+func (d *Database) RestoreFromFile(path string, prune bool, namespaces ...string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var backups []*NamespaceBackup
+	if err := json.Unmarshal(data, &backups); err != nil {
+		return err
+	}
+	failed := make(map[string]error)
+	for _, backup := range backups {
+		if len(namespaces) > 0 && !containsString(namespaces, backup.Namespace) {
+			continue
+		}
+		if containsString(ProtectedNamespaces, backup.Namespace) {
+			failed[backup.Namespace] = fmt.Errorf("namespace is reserved by Moonraker")
+			continue
+		}
+		if err := d.Namespace(backup.Namespace).Restore(backup, prune); err != nil {
+			failed[backup.Namespace] = err
+		}
+	}
+	if len(failed) > 0 {
+		return &RestoreError{Errors: failed}
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package go_moonraker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/creachadair/jrpc2"
+	"github.com/creachadair/jrpc2/handler"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type fakeDatabase map[string]map[string]interface{}
+
+type fakeDatabaseParams struct {
+	Namespace string          `json:"namespace"`
+	Key       json.RawMessage `json:"key"`
+	Value     interface{}     `json:"value"`
+}
+
+func (p fakeDatabaseParams) path() []string {
+	var segments []string
+	if err := json.Unmarshal(p.Key, &segments); err == nil {
+		return segments
+	}
+	var key string
+	json.Unmarshal(p.Key, &key)
+	if key == "" {
+		return nil
+	}
+	return strings.Split(key, ".")
+}
+
+func (db fakeDatabase) parent(p fakeDatabaseParams, create bool) (map[string]interface{}, string, error) {
+	node, ok := db[p.Namespace]
+	if !ok {
+		if !create {
+			return nil, "", errors.New("namespace not found")
+		}
+		node = make(map[string]interface{})
+		db[p.Namespace] = node
+	}
+	path := p.path()
+	for _, segment := range path[:len(path)-1] {
+		child, ok := node[segment].(map[string]interface{})
+		if !ok {
+			if !create {
+				return nil, "", errors.New("key not found")
+			}
+			child = make(map[string]interface{})
+			node[segment] = child
+		}
+		node = child
+	}
+	return node, path[len(path)-1], nil
+}
+
+func (db fakeDatabase) methods() handler.Map {
+	return handler.Map{
+		"server.database.list": handler.New(func(ctx context.Context) (map[string][]string, error) {
+			var names []string
+			for name := range db {
+				names = append(names, name)
+			}
+			return map[string][]string{"namespaces": names}, nil
+		}),
+		"server.database.get_item": handler.New(func(ctx context.Context, p fakeDatabaseParams) (DatabaseItem, error) {
+			if _, ok := db[p.Namespace]; !ok {
+				return DatabaseItem{}, jrpc2.Errorf(404, "Namespace '%s' not found", p.Namespace)
+			}
+			if len(p.path()) == 0 {
+				value, _ := json.Marshal(db[p.Namespace])
+				return DatabaseItem{Namespace: p.Namespace, Value: value}, nil
+			}
+			node, key, err := db.parent(p, false)
+			if err != nil {
+				return DatabaseItem{}, err
+			}
+			value, _ := json.Marshal(node[key])
+			return DatabaseItem{Namespace: p.Namespace, Key: p.Key, Value: value}, nil
+		}),
+		"server.database.post_item": handler.New(func(ctx context.Context, p fakeDatabaseParams) (DatabaseItem, error) {
+			node, key, _ := db.parent(p, true)
+			node[key] = p.Value
+			value, _ := json.Marshal(p.Value)
+			return DatabaseItem{Namespace: p.Namespace, Key: p.Key, Value: value}, nil
+		}),
+		"server.database.delete_item": handler.New(func(ctx context.Context, p fakeDatabaseParams) (DatabaseItem, error) {
+			node, key, err := db.parent(p, false)
+			if err != nil {
+				return DatabaseItem{}, err
+			}
+			value, _ := json.Marshal(node[key])
+			delete(node, key)
+			return DatabaseItem{Namespace: p.Namespace, Key: p.Key, Value: value}, nil
+		}),
+	}
+}
+
+type uiSettings struct {
+	Theme    string `json:"theme"`
+	Language string `json:"language"`
+}
+
+func TestDatabase_TypedItems(t *testing.T) {
+	assert := assert.New(t)
+	db := fakeDatabase{"mainsail": {"general": map[string]interface{}{"theme": "dark", "language": "en"}}}
+	c, _ := newTestClient(t, db.methods())
+	ns := c.Database().Namespace("mainsail")
+
+	settings, err := GetItem[uiSettings](ns, "general")
+	assert.NoError(err)
+	assert.Equal(uiSettings{"dark", "en"}, settings)
+
+	theme, err := PutItem(ns, "general.theme", "light")
+	assert.NoError(err)
+	assert.Equal("light", theme)
+	settings, _ = GetItem[uiSettings](ns, "general")
+	assert.Equal("light", settings.Theme)
+
+	_, err = PutItem(ns, KeyPath("presets", "PLA 0.4"), 215)
+	assert.NoError(err)
+	temp, err := GetItem[int](ns, KeyPath("presets", "PLA 0.4"))
+	assert.NoError(err)
+	assert.Equal(215, temp)
+
+	_, err = ns.Put("", 1)
+	assert.Error(err)
+	_, err = GetItem[uiSettings](ns, "missing.key")
+	assert.Error(err)
+}
+
+func TestDatabase_BackupRestore(t *testing.T) {
+	assert := assert.New(t)
+	db := fakeDatabase{
+		"mainsail":  {"general": map[string]interface{}{"theme": "dark"}, "v1.2": true},
+		"fluidd":    {"uiSettings": map[string]interface{}{"general": "x"}},
+		"moonraker": {"database_version": 4},
+	}
+	c, _ := newTestClient(t, db.methods())
+	path := filepath.Join(t.TempDir(), "backup.json")
+	assert.NoError(c.Database().BackupToFile(path))
+	data, err := os.ReadFile(path)
+	assert.NoError(err)
+	assert.NotContains(string(data), "database_version")
+
+	ns := c.Database().Namespace("mainsail")
+	ns.Put("general.theme", "light")
+	ns.Put("added", 1)
+	_, err = ns.Delete(KeyPath("v1.2"))
+	assert.NoError(err)
+
+	assert.NoError(c.Database().RestoreFromFile(path, true, "mainsail"))
+	items, err := ns.Items()
+	assert.NoError(err)
+	assert.Len(items, 2)
+	assert.JSONEq(`{"theme":"dark"}`, string(items["general"]))
+	assert.JSONEq(`true`, string(items["v1.2"]))
+}
+
+func TestDatabase_RestoreIntoEmptyDatabase(t *testing.T) {
+	assert := assert.New(t)
+	db := fakeDatabase{"mainsail": {"general": map[string]interface{}{"theme": "dark"}}}
+	c, _ := newTestClient(t, db.methods())
+	path := filepath.Join(t.TempDir(), "backup.json")
+	assert.NoError(c.Database().BackupToFile(path))
+
+	fresh, _ := newTestClient(t, fakeDatabase{}.methods())
+	assert.NoError(fresh.Database().RestoreFromFile(path, true))
+	items, err := fresh.Database().Namespace("mainsail").Items()
+	assert.NoError(err)
+	assert.JSONEq(`{"theme":"dark"}`, string(items["general"]))
+}
+
+func TestDatabase_RestoreSkipsProtected(t *testing.T) {
+	assert := assert.New(t)
+	db := fakeDatabase{"moonraker": {"database_version": 4}, "mainsail": {"general": "dark"}}
+	c, _ := newTestClient(t, db.methods())
+	path := filepath.Join(t.TempDir(), "backup.json")
+	assert.NoError(c.Database().BackupToFile(path, "moonraker", "mainsail"))
+	c.Database().Namespace("mainsail").Put("general", "light")
+
+	err := c.Database().RestoreFromFile(path, false)
+	var restoreErr *RestoreError
+	if assert.ErrorAs(err, &restoreErr) {
+		assert.Len(restoreErr.Errors, 1)
+		assert.Contains(restoreErr.Errors, "moonraker")
+	}
+	value, err := c.Database().Namespace("mainsail").Get("general")
+	assert.NoError(err)
+	assert.JSONEq(`"dark"`, string(value))
+}
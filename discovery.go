@@ -0,0 +1,229 @@
+package go_moonraker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/creachadair/jrpc2"
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MoonrakerService = "_moonraker._tcp"
+	mdnsAddr         = "224.0.0.251:5353"
+)
+
+type DiscoveredPrinter struct {
+	Instance string            `json:"instance"`
+	Hostname string            `json:"hostname"`
+	Port     int               `json:"port"`
+	Addrs    []net.IP          `json:"addrs"`
+	Text     map[string]string `json:"text"`
+}
+
+// Address returns a host:port suitable for NewClient, preferring a resolved
+// IPv4 address over the advertised .local hostname.
+func (p DiscoveredPrinter) Address() string {
+	host := strings.TrimSuffix(p.Hostname, ".")
+	if len(p.Addrs) > 0 {
+		host = p.Addrs[0].String()
+	}
+	for _, ip := range p.Addrs {
+		if ip.To4() != nil {
+			host = ip.String()
+			break
+		}
+	}
+	return net.JoinHostPort(host, strconv.Itoa(p.Port))
+}
+
+func (p DiscoveredPrinter) Connect(path string, notifyHandler func(*jrpc2.Request)) (*MoonClient, error) {
+	return NewClient(p.Address(), path, notifyHandler)
+}
+
+func (p DiscoveredPrinter) FleetMember() FleetMember {
+	return FleetMember{Name: p.Instance, Host: p.Address(), Path: "websocket"}
+}
+
+type DiscoveryOptions struct {
+	Service   string
+	Domain    string
+	Timeout   time.Duration
+	Interface *net.Interface
+	// Addr overrides the multicast group queries are sent to, e.g. to browse
+	// through a unicast responder.
+	Addr string
+}
+
+// Discover browses for Moonraker instances advertised through zeroconf. The
+// query is sent from an ephemeral port, so responders answer with unicast
+// replies, and Discover collects them until the timeout or ctx ends.
+func Discover(ctx context.Context, opts DiscoveryOptions) ([]DiscoveredPrinter, error) {
+	if opts.Service == "" {
+		opts.Service = MoonrakerService
+	}
+	if opts.Domain == "" {
+		opts.Domain = "local."
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 2 * time.Second
+	}
+	if opts.Addr == "" {
+		opts.Addr = mdnsAddr
+	}
+	service := fmt.Sprintf("%s.%s", opts.Service, opts.Domain)
+
+	dst, err := net.ResolveUDPAddr("udp4", opts.Addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if opts.Interface != nil {
+		if err := ipv4.NewPacketConn(conn).SetMulticastInterface(opts.Interface); err != nil {
+			return nil, err
+		}
+	}
+
+	query, err := buildDiscoveryQuery(service)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.WriteToUDP(query, dst); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(opts.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetReadDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	records := newDiscoveryRecords(service)
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				break
+			}
+			return nil, err
+		}
+		records.add(buf[:n])
+	}
+	if err := ctx.Err(); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return nil, err
+	}
+	return records.printers(), nil
+}
+
+func buildDiscoveryQuery(service string) ([]byte, error) {
+	name, err := dnsmessage.NewName(service)
+	if err != nil {
+		return nil, err
+	}
+	msg := dnsmessage.Message{
+		Questions: []dnsmessage.Question{{Name: name, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET}},
+	}
+	return msg.Pack()
+}
+
+type discoveryRecords struct {
+	service   string
+	instances map[string]string
+	srv       map[string]dnsmessage.SRVResource
+	txt       map[string][]string
+	addrs     map[string][]net.IP
+}
+
+func newDiscoveryRecords(service string) *discoveryRecords {
+	return &discoveryRecords{
+		service:   strings.ToLower(service),
+		instances: make(map[string]string),
+		srv:       make(map[string]dnsmessage.SRVResource),
+		txt:       make(map[string][]string),
+		addrs:     make(map[string][]net.IP),
+	}
+}
+
+func (r *discoveryRecords) add(packet []byte) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(packet); err != nil || !msg.Response {
+		return
+	}
+	answers := append(append(msg.Answers, msg.Authorities...), msg.Additionals...)
+	for _, rr := range answers {
+		name := strings.ToLower(rr.Header.Name.String())
+		switch body := rr.Body.(type) {
+		case *dnsmessage.PTRResource:
+			if name == r.service && strings.HasSuffix(strings.ToLower(body.PTR.String()), "."+r.service) {
+				r.instances[strings.ToLower(body.PTR.String())] = body.PTR.String()
+			}
+		case *dnsmessage.SRVResource:
+			r.srv[name] = *body
+		case *dnsmessage.TXTResource:
+			r.txt[name] = body.TXT
+		case *dnsmessage.AResource:
+			r.addAddr(name, net.IP(body.A[:]))
+		case *dnsmessage.AAAAResource:
+			r.addAddr(name, net.IP(body.AAAA[:]))
+		}
+	}
+}
+
+func (r *discoveryRecords) addAddr(name string, ip net.IP) {
+	for _, known := range r.addrs[name] {
+		if known.Equal(ip) {
+			return
+		}
+	}
+	r.addrs[name] = append(r.addrs[name], ip)
+}
+
+func (r *discoveryRecords) printers() []DiscoveredPrinter {
+	var printers []DiscoveredPrinter
+	for instance, fullName := range r.instances {
+		srv, ok := r.srv[instance]
+		if !ok {
+			continue
+		}
+		target := srv.Target.String()
+		p := DiscoveredPrinter{
+			Instance: fullName[:len(fullName)-len(r.service)-1],
+			Hostname: target,
+			Port:     int(srv.Port),
+			Addrs:    r.addrs[strings.ToLower(target)],
+			Text:     make(map[string]string),
+		}
+		for _, kv := range r.txt[instance] {
+			key, value, _ := strings.Cut(kv, "=")
+			if key != "" {
+				p.Text[key] = value
+			}
+		}
+		printers = append(printers, p)
+	}
+	sort.Slice(printers, func(i, j int) bool { return printers[i].Instance < printers[j].Instance })
+	return printers
+}
@@ -0,0 +1,81 @@
+package go_moonraker
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/dns/dnsmessage"
+	"net"
+	"testing"
+	"time"
+)
+
+// startResponder answers PTR queries for the Moonraker service the way the
+// zeroconf component does, including SRV, TXT and A records as additionals.
+func startResponder(t *testing.T, instances map[string]uint16) string {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	service := dnsmessage.MustNewName("_moonraker._tcp.local.")
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, src, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			var query dnsmessage.Message
+			if err := query.Unpack(buf[:n]); err != nil || len(query.Questions) == 0 || query.Questions[0].Name != service {
+				continue
+			}
+			for instance, port := range instances {
+				name := dnsmessage.MustNewName(instance + "._moonraker._tcp.local.")
+				host := dnsmessage.MustNewName(instance + ".local.")
+				hdr := func(n dnsmessage.Name, typ dnsmessage.Type) dnsmessage.ResourceHeader {
+					return dnsmessage.ResourceHeader{Name: n, Type: typ, Class: dnsmessage.ClassINET, TTL: 120}
+				}
+				resp := dnsmessage.Message{
+					Header:  dnsmessage.Header{Response: true, Authoritative: true},
+					Answers: []dnsmessage.Resource{{Header: hdr(service, dnsmessage.TypePTR), Body: &dnsmessage.PTRResource{PTR: name}}},
+					Additionals: []dnsmessage.Resource{
+						{Header: hdr(name, dnsmessage.TypeSRV), Body: &dnsmessage.SRVResource{Target: host, Port: port}},
+						{Header: hdr(name, dnsmessage.TypeTXT), Body: &dnsmessage.TXTResource{TXT: []string{"path=/websocket"}}},
+						{Header: hdr(host, dnsmessage.TypeA), Body: &dnsmessage.AResource{A: [4]byte{10, 0, 0, byte(port % 256)}}},
+					},
+				}
+				packet, _ := resp.Pack()
+				conn.WriteToUDP(packet, src)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestDiscover(t *testing.T) {
+	assert := assert.New(t)
+	addr := startResponder(t, map[string]uint16{"voron": 7125, "ender": 80})
+	printers, err := Discover(context.Background(), DiscoveryOptions{Addr: addr, Timeout: 200 * time.Millisecond})
+	assert.NoError(err)
+	assert.Len(printers, 2)
+	assert.Equal("ender", printers[0].Instance)
+	assert.Equal("voron", printers[1].Instance)
+	assert.Equal("voron.local.", printers[1].Hostname)
+	assert.Equal(7125, printers[1].Port)
+	assert.Equal("10.0.0.213:7125", printers[1].Address())
+	assert.Equal("/websocket", printers[1].Text["path"])
+	assert.Equal(FleetMember{Name: "ender", Host: "10.0.0.80:80", Path: "websocket"}, printers[0].FleetMember())
+}
+
+func TestDiscover_ContextCancelled(t *testing.T) {
+	addr := startResponder(t, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := Discover(ctx, DiscoveryOptions{Addr: addr, Timeout: time.Second})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestDiscoveredPrinter_AddressFallsBackToHostname(t *testing.T) {
+	p := DiscoveredPrinter{Hostname: "voron.local.", Port: 7125}
+	assert.Equal(t, "voron.local:7125", p.Address())
+}
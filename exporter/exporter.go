@@ -0,0 +1,473 @@
+// Package exporter exposes printer and host metrics of one or more Moonraker
+// instances in the Prometheus text exposition format.
+package exporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	moonraker "github.com/derek-elliott/go-moonraker"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+type Options struct {
+	// Prefix of every metric name, "moonraker" by default.
+	Namespace string
+	// Maximum time spent scraping a single printer. Printers that don't
+	// answer in time are reported as down, and later scrapes wait on the
+	// call still running rather than starting another.
+	Timeout time.Duration
+}
+
+type target struct {
+	name   string
+	client *moonraker.MoonClient
+	labels map[string]string
+}
+
+type fleetSource struct {
+	fleet *moonraker.Fleet
+	tags  []string
+}
+
+type Exporter struct {
+	opts Options
+
+	mu      sync.RWMutex
+	targets map[string]target
+	fleets  []fleetSource
+
+	inflightMu sync.Mutex
+	inflight   map[*moonraker.MoonClient]*pendingScrape
+}
+
+// pendingScrape is a scrape still waiting for its printer. Later scrapes of
+// the same client wait for it instead of piling up more calls on a printer
+// that doesn't answer.
+type pendingScrape struct {
+	done chan struct{}
+	set  *metricSet
+}
+
+func New(opts Options) *Exporter {
+	if opts.Namespace == "" {
+		opts.Namespace = "moonraker"
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	return &Exporter{opts: opts, targets: make(map[string]target), inflight: make(map[*moonraker.MoonClient]*pendingScrape)}
+}
+
+// reservedLabels are the label names the exporter sets itself.
+var reservedLabels = []string{"printer", "sensor", "type", "fan", "state", "cpu", "interface", "condition"}
+
+func validLabelName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "__") {
+		return false
+	}
+	for i, r := range name {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// Add registers a client whose metrics are labelled printer=name plus any
+// extra labels given. The name must not already be exported, and the extra
+// labels must be valid label names the exporter doesn't set itself.
+func (e *Exporter) Add(name string, client *moonraker.MoonClient, labels map[string]string) error {
+	for key := range labels {
+		if !validLabelName(key) {
+			return fmt.Errorf("invalid label name %q", key)
+		}
+		for _, reserved := range reservedLabels {
+			if key == reserved {
+				return fmt.Errorf("label %s is set by the exporter", key)
+			}
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.exported(name) {
+		return fmt.Errorf("printer %s is already exported", name)
+	}
+	e.targets[name] = target{name, client, labels}
+	return nil
+}
+
+// exported reports whether name is a target or a current member of a fleet.
+// The caller must hold e.mu.
+func (e *Exporter) exported(name string) bool {
+	if _, ok := e.targets[name]; ok {
+		return true
+	}
+	for _, src := range e.fleets {
+		for _, member := range src.fleet.Members(src.tags...) {
+			if member.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (e *Exporter) Remove(name string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.targets, name)
+}
+
+// AddFleet exports every fleet member carrying all of the given tags. The
+// membership is resolved on each scrape, and members that are currently
+// disconnected are reported as down. It fails if a member is already
+// exported; a printer joining later under an exported name is skipped.
+func (e *Exporter) AddFleet(fleet *moonraker.Fleet, tags ...string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, member := range fleet.Members(tags...) {
+		if e.exported(member.Name) {
+			return fmt.Errorf("printer %s is already exported", member.Name)
+		}
+	}
+	e.fleets = append(e.fleets, fleetSource{fleet, tags})
+	return nil
+}
+
+type scrapeTarget struct {
+	target
+	err error
+}
+
+func (e *Exporter) scrapeTargets() []scrapeTarget {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	var targets []scrapeTarget
+	seen := make(map[string]bool)
+	for _, t := range e.targets {
+		targets = append(targets, scrapeTarget{target: t})
+		seen[t.name] = true
+	}
+	for _, src := range e.fleets {
+		for _, member := range src.fleet.Members(src.tags...) {
+			// Duplicate series would make Prometheus reject the scrape.
+			if seen[member.Name] {
+				continue
+			}
+			seen[member.Name] = true
+			client, err := src.fleet.Client(member.Name)
+			targets = append(targets, scrapeTarget{target{member.Name, client, nil}, err})
+		}
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].name < targets[j].name })
+	return targets
+}
+
+// Scrape queries all registered printers concurrently and renders their
+// metrics.
+func (e *Exporter) Scrape() ([]byte, error) {
+	targets := e.scrapeTargets()
+	results := make([]*metricSet, len(targets))
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func(i int, t scrapeTarget) {
+			defer wg.Done()
+			results[i] = e.scrapeWithTimeout(t)
+		}(i, t)
+	}
+	wg.Wait()
+
+	all := newMetricSet()
+	for i, t := range targets {
+		labels := []label{{"printer", t.name}}
+		extra := make([]string, 0, len(t.labels))
+		for name := range t.labels {
+			extra = append(extra, name)
+		}
+		sort.Strings(extra)
+		for _, name := range extra {
+			labels = append(labels, label{name, t.labels[name]})
+		}
+		all.merge(results[i], labels...)
+	}
+	var buf bytes.Buffer
+	if err := all.writeText(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := e.Scrape()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(body)
+}
+
+func (e *Exporter) scrapeWithTimeout(t scrapeTarget) *metricSet {
+	start := time.Now()
+	var set *metricSet
+	if t.err == nil {
+		pending := e.startScrape(t.client)
+		select {
+		case <-pending.done:
+			// The result may be shared with a concurrent scrape.
+			set = newMetricSet()
+			set.merge(pending.set)
+		case <-time.After(e.opts.Timeout):
+		}
+	}
+	if set == nil {
+		set = newMetricSet()
+		e.up(set, false)
+	}
+	set.gauge(e.name("scrape_duration_seconds"), "Time taken to scrape the printer.", time.Since(start).Seconds())
+	return set
+}
+
+// startScrape scrapes client in the background, or returns the scrape that is
+// already running for it.
+func (e *Exporter) startScrape(client *moonraker.MoonClient) *pendingScrape {
+	e.inflightMu.Lock()
+	defer e.inflightMu.Unlock()
+	if pending, ok := e.inflight[client]; ok {
+		return pending
+	}
+	pending := &pendingScrape{done: make(chan struct{})}
+	e.inflight[client] = pending
+	go func() {
+		pending.set = e.scrape(client)
+		e.inflightMu.Lock()
+		delete(e.inflight, client)
+		e.inflightMu.Unlock()
+		close(pending.done)
+	}()
+	return pending
+}
+
+func (e *Exporter) name(suffix string) string {
+	return e.opts.Namespace + "_" + suffix
+}
+
+func (e *Exporter) up(set *metricSet, up bool) {
+	set.gauge(e.name("up"), "Whether the Moonraker API could be reached.", boolValue(up))
+}
+
+func (e *Exporter) scrape(client *moonraker.MoonClient) *metricSet {
+	set := newMetricSet()
+	info, err := client.QueryServerInfo()
+	e.up(set, err == nil)
+	if err != nil {
+		return set
+	}
+	set.gauge(e.name("klippy_connected"), "Whether Moonraker is connected to Klippy.", boolValue(info.KlippyConnected))
+	for _, state := range klippyStates {
+		set.gauge(e.name("klippy_state"), "Current Klippy state.", boolValue(info.KlippyState == state), label{"state", state})
+	}
+	set.gauge(e.name("websocket_connections"), "Number of open websocket connections.", float64(info.WebsocketCount))
+
+	if info.KlippyState == "ready" {
+		if objects, err := queryObjects(client); err == nil {
+			e.collectObjects(set, objects)
+		}
+	}
+	if totals, err := client.JobHistoryTotals(); err == nil {
+		e.collectTotals(set, &totals.JobTotals)
+	}
+	if stats, err := client.ProcStats(); err == nil {
+		e.collectProcStats(set, stats)
+	}
+	return set
+}
+
+var klippyStates = []string{"ready", "startup", "shutdown", "error", "disconnected"}
+
+var printStates = []string{"standby", "printing", "paused", "complete", "cancelled", "error"}
+
+// Klipper object types reporting a temperature, a fan speed or both.
+var temperatureObjects = []string{"extruder", "heater_bed", "heater_generic", "temperature_sensor", "temperature_fan"}
+var fanObjects = []string{"fan", "heater_fan", "controller_fan", "fan_generic", "temperature_fan"}
+
+// objectType splits a Klipper object name such as "heater_generic chamber"
+// into its type and instance name. Additional extruders (extruder1, ...)
+// report as type extruder.
+func objectType(object string) (string, string) {
+	kind, name, found := strings.Cut(object, " ")
+	if !found {
+		name = object
+	}
+	if strings.HasPrefix(kind, "extruder") {
+		kind = "extruder"
+	}
+	return kind, name
+}
+
+func isObjectType(kind string, types []string) bool {
+	for _, t := range types {
+		if kind == t {
+			return true
+		}
+	}
+	return false
+}
+
+var statusObjects = []string{"webhooks", "print_stats", "virtual_sdcard", "display_status"}
+
+func queryObjects(client *moonraker.MoonClient) (map[string]json.RawMessage, error) {
+	names, err := client.ListObjects()
+	if err != nil {
+		return nil, err
+	}
+	params := moonraker.QueryObjectParams{Objects: make(map[string]interface{})}
+	for _, object := range statusObjects {
+		params.Objects[object] = nil
+	}
+	for _, object := range *names {
+		kind, _ := objectType(object)
+		if isObjectType(kind, temperatureObjects) || isObjectType(kind, fanObjects) {
+			params.Objects[object] = nil
+		}
+	}
+	var resp struct {
+		Status map[string]json.RawMessage `json:"status"`
+	}
+	if err := client.QueryObject(params, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Status, nil
+}
+
+// objectStatus holds the fields shared by all heater, sensor and fan objects.
+// Pointers distinguish fields an object doesn't report from zero values.
+type objectStatus struct {
+	Temperature *float64 `json:"temperature"`
+	Target      *float64 `json:"target"`
+	Power       *float64 `json:"power"`
+	Speed       *float64 `json:"speed"`
+	Rpm         *float64 `json:"rpm"`
+}
+
+func (e *Exporter) collectObjects(set *metricSet, objects map[string]json.RawMessage) {
+	names := make([]string, 0, len(objects))
+	for name := range objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, object := range names {
+		kind, name := objectType(object)
+		var status objectStatus
+		if err := json.Unmarshal(objects[object], &status); err != nil {
+			continue
+		}
+		if isObjectType(kind, temperatureObjects) {
+			labels := []label{{"sensor", name}, {"type", kind}}
+			if status.Temperature != nil {
+				set.gauge(e.name("temperature_celsius"), "Current temperature of a heater or sensor.", *status.Temperature, labels...)
+			}
+			if status.Target != nil {
+				set.gauge(e.name("temperature_target_celsius"), "Target temperature of a heater.", *status.Target, labels...)
+			}
+			if status.Power != nil {
+				set.gauge(e.name("heater_power_ratio"), "Heater PWM duty cycle between 0 and 1.", *status.Power, labels...)
+			}
+		}
+		if isObjectType(kind, fanObjects) {
+			labels := []label{{"fan", name}, {"type", kind}}
+			if status.Speed != nil {
+				set.gauge(e.name("fan_speed_ratio"), "Fan speed between 0 and 1.", *status.Speed, labels...)
+			}
+			if status.Rpm != nil {
+				set.gauge(e.name("fan_rpm"), "Measured fan speed in revolutions per minute.", *status.Rpm, labels...)
+			}
+		}
+	}
+
+	var printStats moonraker.PrintStats
+	if raw, ok := objects["print_stats"]; ok && json.Unmarshal(raw, &printStats) == nil {
+		for _, state := range printStates {
+			set.gauge(e.name("print_state"), "Current print state.", boolValue(printStats.State == state), label{"state", state})
+		}
+		set.gauge(e.name("print_duration_seconds"), "Time spent printing the current job.", float64(printStats.PrintDuration))
+		set.gauge(e.name("print_total_duration_seconds"), "Time since the current job started, including pauses.", float64(printStats.TotalDuration))
+		set.gauge(e.name("print_filament_used_mm"), "Filament used by the current job.", float64(printStats.FilamentUsed))
+	}
+	var progress float32
+	var sdcard moonraker.VirtualSdcard
+	if raw, ok := objects["virtual_sdcard"]; ok && json.Unmarshal(raw, &sdcard) == nil {
+		progress = sdcard.Progress
+	}
+	var display moonraker.DisplayStatus
+	if raw, ok := objects["display_status"]; ok && json.Unmarshal(raw, &display) == nil && display.Progress > 0 {
+		progress = display.Progress
+	}
+	set.gauge(e.name("print_progress_ratio"), "Progress of the current job between 0 and 1.", float64(progress))
+}
+
+func (e *Exporter) collectTotals(set *metricSet, totals *moonraker.HistoryTotals) {
+	set.counter(e.name("jobs_total"), "Number of jobs recorded in the job history.", float64(totals.TotalJobs))
+	set.counter(e.name("jobs_time_seconds_total"), "Total time of all recorded jobs.", totals.TotalTime)
+	set.counter(e.name("jobs_print_time_seconds_total"), "Total print time of all recorded jobs.", totals.TotalPrintTime)
+	set.counter(e.name("jobs_filament_used_mm_total"), "Total filament used by all recorded jobs.", totals.TotalFilamentUsed)
+	set.gauge(e.name("jobs_longest_job_seconds"), "Duration of the longest recorded job.", totals.LongestJob)
+	set.gauge(e.name("jobs_longest_print_seconds"), "Print time of the longest recorded job.", totals.LongestPrint)
+}
+
+// Moonraker reports memory in kB.
+const kilobyte = 1024
+
+func (e *Exporter) collectProcStats(set *metricSet, stats *moonraker.ProcStats) {
+	if n := len(stats.MoonrakerStats); n > 0 {
+		latest := stats.MoonrakerStats[n-1]
+		set.gauge(e.name("process_cpu_usage_percent"), "CPU usage of the Moonraker process.", float64(latest.CPUUsage))
+		set.gauge(e.name("process_memory_bytes"), "Memory used by the Moonraker process.", float64(latest.Memory)*kilobyte)
+	}
+	set.gauge(e.name("host_cpu_temperature_celsius"), "Host CPU temperature.", float64(stats.CpuTemp))
+	if usage, ok := stats.SystemCpuUsage["cpu"]; ok {
+		set.gauge(e.name("host_cpu_usage_percent"), "Host CPU usage, overall and per core.", usage, label{"cpu", "all"})
+	}
+	for i, usage := range stats.SystemCpuUsage.Cores() {
+		set.gauge(e.name("host_cpu_usage_percent"), "Host CPU usage, overall and per core.", usage, label{"cpu", fmt.Sprint(i)})
+	}
+	set.gauge(e.name("host_memory_total_bytes"), "Total host memory.", float64(stats.SystemMemory.Total)*kilobyte)
+	set.gauge(e.name("host_memory_available_bytes"), "Available host memory.", float64(stats.SystemMemory.Available)*kilobyte)
+	set.gauge(e.name("host_memory_used_bytes"), "Used host memory.", float64(stats.SystemMemory.Used)*kilobyte)
+	set.gauge(e.name("host_uptime_seconds"), "Host uptime.", stats.SystemUptime)
+
+	ifaces := make([]string, 0, len(stats.Network))
+	for iface := range stats.Network {
+		ifaces = append(ifaces, iface)
+	}
+	sort.Strings(ifaces)
+	for _, iface := range ifaces {
+		net := stats.Network[iface]
+		l := label{"interface", iface}
+		set.counter(e.name("host_network_receive_bytes_total"), "Bytes received per network interface.", float64(net.RxBytes), l)
+		set.counter(e.name("host_network_transmit_bytes_total"), "Bytes transmitted per network interface.", float64(net.TxBytes), l)
+		set.counter(e.name("host_network_receive_errors_total"), "Receive errors per network interface.", float64(net.RxErrs), l)
+		set.counter(e.name("host_network_transmit_errors_total"), "Transmit errors per network interface.", float64(net.TxErrs), l)
+		set.gauge(e.name("host_network_bandwidth_bytes_per_second"), "Current bandwidth per network interface.", net.Bandwidth, l)
+	}
+
+	for _, cond := range []moonraker.ThrottledCondition{moonraker.UnderVoltage, moonraker.FrequencyCapped, moonraker.Throttled, moonraker.TemperatureLimit} {
+		set.gauge(e.name("host_throttled"), "Whether a Raspberry Pi throttling condition is currently active.",
+			boolValue(stats.ThrottledState.Has(cond)), label{"condition", cond.String()})
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,193 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/creachadair/jrpc2"
+	"github.com/creachadair/jrpc2/channel"
+	"github.com/creachadair/jrpc2/handler"
+	moonraker "github.com/derek-elliott/go-moonraker"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, methods handler.Map) *moonraker.MoonClient {
+	cch, sch := channel.Direct()
+	srv := jrpc2.NewServer(methods, nil).Start(sch)
+	c := moonraker.NewClientFromChannel(cch, "printer.local", nil)
+	t.Cleanup(func() {
+		c.Close()
+		srv.Stop()
+		srv.Wait()
+	})
+	return c
+}
+
+func raw(s string) handler.Func {
+	return handler.New(func(ctx context.Context) (json.RawMessage, error) {
+		return json.RawMessage(s), nil
+	})
+}
+
+func printerMethods() handler.Map {
+	return handler.Map{
+		"server.info": raw(`{"klippy_connected": true, "klippy_state": "ready", "websocket_count": 2}`),
+		"printer.objects.list": raw(`{"objects": ["webhooks", "extruder", "heater_bed", "heater_generic chamber",
+			"temperature_sensor mcu", "fan", "heater_fan hotend_fan", "print_stats", "virtual_sdcard", "toolhead"]}`),
+		"printer.objects.query": handler.New(func(ctx context.Context, params moonraker.QueryObjectParams) (json.RawMessage, error) {
+			if _, ok := params.Objects["toolhead"]; ok {
+				return nil, jrpc2.Errorf(400, "unexpected object")
+			}
+			return json.RawMessage(`{"eventtime": 1.0, "status": {
+				"webhooks": {"state": "ready"},
+				"extruder": {"temperature": 209.8, "target": 210, "power": 0.42},
+				"heater_bed": {"temperature": 60.1, "target": 60, "power": 0.2},
+				"heater_generic chamber": {"temperature": 35, "target": 0, "power": 0},
+				"temperature_sensor mcu": {"temperature": 41.5},
+				"fan": {"speed": 0.5, "rpm": null},
+				"heater_fan hotend_fan": {"speed": 1},
+				"print_stats": {"filename": "benchy.gcode", "state": "printing", "print_duration": 600, "total_duration": 630, "filament_used": 1234.5},
+				"virtual_sdcard": {"progress": 0.25}
+			}}`), nil
+		}),
+		"server.history.totals": raw(`{"job_totals": {"total_jobs": 12, "total_time": 3600, "total_print_time": 3000, "total_filament_used": 5000, "longest_job": 900, "longest_print": 800}}`),
+		"machine.proc_stats": raw(`{
+			"moonraker_stats": [{"time": 1, "cpu_usage": 1.5, "memory": 100, "mem_units": "kB"}, {"time": 2, "cpu_usage": 2.5, "memory": 200, "mem_units": "kB"}],
+			"throttled_state": {"bits": 4, "flags": ["Currently Throttled"]},
+			"cpu_temp": 48.2,
+			"network": {"wlan0": {"rx_bytes": 1000, "tx_bytes": 2000, "bandwidth": 10}},
+			"system_cpu_usage": {"cpu": 10, "cpu0": 12, "cpu1": 8},
+			"system_memory": {"total": 1000, "available": 600, "used": 400},
+			"system_uptime": 120
+		}`),
+	}
+}
+
+func TestExporter_Scrape(t *testing.T) {
+	assert := assert.New(t)
+	e := New(Options{})
+	e.Add("voron", newTestClient(t, printerMethods()), map[string]string{"site": "lab"})
+	body, err := e.Scrape()
+	assert.NoError(err)
+	out := string(body)
+
+	for _, line := range []string{
+		"# TYPE moonraker_up gauge",
+		`moonraker_up{printer="voron",site="lab"} 1`,
+		`moonraker_klippy_state{printer="voron",site="lab",state="ready"} 1`,
+		`moonraker_klippy_state{printer="voron",site="lab",state="shutdown"} 0`,
+		`moonraker_temperature_celsius{printer="voron",site="lab",sensor="extruder",type="extruder"} 209.8`,
+		`moonraker_temperature_target_celsius{printer="voron",site="lab",sensor="heater_bed",type="heater_bed"} 60`,
+		`moonraker_heater_power_ratio{printer="voron",site="lab",sensor="extruder",type="extruder"} 0.42`,
+		`moonraker_temperature_celsius{printer="voron",site="lab",sensor="chamber",type="heater_generic"} 35`,
+		`moonraker_temperature_celsius{printer="voron",site="lab",sensor="mcu",type="temperature_sensor"} 41.5`,
+		`moonraker_fan_speed_ratio{printer="voron",site="lab",fan="fan",type="fan"} 0.5`,
+		`moonraker_fan_speed_ratio{printer="voron",site="lab",fan="hotend_fan",type="heater_fan"} 1`,
+		`moonraker_print_state{printer="voron",site="lab",state="printing"} 1`,
+		`moonraker_print_progress_ratio{printer="voron",site="lab"} 0.25`,
+		`moonraker_print_filament_used_mm{printer="voron",site="lab"} 1234.5`,
+		"# TYPE moonraker_jobs_total counter",
+		`moonraker_jobs_total{printer="voron",site="lab"} 12`,
+		`moonraker_process_memory_bytes{printer="voron",site="lab"} 204800`,
+		`moonraker_host_cpu_usage_percent{printer="voron",site="lab",cpu="1"} 8`,
+		`moonraker_host_network_receive_bytes_total{printer="voron",site="lab",interface="wlan0"} 1000`,
+		`moonraker_host_throttled{printer="voron",site="lab",condition="Currently Throttled"} 1`,
+		`moonraker_host_throttled{printer="voron",site="lab",condition="Under-Voltage Detected"} 0`,
+	} {
+		assert.Contains(out, line+"\n")
+	}
+	assert.NotContains(out, "moonraker_temperature_target_celsius{printer=\"voron\",site=\"lab\",sensor=\"mcu\"")
+	assert.NotContains(out, "moonraker_fan_rpm")
+	assert.Equal(1, strings.Count(out, "# HELP moonraker_host_cpu_usage_percent "))
+}
+
+func TestExporter_Down(t *testing.T) {
+	assert := assert.New(t)
+	e := New(Options{Namespace: "klipper", Timeout: 50 * time.Millisecond})
+	e.Add("broken", newTestClient(t, handler.Map{}), nil)
+	e.Add("stuck", newTestClient(t, handler.Map{
+		"server.info": handler.New(func(ctx context.Context) (json.RawMessage, error) {
+			time.Sleep(300 * time.Millisecond)
+			return json.RawMessage(`{}`), nil
+		}),
+	}), nil)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(contentType, rec.Header().Get("Content-Type"))
+	out, _ := io.ReadAll(rec.Body)
+	assert.Contains(string(out), `klipper_up{printer="broken"} 0`+"\n")
+	assert.Contains(string(out), `klipper_up{printer="stuck"} 0`+"\n")
+	assert.Contains(string(out), `klipper_scrape_duration_seconds{printer="stuck"}`)
+	assert.NotContains(string(out), "klipper_klippy_connected")
+}
+
+func TestExporter_StuckPrinterScrapedOnce(t *testing.T) {
+	assert := assert.New(t)
+	release := make(chan struct{})
+	calls := make(chan struct{}, 10)
+	e := New(Options{Timeout: 20 * time.Millisecond})
+	e.Add("stuck", newTestClient(t, handler.Map{
+		"server.info": handler.New(func(ctx context.Context) (json.RawMessage, error) {
+			calls <- struct{}{}
+			<-release
+			return nil, jrpc2.Errorf(500, "gave up")
+		}),
+	}), nil)
+
+	for i := 0; i < 3; i++ {
+		body, err := e.Scrape()
+		assert.NoError(err)
+		assert.Contains(string(body), `moonraker_up{printer="stuck"} 0`)
+	}
+	assert.Len(calls, 1)
+	close(release)
+}
+
+func TestExporter_AddChecksLabelsAndNames(t *testing.T) {
+	assert := assert.New(t)
+	e := New(Options{})
+	client := newTestClient(t, printerMethods())
+	assert.EqualError(e.Add("voron", client, map[string]string{"printer": "other"}), "label printer is set by the exporter")
+	assert.EqualError(e.Add("voron", client, map[string]string{"sensor": "x"}), "label sensor is set by the exporter")
+	assert.EqualError(e.Add("voron", client, map[string]string{"site-name": "lab"}), `invalid label name "site-name"`)
+	assert.NoError(e.Add("voron", client, map[string]string{"site": "lab"}))
+	assert.EqualError(e.Add("voron", client, nil), "printer voron is already exported")
+
+	newFleet := func() *moonraker.Fleet {
+		fleet := moonraker.NewFleet(moonraker.FleetOptions{
+			Dial: func(moonraker.FleetMember) (*moonraker.MoonClient, error) {
+				return newTestClient(t, printerMethods()), nil
+			},
+			HealthInterval: time.Minute,
+		})
+		t.Cleanup(func() { fleet.Close() })
+		return fleet
+	}
+	fleet := newFleet()
+	fleet.Add(moonraker.FleetMember{Name: "voron", Tags: []string{"abs"}})
+	fleet.Add(moonraker.FleetMember{Name: "prusa", Tags: []string{"pla"}})
+	assert.EqualError(e.AddFleet(fleet), "printer voron is already exported")
+	assert.NoError(e.AddFleet(fleet, "pla"))
+	assert.EqualError(e.Add("prusa", client, nil), "printer prusa is already exported")
+
+	// A member joining later under an exported name isn't exported twice.
+	other := newFleet()
+	assert.NoError(e.AddFleet(other))
+	assert.NoError(other.Add(moonraker.FleetMember{Name: "voron"}))
+	body, err := e.Scrape()
+	assert.NoError(err)
+	assert.Equal(1, strings.Count(string(body), `moonraker_up{printer="voron"`))
+}
+
+func TestMetricSet_Escaping(t *testing.T) {
+	set := newMetricSet()
+	set.gauge("m", "Help with \\ and\nnewline.", 1, label{"l", "a \"quoted\"\nvalue\\"})
+	var b strings.Builder
+	assert.NoError(t, set.writeText(&b))
+	assert.Equal(t, "# HELP m Help with \\\\ and\\nnewline.\n# TYPE m gauge\nm{l=\"a \\\"quoted\\\"\\nvalue\\\\\"} 1\n", b.String())
+}
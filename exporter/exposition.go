@@ -0,0 +1,122 @@
+package exporter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type metricType string
+
+const (
+	gaugeType   metricType = "gauge"
+	counterType metricType = "counter"
+)
+
+type label struct {
+	name  string
+	value string
+}
+
+type sample struct {
+	labels []label
+	value  float64
+}
+
+type family struct {
+	name    string
+	help    string
+	kind    metricType
+	samples []sample
+}
+
+// metricSet collects samples grouped by metric family, keeping families in
+// the order they were first added so the output is stable between scrapes.
+type metricSet struct {
+	families []*family
+	byName   map[string]*family
+}
+
+func newMetricSet() *metricSet {
+	return &metricSet{byName: make(map[string]*family)}
+}
+
+func (s *metricSet) add(kind metricType, name, help string, value float64, labels ...label) {
+	f, ok := s.byName[name]
+	if !ok {
+		f = &family{name: name, help: help, kind: kind}
+		s.families = append(s.families, f)
+		s.byName[name] = f
+	}
+	f.samples = append(f.samples, sample{labels, value})
+}
+
+func (s *metricSet) gauge(name, help string, value float64, labels ...label) {
+	s.add(gaugeType, name, help, value, labels...)
+}
+
+func (s *metricSet) counter(name, help string, value float64, labels ...label) {
+	s.add(counterType, name, help, value, labels...)
+}
+
+// merge appends all samples of other, prefixing each with the given labels.
+func (s *metricSet) merge(other *metricSet, labels ...label) {
+	for _, f := range other.families {
+		for _, smp := range f.samples {
+			s.add(f.kind, f.name, f.help, smp.value, append(append([]label{}, labels...), smp.labels...)...)
+		}
+	}
+}
+
+// writeText renders the set in the Prometheus text exposition format 0.0.4.
+func (s *metricSet) writeText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range s.families {
+		fmt.Fprintf(bw, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.name, f.kind)
+		for _, smp := range f.samples {
+			bw.WriteString(f.name)
+			if len(smp.labels) > 0 {
+				bw.WriteByte('{')
+				for i, l := range smp.labels {
+					if i > 0 {
+						bw.WriteByte(',')
+					}
+					fmt.Fprintf(bw, "%s=\"%s\"", l.name, escapeLabelValue(l.value))
+				}
+				bw.WriteByte('}')
+			}
+			bw.WriteByte(' ')
+			bw.WriteString(formatValue(smp.value))
+			bw.WriteByte('\n')
+		}
+	}
+	return bw.Flush()
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,397 @@
+package go_moonraker
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type FleetMember struct {
+	Name string   `json:"name"`
+	Host string   `json:"host"`
+	Path string   `json:"path"`
+	Tags []string `json:"tags"`
+}
+
+func (m FleetMember) HasTags(tags ...string) bool {
+	for _, tag := range tags {
+		if !containsString(m.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+type FleetOptions struct {
+	HealthInterval       time.Duration
+	HealthTimeout        time.Duration
+	ReconnectInterval    time.Duration
+	MaxReconnectInterval time.Duration
+	Dial                 func(member FleetMember) (*MoonClient, error)
+	// OnConnect is called every time a member (re)connects, before the
+	// connection is handed out. Use it to identify or subscribe.
+	OnConnect func(member FleetMember, client *MoonClient) error
+}
+
+type Fleet struct {
+	opts     FleetOptions
+	mu       sync.RWMutex
+	printers map[string]*fleetPrinter
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+type fleetPrinter struct {
+	member FleetMember
+	cancel context.CancelFunc
+
+	mu        sync.Mutex
+	client    *MoonClient
+	lastError error
+	since     time.Time
+}
+
+func dialFleetMember(member FleetMember) (*MoonClient, error) {
+	path := member.Path
+	if path == "" {
+		path = "websocket"
+	}
+	return NewClient(member.Host, path, nil)
+}
+
+func NewFleet(opts FleetOptions) *Fleet {
+	if opts.HealthInterval <= 0 {
+		opts.HealthInterval = 10 * time.Second
+	}
+	if opts.HealthTimeout <= 0 {
+		opts.HealthTimeout = 5 * time.Second
+	}
+	if opts.ReconnectInterval <= 0 {
+		opts.ReconnectInterval = time.Second
+	}
+	if opts.MaxReconnectInterval < opts.ReconnectInterval {
+		opts.MaxReconnectInterval = time.Minute
+	}
+	if opts.Dial == nil {
+		opts.Dial = dialFleetMember
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Fleet{
+		opts:     opts,
+		printers: make(map[string]*fleetPrinter),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+func (f *Fleet) Add(member FleetMember) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.printers[member.Name]; ok {
+		return fmt.Errorf("printer %s is already in the fleet", member.Name)
+	}
+	ctx, cancel := context.WithCancel(f.ctx)
+	p := &fleetPrinter{member: member, cancel: cancel}
+	f.printers[member.Name] = p
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		f.maintain(ctx, p)
+	}()
+	return nil
+}
+
+func (f *Fleet) Remove(name string) error {
+	f.mu.Lock()
+	p, ok := f.printers[name]
+	delete(f.printers, name)
+	f.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown printer: %s", name)
+	}
+	p.cancel()
+	return nil
+}
+
+func (f *Fleet) Close() error {
+	f.cancel()
+	f.wg.Wait()
+	return nil
+}
+
+// maintain keeps a single member connected until ctx ends, redialling with
+// exponential backoff whenever the connection fails its health check.
+func (f *Fleet) maintain(ctx context.Context, p *fleetPrinter) {
+	defer p.disconnect(nil)
+	backoff := f.opts.ReconnectInterval
+	for {
+		client, err := f.opts.Dial(p.member)
+		if err == nil && f.opts.OnConnect != nil {
+			if err = f.opts.OnConnect(p.member, client); err != nil {
+				client.Close()
+			}
+		}
+		if err != nil {
+			log.WithError(err).WithField("printer", p.member.Name).Warn("fleet connection failed")
+			p.disconnect(err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > f.opts.MaxReconnectInterval {
+				backoff = f.opts.MaxReconnectInterval
+			}
+			continue
+		}
+		backoff = f.opts.ReconnectInterval
+		p.connect(client)
+		if err := f.watch(ctx, client); err != nil {
+			log.WithError(err).WithField("printer", p.member.Name).Warn("fleet connection lost")
+			p.disconnect(err)
+			continue
+		}
+		return
+	}
+}
+
+func (f *Fleet) watch(ctx context.Context, client *MoonClient) error {
+	ticker := time.NewTicker(f.opts.HealthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		callCtx, cancel := context.WithTimeout(ctx, f.opts.HealthTimeout)
+		var info ServerInfo
+		err := client.Conn.CallResult(callCtx, "server.info", nil, &info)
+		cancel()
+		if err != nil && ctx.Err() == nil {
+			return err
+		}
+	}
+}
+
+func (p *fleetPrinter) connect(client *MoonClient) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.client = client
+	p.lastError = nil
+	p.since = time.Now()
+}
+
+func (p *fleetPrinter) disconnect(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.client != nil {
+		p.client.Close()
+		p.client = nil
+		p.since = time.Now()
+	}
+	if err != nil {
+		p.lastError = err
+	}
+}
+
+func (p *fleetPrinter) get() (*MoonClient, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.client == nil {
+		if p.lastError != nil {
+			return nil, fmt.Errorf("not connected: %w", p.lastError)
+		}
+		return nil, fmt.Errorf("not connected")
+	}
+	return p.client, nil
+}
+
+func (f *Fleet) Members(tags ...string) []FleetMember {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	var members []FleetMember
+	for _, p := range f.printers {
+		if p.member.HasTags(tags...) {
+			members = append(members, p.member)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+	return members
+}
+
+func (f *Fleet) Client(name string) (*MoonClient, error) {
+	f.mu.RLock()
+	p, ok := f.printers[name]
+	f.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown printer: %s", name)
+	}
+	return p.get()
+}
+
+type FleetError struct {
+	Errors map[string]error
+}
+
+func (e *FleetError) Error() string {
+	names := make([]string, 0, len(e.Errors))
+	for name := range e.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	msgs := make([]string, len(names))
+	for i, name := range names {
+		msgs[i] = fmt.Sprintf("%s: %s", name, e.Errors[name])
+	}
+	return fmt.Sprintf("%d printer(s) failed: %s", len(names), strings.Join(msgs, "; "))
+}
+
+// Do runs fn concurrently against every connected member carrying all of the
+// given tags. Failures, including members that are currently disconnected,
+// are collected per printer into a *FleetError.
+func (f *Fleet) Do(fn func(name string, client *MoonClient) error, tags ...string) error {
+	members := f.Members(tags...)
+	var mu sync.Mutex
+	errs := make(map[string]error)
+	var wg sync.WaitGroup
+	for _, member := range members {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			client, err := f.Client(name)
+			if err == nil {
+				err = fn(name, client)
+			}
+			if err != nil {
+				mu.Lock()
+				errs[name] = err
+				mu.Unlock()
+			}
+		}(member.Name)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return &FleetError{Errors: errs}
+	}
+	return nil
+}
+
+func (f *Fleet) PauseAll(tags ...string) error {
+	return f.Do(func(_ string, c *MoonClient) error { return c.PausePrint() }, tags...)
+}
+
+func (f *Fleet) ResumeAll(tags ...string) error {
+	return f.Do(func(_ string, c *MoonClient) error { return c.ResumePrint() }, tags...)
+}
+
+func (f *Fleet) CancelAll(tags ...string) error {
+	return f.Do(func(_ string, c *MoonClient) error { return c.CancelPrint() }, tags...)
+}
+
+func (f *Fleet) EmergencyStopAll(tags ...string) error {
+	return f.Do(func(_ string, c *MoonClient) error { return c.EmergencyStop() }, tags...)
+}
+
+func (f *Fleet) RunGcode(code string, tags ...string) error {
+	return f.Do(func(_ string, c *MoonClient) error { return c.RunGcode(code) }, tags...)
+}
+
+type HeaterStatus struct {
+	Temperature float32 `json:"temperature"`
+	Target      float32 `json:"target"`
+	Power       float32 `json:"power"`
+}
+
+type PrinterSnapshot struct {
+	Name          string                  `json:"name"`
+	Host          string                  `json:"host"`
+	Tags          []string                `json:"tags"`
+	Connected     bool                    `json:"connected"`
+	Error         string                  `json:"error,omitempty"`
+	KlippyState   string                  `json:"klippy_state"`
+	PrintState    string                  `json:"print_state"`
+	Filename      string                  `json:"filename"`
+	Progress      float32                 `json:"progress"`
+	PrintDuration float32                 `json:"print_duration"`
+	Message       string                  `json:"message"`
+	Heaters       map[string]HeaterStatus `json:"heaters"`
+}
+
+type FleetStatus struct {
+	Time     time.Time         `json:"time"`
+	Printers []PrinterSnapshot `json:"printers"`
+}
+
+func (f *Fleet) Status(tags ...string) FleetStatus {
+	members := f.Members(tags...)
+	status := FleetStatus{Time: time.Now(), Printers: make([]PrinterSnapshot, len(members))}
+	var wg sync.WaitGroup
+	for i, member := range members {
+		wg.Add(1)
+		go func(i int, member FleetMember) {
+			defer wg.Done()
+			status.Printers[i] = f.snapshot(member)
+		}(i, member)
+	}
+	wg.Wait()
+	return status
+}
+
+// Snapshot reports the status of a single member.
+func (f *Fleet) Snapshot(name string) (PrinterSnapshot, error) {
+	f.mu.RLock()
+	p, ok := f.printers[name]
+	f.mu.RUnlock()
+	if !ok {
+		return PrinterSnapshot{}, fmt.Errorf("unknown printer: %s", name)
+	}
+	return f.snapshot(p.member), nil
+}
+
+func (f *Fleet) snapshot(member FleetMember) PrinterSnapshot {
+	snap := PrinterSnapshot{Name: member.Name, Host: member.Host, Tags: member.Tags}
+	client, err := f.Client(member.Name)
+	if err != nil {
+		snap.Error = err.Error()
+		return snap
+	}
+	snap.Connected = true
+	objects, err := client.QueryPrinterObjects("webhooks", "print_stats", "virtual_sdcard", "display_status", "extruder", "heater_bed")
+	if err != nil {
+		snap.Error = err.Error()
+		return snap
+	}
+	return snap.fill(objects)
+}
+
+func (snap PrinterSnapshot) fill(objects *PrinterObjects) PrinterSnapshot {
+	if objects.Webhooks != nil {
+		snap.KlippyState = objects.Webhooks.State
+	}
+	if objects.PrintStats != nil {
+		snap.PrintState = objects.PrintStats.State
+		snap.Filename = objects.PrintStats.Filename
+		snap.PrintDuration = objects.PrintStats.PrintDuration
+		snap.Message = objects.PrintStats.Message
+	}
+	if objects.VirtualSdcard != nil {
+		snap.Progress = objects.VirtualSdcard.Progress
+	}
+	if objects.DisplayStatus != nil && objects.DisplayStatus.Progress > 0 {
+		snap.Progress = objects.DisplayStatus.Progress
+	}
+	snap.Heaters = make(map[string]HeaterStatus)
+	if objects.Extruder != nil {
+		snap.Heaters["extruder"] = HeaterStatus{objects.Extruder.Temperature, objects.Extruder.Target, objects.Extruder.Power}
+	}
+	if objects.HeaterBed != nil {
+		snap.Heaters["heater_bed"] = HeaterStatus{objects.HeaterBed.Temperature, objects.HeaterBed.Target, objects.HeaterBed.Power}
+	}
+	return snap
+}
@@ -0,0 +1,142 @@
+package go_moonraker
+
+import (
+	"context"
+	"errors"
+	"github.com/creachadair/jrpc2/handler"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeFleet struct {
+	t       *testing.T
+	mu      sync.Mutex
+	dials   map[string]int
+	gcode   map[string][]string
+	healthy func(name string, dial int) bool
+}
+
+func (f *fakeFleet) dial(member FleetMember) (*MoonClient, error) {
+	f.mu.Lock()
+	f.dials[member.Name]++
+	dial := f.dials[member.Name]
+	f.mu.Unlock()
+	if member.Host == "" {
+		return nil, errors.New("no route to host")
+	}
+	c, _ := newTestClient(f.t, handler.Map{
+		"server.info": handler.New(func(ctx context.Context) (ServerInfo, error) {
+			if f.healthy != nil && !f.healthy(member.Name, dial) {
+				return ServerInfo{}, errors.New("klippy host unreachable")
+			}
+			return ServerInfo{KlippyState: "ready"}, nil
+		}),
+		"printer.gcode.script": handler.New(func(ctx context.Context, params map[string]string) (string, error) {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			f.gcode[member.Name] = append(f.gcode[member.Name], params["script"])
+			return "ok", nil
+		}),
+		"printer.objects.query": handler.New(func(ctx context.Context, params QueryObjectParams) (PrinterObjectsStatus, error) {
+			return PrinterObjectsStatus{Status: PrinterObjects{
+				Webhooks:      &Webhooks{State: "ready"},
+				PrintStats:    &PrintStats{State: "printing", Filename: member.Name + ".gcode"},
+				VirtualSdcard: &VirtualSdcard{Progress: 0.25},
+				Extruder:      &Extruder{Temperature: 215, Target: 215, Power: 0.4},
+			}}, nil
+		}),
+	})
+	return c, nil
+}
+
+func newFakeFleet(t *testing.T) *fakeFleet {
+	return &fakeFleet{t: t, dials: make(map[string]int), gcode: make(map[string][]string)}
+}
+
+func waitConnected(t *testing.T, fleet *Fleet, names ...string) {
+	assert.Eventually(t, func() bool {
+		for _, name := range names {
+			if _, err := fleet.Client(name); err != nil {
+				return false
+			}
+		}
+		return true
+	}, 2*time.Second, 5*time.Millisecond)
+}
+
+func TestFleet_RunGcodeOnTaggedGroup(t *testing.T) {
+	assert := assert.New(t)
+	fake := newFakeFleet(t)
+	fleet := NewFleet(FleetOptions{Dial: fake.dial, ReconnectInterval: 10 * time.Millisecond})
+	defer fleet.Close()
+	assert.NoError(fleet.Add(FleetMember{Name: "voron", Host: "voron.local", Tags: []string{"abs", "enclosed"}}))
+	assert.NoError(fleet.Add(FleetMember{Name: "prusa", Host: "prusa.local", Tags: []string{"pla"}}))
+	assert.NoError(fleet.Add(FleetMember{Name: "ender", Tags: []string{"abs"}}))
+	assert.Error(fleet.Add(FleetMember{Name: "prusa"}))
+	waitConnected(t, fleet, "voron", "prusa")
+
+	err := fleet.RunGcode("M117 hello", "abs")
+	var fleetErr *FleetError
+	assert.True(errors.As(err, &fleetErr))
+	assert.Len(fleetErr.Errors, 1)
+	assert.Contains(fleetErr.Errors["ender"].Error(), "no route to host")
+
+	fake.mu.Lock()
+	assert.Equal(map[string][]string{"voron": {"M117 hello"}}, fake.gcode)
+	fake.mu.Unlock()
+	assert.NoError(fleet.RunGcode("M117 pla", "pla"))
+}
+
+func TestFleet_Status(t *testing.T) {
+	assert := assert.New(t)
+	fake := newFakeFleet(t)
+	fleet := NewFleet(FleetOptions{Dial: fake.dial, ReconnectInterval: 10 * time.Millisecond})
+	defer fleet.Close()
+	fleet.Add(FleetMember{Name: "b", Host: "b.local"})
+	fleet.Add(FleetMember{Name: "a", Host: "a.local"})
+	fleet.Add(FleetMember{Name: "c"})
+	waitConnected(t, fleet, "a", "b")
+
+	status := fleet.Status()
+	assert.Len(status.Printers, 3)
+	a := status.Printers[0]
+	assert.Equal("a", a.Name)
+	assert.True(a.Connected)
+	assert.Equal("printing", a.PrintState)
+	assert.Equal("a.gcode", a.Filename)
+	assert.InDelta(0.25, a.Progress, 0.001)
+	assert.Equal(HeaterStatus{215, 215, 0.4}, a.Heaters["extruder"])
+	c := status.Printers[2]
+	assert.False(c.Connected)
+	assert.NotEmpty(c.Error)
+
+	b, err := fleet.Snapshot("b")
+	assert.NoError(err)
+	assert.Equal("b.gcode", b.Filename)
+	_, err = fleet.Snapshot("d")
+	assert.EqualError(err, "unknown printer: d")
+}
+
+func TestFleet_Reconnects(t *testing.T) {
+	fake := newFakeFleet(t)
+	fake.healthy = func(name string, dial int) bool { return dial > 1 }
+	fleet := NewFleet(FleetOptions{
+		Dial:              fake.dial,
+		HealthInterval:    10 * time.Millisecond,
+		ReconnectInterval: 10 * time.Millisecond,
+	})
+	defer fleet.Close()
+	fleet.Add(FleetMember{Name: "voron", Host: "voron.local"})
+	assert.Eventually(t, func() bool {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		return fake.dials["voron"] == 2
+	}, 2*time.Second, 5*time.Millisecond)
+	waitConnected(t, fleet, "voron")
+	time.Sleep(50 * time.Millisecond)
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	assert.Equal(t, 2, fake.dials["voron"])
+}
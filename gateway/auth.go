@@ -0,0 +1,103 @@
+package gateway
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Scope is the permission a route requires.
+type Scope string
+
+const (
+	// ScopeRead covers printer status, files and event streams.
+	ScopeRead Scope = "read"
+	// ScopeControl covers G-code and starting or stopping prints.
+	ScopeControl Scope = "control"
+)
+
+var (
+	ErrUnauthenticated = errors.New("missing or unknown credentials")
+	ErrForbidden       = errors.New("not allowed")
+)
+
+// Access describes what a request is trying to do. Printer is empty for
+// routes that span the whole fleet.
+type Access struct {
+	Operation string
+	Scope     Scope
+	Printer   string
+}
+
+// Authorizer decides whether a request may perform an access. Returning an
+// error wrapping ErrUnauthenticated answers 401, any other error 403.
+type Authorizer interface {
+	Authorize(r *http.Request, access Access) error
+}
+
+type AuthorizerFunc func(r *http.Request, access Access) error
+
+func (f AuthorizerFunc) Authorize(r *http.Request, access Access) error {
+	return f(r, access)
+}
+
+// Token grants scopes to the holder of an API token, optionally limited to
+// some printers. An empty Printers list allows every printer.
+type Token struct {
+	Scopes   []Scope
+	Printers []string
+}
+
+func (t Token) allows(access Access) bool {
+	if !containsScope(t.Scopes, access.Scope) {
+		return false
+	}
+	if len(t.Printers) == 0 {
+		return true
+	}
+	if access.Printer == "" {
+		// Fleet-wide routes would expose printers outside the allowed set.
+		return false
+	}
+	for _, p := range t.Printers {
+		if p == access.Printer {
+			return true
+		}
+	}
+	return false
+}
+
+func containsScope(scopes []Scope, scope Scope) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// TokenAuth authorizes requests carrying one of the given tokens, either as
+// "Authorization: Bearer <token>" or in an X-Api-Key header.
+func TokenAuth(tokens map[string]Token) Authorizer {
+	return AuthorizerFunc(func(r *http.Request, access Access) error {
+		presented := r.Header.Get("X-Api-Key")
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			presented = strings.TrimPrefix(auth, "Bearer ")
+		}
+		if presented == "" {
+			return ErrUnauthenticated
+		}
+		for key, token := range tokens {
+			if subtle.ConstantTimeCompare([]byte(key), []byte(presented)) != 1 {
+				continue
+			}
+			if !token.allows(access) {
+				return fmt.Errorf("%w: %s", ErrForbidden, access.Operation)
+			}
+			return nil
+		}
+		return ErrUnauthenticated
+	})
+}
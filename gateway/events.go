@@ -0,0 +1,189 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	moonraker "github.com/derek-elliott/go-moonraker"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// eventStream writes server-sent events. Every event carries an increasing
+// id so clients can tell whether they missed any.
+type eventStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	id      int
+}
+
+func newEventStream(w http.ResponseWriter) (*eventStream, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, errors.New("streaming is not supported")
+	}
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return &eventStream{w: w, flusher: flusher}, nil
+}
+
+func (s *eventStream) send(event string, data []byte) error {
+	s.id++
+	if _, err := fmt.Fprintf(s.w, "id: %d\nevent: %s\ndata: %s\n\n", s.id, event, data); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// statusChanges remembers the last snapshot sent per printer so that only
+// changes are streamed.
+type statusChanges map[string][]byte
+
+func (c statusChanges) changed(snap moonraker.PrinterSnapshot) ([]byte, bool) {
+	data, err := json.Marshal(snap)
+	if err != nil || bytes.Equal(c[snap.Name], data) {
+		return nil, false
+	}
+	c[snap.Name] = data
+	return data, true
+}
+
+// statusFeed polls the fleet every EventInterval while at least one event
+// stream is open and hands each poll to all of them, so the load on the
+// printers doesn't grow with the number of streams. Polling keeps the gateway
+// from replacing the object subscriptions other users of the fleet's
+// connections rely on.
+type statusFeed struct {
+	fleet    *moonraker.Fleet
+	interval time.Duration
+
+	mu   sync.Mutex
+	subs map[chan []moonraker.PrinterSnapshot]struct{}
+	// last is the latest poll, sent straight away to new subscribers.
+	last []moonraker.PrinterSnapshot
+	stop chan struct{}
+}
+
+func newStatusFeed(fleet *moonraker.Fleet, interval time.Duration) *statusFeed {
+	return &statusFeed{fleet: fleet, interval: interval, subs: make(map[chan []moonraker.PrinterSnapshot]struct{})}
+}
+
+// subscribe returns a channel receiving every poll of the fleet. A subscriber
+// that falls behind only gets the latest one.
+func (f *statusFeed) subscribe() chan []moonraker.PrinterSnapshot {
+	ch := make(chan []moonraker.PrinterSnapshot, 1)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.subs[ch] = struct{}{}
+	if f.last != nil {
+		ch <- f.last
+	}
+	if f.stop == nil {
+		f.stop = make(chan struct{})
+		go f.poll(f.stop)
+	}
+	return ch
+}
+
+// unsubscribe removes ch, stopping the polls once nobody is left.
+func (f *statusFeed) unsubscribe(ch chan []moonraker.PrinterSnapshot) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.subs, ch)
+	if len(f.subs) == 0 && f.stop != nil {
+		close(f.stop)
+		f.stop = nil
+		f.last = nil
+	}
+}
+
+func (f *statusFeed) poll(stop chan struct{}) {
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+	for {
+		snaps := f.fleet.Status().Printers
+		f.mu.Lock()
+		if f.stop != stop {
+			// Everyone left during the poll.
+			f.mu.Unlock()
+			return
+		}
+		f.last = snaps
+		for ch := range f.subs {
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- snaps
+		}
+		f.mu.Unlock()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// stream sends a status event for each printer picked by filter whose
+// snapshot changed since the last poll, until the client goes away.
+func (g *Gateway) stream(w http.ResponseWriter, r *http.Request, filter func([]moonraker.PrinterSnapshot) []moonraker.PrinterSnapshot) {
+	events, err := newEventStream(w)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	updates := g.feed.subscribe()
+	defer g.feed.unsubscribe(updates)
+	last := make(statusChanges)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case snaps := <-updates:
+			for _, snap := range filter(snaps) {
+				if data, ok := last.changed(snap); ok {
+					if err := events.send("status", data); err != nil {
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+func (g *Gateway) printerEvents(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	name := params["printer"]
+	if _, ok := g.member(w, name); !ok {
+		return
+	}
+	g.stream(w, r, func(snaps []moonraker.PrinterSnapshot) []moonraker.PrinterSnapshot {
+		for _, snap := range snaps {
+			if snap.Name == name {
+				return []moonraker.PrinterSnapshot{snap}
+			}
+		}
+		// The printer left the fleet; report it as disconnected.
+		return []moonraker.PrinterSnapshot{{Name: name, Error: fmt.Sprintf("unknown printer: %s", name)}}
+	})
+}
+
+func (g *Gateway) fleetEvents(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	tags := r.URL.Query()["tag"]
+	g.stream(w, r, func(snaps []moonraker.PrinterSnapshot) []moonraker.PrinterSnapshot {
+		var picked []moonraker.PrinterSnapshot
+		for _, snap := range snaps {
+			if (moonraker.FleetMember{Tags: snap.Tags}).HasTags(tags...) {
+				picked = append(picked, snap)
+			}
+		}
+		return picked
+	})
+}
@@ -0,0 +1,158 @@
+// Package gateway serves a fleet of Moonraker printers over a small,
+// versioned REST/JSON API, with server-sent events for status updates and an
+// OpenAPI description of every route.
+//
+// All routes live under /v1:
+//
+//	GET  /v1/openapi.json
+//	GET  /v1/printers                         ?tag=...
+//	GET  /v1/printers/{printer}
+//	GET  /v1/printers/{printer}/objects       ?object=...
+//	GET  /v1/printers/{printer}/files         ?root=gcodes
+//	POST /v1/printers/{printer}/gcode         {"script": "G28"}
+//	POST /v1/printers/{printer}/print         {"filename": "benchy.gcode"}
+//	POST /v1/printers/{printer}/pause
+//	POST /v1/printers/{printer}/resume
+//	POST /v1/printers/{printer}/cancel
+//	POST /v1/printers/{printer}/emergency_stop
+//	GET  /v1/printers/{printer}/events
+//	GET  /v1/events                           ?tag=...
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	moonraker "github.com/derek-elliott/go-moonraker"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// APIVersion is the version of the API described by the OpenAPI document.
+const APIVersion = "1.0.0"
+
+const maxBodySize = 1 << 20
+
+type Options struct {
+	// Authorizer decides whether a request may use a route. Every request is
+	// allowed when it is nil.
+	Authorizer Authorizer
+	// How often the printers are polled for changes while event streams are
+	// open, one second by default. All streams share the same polls.
+	EventInterval time.Duration
+}
+
+type Gateway struct {
+	fleet  *moonraker.Fleet
+	opts   Options
+	routes []*route
+	spec   []byte
+	feed   *statusFeed
+}
+
+func New(fleet *moonraker.Fleet, opts Options) *Gateway {
+	if opts.EventInterval <= 0 {
+		opts.EventInterval = time.Second
+	}
+	g := &Gateway{fleet: fleet, opts: opts, feed: newStatusFeed(fleet, opts.EventInterval)}
+	g.routes = g.routeTable()
+	spec, err := json.MarshalIndent(g.openAPI(), "", "  ")
+	if err != nil {
+		panic(fmt.Sprintf("gateway: encoding OpenAPI document: %v", err))
+	}
+	g.spec = spec
+	return g
+}
+
+// OpenAPI returns the OpenAPI 3 document describing the gateway's routes.
+func (g *Gateway) OpenAPI() []byte {
+	return g.spec
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt, params, allowed := g.match(r.Method, r.URL.Path)
+	if rt == nil {
+		if len(allowed) > 0 {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+			return
+		}
+		writeError(w, http.StatusNotFound, fmt.Errorf("no route for %s", r.URL.Path))
+		return
+	}
+	if rt.scope != "" && g.opts.Authorizer != nil {
+		access := Access{Operation: rt.operation, Scope: rt.scope, Printer: params["printer"]}
+		if err := g.opts.Authorizer.Authorize(r, access); err != nil {
+			status := http.StatusForbidden
+			if errors.Is(err, ErrUnauthenticated) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="moonraker-gateway"`)
+				status = http.StatusUnauthorized
+			}
+			writeError(w, status, err)
+			return
+		}
+	}
+	rt.handle(w, r, params)
+}
+
+// match finds the route for a request. When the path exists but not for the
+// method, the methods it does support are returned instead.
+func (g *Gateway) match(method, path string) (*route, map[string]string, []string) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	var allowed []string
+	for _, rt := range g.routes {
+		params, ok := rt.matchPath(segments)
+		if !ok {
+			continue
+		}
+		if rt.method == method {
+			return rt, params, nil
+		}
+		allowed = append(allowed, rt.method)
+	}
+	return nil, nil, allowed
+}
+
+func (rt *route) matchPath(segments []string) (map[string]string, bool) {
+	pattern := strings.Split(strings.Trim(rt.path, "/"), "/")
+	if len(pattern) != len(segments) {
+		return nil, false
+	}
+	params := make(map[string]string)
+	for i, p := range pattern {
+		if strings.HasPrefix(p, "{") && strings.HasSuffix(p, "}") {
+			if segments[i] == "" {
+				return nil, false
+			}
+			params[p[1:len(p)-1]] = segments[i]
+		} else if p != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return false
+	}
+	return true
+}
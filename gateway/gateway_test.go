@@ -0,0 +1,290 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/creachadair/jrpc2"
+	"github.com/creachadair/jrpc2/channel"
+	"github.com/creachadair/jrpc2/handler"
+	moonraker "github.com/derek-elliott/go-moonraker"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakePrinters struct {
+	t       *testing.T
+	mu      sync.Mutex
+	gcode   []string
+	queries map[string]int
+}
+
+func (f *fakePrinters) dial(member moonraker.FleetMember) (*moonraker.MoonClient, error) {
+	if member.Host == "" {
+		return nil, errors.New("no route to host")
+	}
+	cch, sch := channel.Direct()
+	srv := jrpc2.NewServer(handler.Map{
+		"server.info": handler.New(func(ctx context.Context) (moonraker.ServerInfo, error) {
+			return moonraker.ServerInfo{KlippyState: "ready"}, nil
+		}),
+		"printer.objects.query": handler.New(func(ctx context.Context, params moonraker.QueryObjectParams) (json.RawMessage, error) {
+			f.mu.Lock()
+			f.queries[member.Name]++
+			f.mu.Unlock()
+			return json.RawMessage(`{"eventtime": 12.5, "status": {
+				"webhooks": {"state": "ready"},
+				"print_stats": {"state": "printing", "filename": "` + member.Name + `.gcode"},
+				"virtual_sdcard": {"progress": 0.5},
+				"extruder": {"temperature": 215, "target": 215, "power": 0.4},
+				"heater_generic chamber": {"temperature": 40}
+			}}`), nil
+		}),
+		"printer.gcode.script": handler.New(func(ctx context.Context, params map[string]string) (string, error) {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			f.gcode = append(f.gcode, member.Name+": "+params["script"])
+			return "ok", nil
+		}),
+		"printer.print.pause": handler.New(func(ctx context.Context) (string, error) {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			f.gcode = append(f.gcode, member.Name+": PAUSE")
+			return "ok", nil
+		}),
+		"printer.print.start": handler.New(func(ctx context.Context, params map[string]string) (string, error) {
+			return "", jrpc2.Errorf(400, "file %s not found", params["filename"])
+		}),
+		"server.files.list": handler.New(func(ctx context.Context, params map[string]string) ([]moonraker.MoonrakerFile, error) {
+			return []moonraker.MoonrakerFile{{Path: "benchy.gcode", Size: 1024}}, nil
+		}),
+	}, nil).Start(sch)
+	f.t.Cleanup(func() {
+		srv.Stop()
+		srv.Wait()
+	})
+	return moonraker.NewClientFromChannel(cch, member.Host, nil), nil
+}
+
+func newTestFleet(t *testing.T) (*moonraker.Fleet, *fakePrinters) {
+	fake := &fakePrinters{t: t, queries: make(map[string]int)}
+	fleet := moonraker.NewFleet(moonraker.FleetOptions{Dial: fake.dial, HealthInterval: time.Minute})
+	t.Cleanup(func() { fleet.Close() })
+	fleet.Add(moonraker.FleetMember{Name: "voron", Host: "voron.local", Tags: []string{"abs"}})
+	fleet.Add(moonraker.FleetMember{Name: "prusa", Host: "prusa.local", Tags: []string{"pla"}})
+	fleet.Add(moonraker.FleetMember{Name: "ender", Tags: []string{"pla"}})
+	assert.Eventually(t, func() bool {
+		_, err1 := fleet.Client("voron")
+		_, err2 := fleet.Client("prusa")
+		return err1 == nil && err2 == nil
+	}, 2*time.Second, 5*time.Millisecond)
+	return fleet, fake
+}
+
+func do(g http.Handler, method, path, body string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestGateway_Routes(t *testing.T) {
+	assert := assert.New(t)
+	fleet, fake := newTestFleet(t)
+	g := New(fleet, Options{})
+
+	rec := do(g, "GET", "/v1/printers?tag=pla", "")
+	assert.Equal(http.StatusOK, rec.Code)
+	var status moonraker.FleetStatus
+	assert.NoError(json.Unmarshal(rec.Body.Bytes(), &status))
+	if assert.Len(status.Printers, 2) {
+		assert.Equal("ender", status.Printers[0].Name)
+		assert.False(status.Printers[0].Connected)
+		assert.Equal("prusa.gcode", status.Printers[1].Filename)
+	}
+
+	rec = do(g, "GET", "/v1/printers/voron", "")
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Contains(rec.Body.String(), `"print_state":"printing"`)
+	rec = do(g, "GET", "/v1/printers/mk4", "")
+	assert.Equal(http.StatusNotFound, rec.Code)
+	assert.JSONEq(`{"error": "unknown printer: mk4"}`, rec.Body.String())
+
+	rec = do(g, "GET", "/v1/printers/voron/objects?object=heater_generic+chamber", "")
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Contains(rec.Body.String(), `"heater_generic chamber":{"temperature":40}`)
+	assert.Equal(http.StatusBadRequest, do(g, "GET", "/v1/printers/voron/objects", "").Code)
+
+	rec = do(g, "GET", "/v1/printers/voron/files", "")
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Contains(rec.Body.String(), `"path":"benchy.gcode"`)
+
+	rec = do(g, "POST", "/v1/printers/voron/gcode", `{"script": "G28"}`)
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.JSONEq(`{"result": "ok"}`, rec.Body.String())
+	rec = do(g, "POST", "/v1/printers/voron/pause", "")
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Equal([]string{"voron: G28", "voron: PAUSE"}, fake.gcode)
+
+	assert.Equal(http.StatusBadRequest, do(g, "POST", "/v1/printers/voron/gcode", `{"gcode": "G28"}`).Code)
+	rec = do(g, "POST", "/v1/printers/voron/print", `{"filename": "missing.gcode"}`)
+	assert.Equal(http.StatusBadGateway, rec.Code)
+	assert.Contains(rec.Body.String(), "missing.gcode not found")
+	rec = do(g, "POST", "/v1/printers/ender/gcode", `{"script": "G28"}`)
+	assert.Equal(http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(rec.Body.String(), "no route to host")
+
+	rec = do(g, "GET", "/v1/printers/voron/gcode", "")
+	assert.Equal(http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal("POST", rec.Header().Get("Allow"))
+	assert.Equal(http.StatusNotFound, do(g, "GET", "/v2/printers", "").Code)
+}
+
+func TestGateway_Authorization(t *testing.T) {
+	assert := assert.New(t)
+	fleet, fake := newTestFleet(t)
+	g := New(fleet, Options{Authorizer: TokenAuth(map[string]Token{
+		"viewer":   {Scopes: []Scope{ScopeRead}},
+		"operator": {Scopes: []Scope{ScopeRead, ScopeControl}, Printers: []string{"voron"}},
+	})})
+
+	rec := do(g, "GET", "/v1/printers", "")
+	assert.Equal(http.StatusUnauthorized, rec.Code)
+	assert.NotEmpty(rec.Header().Get("WWW-Authenticate"))
+	assert.Equal(http.StatusUnauthorized, do(g, "GET", "/v1/printers", "", "Authorization", "Bearer guess").Code)
+	assert.Equal(http.StatusOK, do(g, "GET", "/v1/printers", "", "Authorization", "Bearer viewer").Code)
+	assert.Equal(http.StatusOK, do(g, "GET", "/v1/openapi.json", "").Code)
+
+	rec = do(g, "POST", "/v1/printers/voron/gcode", `{"script": "G28"}`, "X-Api-Key", "viewer")
+	assert.Equal(http.StatusForbidden, rec.Code)
+	assert.JSONEq(`{"error": "not allowed: runGcode"}`, rec.Body.String())
+
+	assert.Equal(http.StatusOK, do(g, "POST", "/v1/printers/voron/gcode", `{"script": "G28"}`, "X-Api-Key", "operator").Code)
+	assert.Equal(http.StatusForbidden, do(g, "POST", "/v1/printers/prusa/gcode", `{"script": "G28"}`, "X-Api-Key", "operator").Code)
+	assert.Equal(http.StatusForbidden, do(g, "GET", "/v1/printers", "", "X-Api-Key", "operator").Code)
+	assert.Equal([]string{"voron: G28"}, fake.gcode)
+}
+
+func TestGateway_OpenAPI(t *testing.T) {
+	assert := assert.New(t)
+	fleet, _ := newTestFleet(t)
+	g := New(fleet, Options{Authorizer: TokenAuth(nil)})
+
+	var doc struct {
+		OpenAPI string `json:"openapi"`
+		Info    struct {
+			Version string `json:"version"`
+		} `json:"info"`
+		Paths map[string]map[string]struct {
+			OperationID string `json:"operationId"`
+			Parameters  []struct {
+				Name string `json:"name"`
+				In   string `json:"in"`
+			} `json:"parameters"`
+			RequestBody *json.RawMessage           `json:"requestBody"`
+			Responses   map[string]json.RawMessage `json:"responses"`
+		} `json:"paths"`
+		Components struct {
+			Schemas map[string]json.RawMessage `json:"schemas"`
+		} `json:"components"`
+	}
+	assert.NoError(json.Unmarshal(g.OpenAPI(), &doc))
+	assert.Equal("3.0.3", doc.OpenAPI)
+	assert.Equal(APIVersion, doc.Info.Version)
+	assert.Len(doc.Paths, 13)
+
+	gcode := doc.Paths["/v1/printers/{printer}/gcode"]["post"]
+	assert.Equal("runGcode", gcode.OperationID)
+	assert.Equal("printer", gcode.Parameters[0].Name)
+	assert.Equal("path", gcode.Parameters[0].In)
+	assert.NotNil(gcode.RequestBody)
+	for _, code := range []string{"200", "400", "401", "403", "404", "502", "503"} {
+		assert.Contains(gcode.Responses, code)
+	}
+	assert.Equal("tag", doc.Paths["/v1/printers"]["get"].Parameters[0].Name)
+	assert.NotContains(doc.Paths["/v1/openapi.json"]["get"].Responses, "401")
+
+	// Every referenced schema is defined.
+	for _, ref := range strings.Split(string(g.OpenAPI()), `"#/components/schemas/`)[1:] {
+		name := ref[:strings.Index(ref, `"`)]
+		assert.Contains(doc.Components.Schemas, name)
+	}
+}
+
+func TestGateway_Events(t *testing.T) {
+	assert := assert.New(t)
+	fleet, _ := newTestFleet(t)
+	srv := httptest.NewServer(New(fleet, Options{EventInterval: 10 * time.Millisecond}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/v1/events?tag=pla", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if !assert.NoError(err) {
+		return
+	}
+	defer resp.Body.Close()
+	assert.Equal("text/event-stream", resp.Header.Get("Content-Type"))
+
+	// Each printer is sent once, the unchanged snapshots polled afterwards
+	// are not repeated.
+	var lines []string
+	scanner := bufio.NewScanner(resp.Body)
+	for len(lines) < 8 && scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if assert.Len(lines, 8) {
+		assert.Equal([]string{"id: 1", "event: status"}, lines[:2])
+		assert.Contains(lines[2], `"name":"ender"`)
+		assert.Equal([]string{"", "id: 2", "event: status"}, lines[3:6])
+		assert.Contains(lines[6], `"name":"prusa"`)
+	}
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	for scanner.Scan() {
+		assert.NotContains(scanner.Text(), "id: 3")
+	}
+
+	resp, err = http.Get(srv.URL + "/v1/printers/mk4/events")
+	if assert.NoError(err) {
+		resp.Body.Close()
+		assert.Equal(http.StatusNotFound, resp.StatusCode)
+	}
+}
+
+func TestGateway_EventsSharePolls(t *testing.T) {
+	assert := assert.New(t)
+	fleet, fake := newTestFleet(t)
+	srv := httptest.NewServer(New(fleet, Options{EventInterval: time.Hour}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Every stream gets the first poll, but the printers are only queried
+	// once between them.
+	for _, path := range []string{"/v1/events", "/v1/events?tag=pla", "/v1/printers/voron/events", "/v1/printers/voron/events"} {
+		req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if !assert.NoError(err) {
+			return
+		}
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() && !strings.HasPrefix(scanner.Text(), "data:") {
+		}
+		assert.Contains(scanner.Text(), `"name":`, path)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	assert.Equal(map[string]int{"voron": 1, "prusa": 1}, fake.queries)
+}
@@ -0,0 +1,172 @@
+package gateway
+
+import "strings"
+
+type object = map[string]interface{}
+
+func schemaRef(name string) object {
+	return object{"$ref": "#/components/schemas/" + name}
+}
+
+func props(fields ...string) object {
+	properties := object{}
+	for i := 0; i < len(fields); i += 2 {
+		properties[fields[i]] = object{"type": fields[i+1]}
+	}
+	return properties
+}
+
+var schemas = object{
+	"Error": object{
+		"type":       "object",
+		"properties": props("error", "string"),
+	},
+	"Result": object{
+		"type":       "object",
+		"properties": props("result", "string"),
+	},
+	"GcodeRequest": object{
+		"type":       "object",
+		"required":   []string{"script"},
+		"properties": props("script", "string"),
+	},
+	"PrintRequest": object{
+		"type":       "object",
+		"required":   []string{"filename"},
+		"properties": props("filename", "string"),
+	},
+	"HeaterStatus": object{
+		"type":       "object",
+		"properties": props("temperature", "number", "target", "number", "power", "number"),
+	},
+	"PrinterSnapshot": object{
+		"type": "object",
+		"properties": func() object {
+			p := props("name", "string", "host", "string", "connected", "boolean", "error", "string",
+				"klippy_state", "string", "print_state", "string", "filename", "string", "progress", "number",
+				"print_duration", "number", "message", "string")
+			p["tags"] = object{"type": "array", "items": object{"type": "string"}}
+			p["heaters"] = object{"type": "object", "additionalProperties": schemaRef("HeaterStatus")}
+			return p
+		}(),
+	},
+	"FleetStatus": object{
+		"type": "object",
+		"properties": object{
+			"time":     object{"type": "string", "format": "date-time"},
+			"printers": object{"type": "array", "items": schemaRef("PrinterSnapshot")},
+		},
+	},
+	"ObjectStatus": object{
+		"type": "object",
+		"properties": object{
+			"eventtime": object{"type": "number"},
+			"status":    object{"type": "object", "additionalProperties": object{"type": "object"}},
+		},
+	},
+	"File": object{
+		"type":       "object",
+		"properties": props("path", "string", "modified", "number", "size", "integer", "permissions", "string"),
+	},
+	"FileList": object{
+		"type":  "array",
+		"items": schemaRef("File"),
+	},
+}
+
+func jsonContent(schema string) object {
+	return object{"application/json": object{"schema": schemaRef(schema)}}
+}
+
+func errorSpec(description string) object {
+	return object{"description": description, "content": jsonContent("Error")}
+}
+
+func (g *Gateway) operation(rt *route) object {
+	var params []object
+	for _, segment := range strings.Split(rt.path, "/") {
+		if strings.HasPrefix(segment, "{") {
+			params = append(params, object{
+				"name": strings.Trim(segment, "{}"), "in": "path", "required": true,
+				"schema": object{"type": "string"},
+			})
+		}
+	}
+	required := rt.body != ""
+	for _, q := range rt.query {
+		required = required || q.required
+		schema := object{"type": "string"}
+		if q.multiple {
+			schema = object{"type": "array", "items": schema}
+		}
+		params = append(params, object{"name": q.name, "in": "query", "description": q.description, "required": q.required, "schema": schema})
+	}
+
+	ok := object{"description": "OK"}
+	if rt.stream {
+		ok["description"] = "Server-sent events named status, each carrying a " + rt.result + " as data."
+		ok["content"] = object{"text/event-stream": object{"schema": object{"type": "string"}}}
+	} else if rt.result != "" {
+		ok["content"] = jsonContent(rt.result)
+	}
+	responses := object{"200": ok}
+	if required {
+		responses["400"] = errorSpec("Invalid request")
+	}
+	if rt.scope != "" && g.opts.Authorizer != nil {
+		responses["401"] = errorSpec("Missing or unknown credentials")
+		responses["403"] = errorSpec("Credentials lack the " + string(rt.scope) + " scope")
+	}
+	if strings.Contains(rt.path, "{printer}") {
+		responses["404"] = errorSpec("Unknown printer")
+		if !rt.stream && rt.operation != "getPrinter" {
+			responses["502"] = errorSpec("Moonraker rejected the request")
+			responses["503"] = errorSpec("Printer is disconnected")
+		}
+	}
+
+	op := object{"operationId": rt.operation, "summary": rt.summary, "responses": responses}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+	if rt.body != "" {
+		op["requestBody"] = object{"required": true, "content": jsonContent(rt.body)}
+	}
+	if rt.scope == "" {
+		op["security"] = []object{}
+	} else {
+		op["x-scope"] = rt.scope
+	}
+	return op
+}
+
+func (g *Gateway) openAPI() object {
+	paths := object{}
+	for _, rt := range g.routes {
+		item, ok := paths[rt.path].(object)
+		if !ok {
+			item = object{}
+			paths[rt.path] = item
+		}
+		item[strings.ToLower(rt.method)] = g.operation(rt)
+	}
+	doc := object{
+		"openapi": "3.0.3",
+		"info": object{
+			"title":       "Moonraker gateway",
+			"version":     APIVersion,
+			"description": "Status and control of a fleet of Klipper printers through Moonraker.",
+		},
+		"servers":    []object{{"url": "/"}},
+		"paths":      paths,
+		"components": object{"schemas": schemas},
+	}
+	if g.opts.Authorizer != nil {
+		doc["components"].(object)["securitySchemes"] = object{
+			"bearer": object{"type": "http", "scheme": "bearer"},
+			"apiKey": object{"type": "apiKey", "in": "header", "name": "X-Api-Key"},
+		}
+		doc["security"] = []object{{"bearer": []string{}}, {"apiKey": []string{}}}
+	}
+	return doc
+}
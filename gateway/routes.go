@@ -0,0 +1,210 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	moonraker "github.com/derek-elliott/go-moonraker"
+	"net/http"
+)
+
+type route struct {
+	method    string
+	path      string
+	operation string
+	summary   string
+	// scope is empty for routes anyone may use.
+	scope  Scope
+	query  []queryParam
+	body   string
+	result string
+	stream bool
+	handle func(w http.ResponseWriter, r *http.Request, params map[string]string)
+}
+
+type queryParam struct {
+	name        string
+	description string
+	multiple    bool
+	required    bool
+}
+
+var tagParam = queryParam{"tag", "Only include printers carrying all of these tags.", true, false}
+
+type GcodeRequest struct {
+	Script string `json:"script"`
+}
+
+type PrintRequest struct {
+	Filename string `json:"filename"`
+}
+
+type Result struct {
+	Result string `json:"result"`
+}
+
+var ok = Result{Result: "ok"}
+
+func (g *Gateway) routeTable() []*route {
+	return []*route{
+		{method: "GET", path: "/v1/openapi.json", operation: "getOpenAPI", summary: "OpenAPI description of this API",
+			handle: g.serveOpenAPI},
+		{method: "GET", path: "/v1/printers", operation: "listPrinters", summary: "Status of every printer in the fleet",
+			scope: ScopeRead, query: []queryParam{tagParam}, result: "FleetStatus", handle: g.listPrinters},
+		{method: "GET", path: "/v1/printers/{printer}", operation: "getPrinter", summary: "Status of a printer",
+			scope: ScopeRead, result: "PrinterSnapshot", handle: g.getPrinter},
+		{method: "GET", path: "/v1/printers/{printer}/objects", operation: "queryObjects", summary: "Query Klipper printer objects",
+			scope: ScopeRead, query: []queryParam{{"object", "Printer objects to query, like extruder or \"heater_generic chamber\".", true, true}},
+			result: "ObjectStatus", handle: g.withClient(queryObjects)},
+		{method: "GET", path: "/v1/printers/{printer}/files", operation: "listFiles", summary: "List files in a root",
+			scope: ScopeRead, query: []queryParam{{"root", "File root, gcodes by default.", false, false}},
+			result: "FileList", handle: g.withClient(listFiles)},
+		{method: "POST", path: "/v1/printers/{printer}/gcode", operation: "runGcode", summary: "Run a G-code script",
+			scope: ScopeControl, body: "GcodeRequest", result: "Result", handle: g.withClient(runGcode)},
+		{method: "POST", path: "/v1/printers/{printer}/print", operation: "startPrint", summary: "Start printing a file",
+			scope: ScopeControl, body: "PrintRequest", result: "Result", handle: g.withClient(startPrint)},
+		g.action("pause", "pausePrint", "Pause the current print", (*moonraker.MoonClient).PausePrint),
+		g.action("resume", "resumePrint", "Resume the paused print", (*moonraker.MoonClient).ResumePrint),
+		g.action("cancel", "cancelPrint", "Cancel the current print", (*moonraker.MoonClient).CancelPrint),
+		g.action("emergency_stop", "emergencyStop", "Emergency stop the printer", (*moonraker.MoonClient).EmergencyStop),
+		{method: "GET", path: "/v1/printers/{printer}/events", operation: "printerEvents", summary: "Stream status changes of a printer",
+			scope: ScopeRead, result: "PrinterSnapshot", stream: true, handle: g.printerEvents},
+		{method: "GET", path: "/v1/events", operation: "fleetEvents", summary: "Stream status changes of every printer",
+			scope: ScopeRead, query: []queryParam{tagParam}, result: "PrinterSnapshot", stream: true, handle: g.fleetEvents},
+	}
+}
+
+func (g *Gateway) action(name, operation, summary string, fn func(*moonraker.MoonClient) error) *route {
+	return &route{method: "POST", path: "/v1/printers/{printer}/" + name, operation: operation, summary: summary,
+		scope: ScopeControl, result: "Result",
+		handle: g.withClient(func(w http.ResponseWriter, r *http.Request, client *moonraker.MoonClient) {
+			if err := fn(client); err != nil {
+				writeUpstreamError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, ok)
+		})}
+}
+
+func (g *Gateway) serveOpenAPI(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(g.spec)
+}
+
+// member looks up a printer by name, answering 404 when it isn't part of the
+// fleet.
+func (g *Gateway) member(w http.ResponseWriter, name string) (moonraker.FleetMember, bool) {
+	for _, m := range g.fleet.Members() {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	writeError(w, http.StatusNotFound, fmt.Errorf("unknown printer: %s", name))
+	return moonraker.FleetMember{}, false
+}
+
+// withClient resolves the printer of a route, answering 503 while it is
+// disconnected.
+func (g *Gateway) withClient(fn func(w http.ResponseWriter, r *http.Request, client *moonraker.MoonClient)) func(http.ResponseWriter, *http.Request, map[string]string) {
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		if _, ok := g.member(w, params["printer"]); !ok {
+			return
+		}
+		client, err := g.fleet.Client(params["printer"])
+		if err != nil {
+			writeError(w, http.StatusServiceUnavailable, err)
+			return
+		}
+		fn(w, r, client)
+	}
+}
+
+// writeUpstreamError reports a failed Moonraker call as 502, since the
+// request itself was fine.
+func writeUpstreamError(w http.ResponseWriter, err error) {
+	writeError(w, http.StatusBadGateway, err)
+}
+
+func (g *Gateway) listPrinters(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	writeJSON(w, http.StatusOK, g.fleet.Status(r.URL.Query()["tag"]...))
+}
+
+func (g *Gateway) getPrinter(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	snap, err := g.fleet.Snapshot(params["printer"])
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, snap)
+}
+
+type ObjectStatus struct {
+	EventTime float64                    `json:"eventtime"`
+	Status    map[string]json.RawMessage `json:"status"`
+}
+
+func queryObjects(w http.ResponseWriter, r *http.Request, client *moonraker.MoonClient) {
+	objects := r.URL.Query()["object"]
+	if len(objects) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("at least one object is required"))
+		return
+	}
+	params := moonraker.QueryObjectParams{Objects: make(map[string]interface{}, len(objects))}
+	for _, object := range objects {
+		params.Objects[object] = nil
+	}
+	var status ObjectStatus
+	if err := client.QueryObject(params, &status); err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func listFiles(w http.ResponseWriter, r *http.Request, client *moonraker.MoonClient) {
+	root := r.URL.Query().Get("root")
+	if root == "" {
+		root = "gcodes"
+	}
+	files, err := client.ListFiles(root)
+	if err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+	if *files == nil {
+		*files = []*moonraker.MoonrakerFile{}
+	}
+	writeJSON(w, http.StatusOK, files)
+}
+
+func runGcode(w http.ResponseWriter, r *http.Request, client *moonraker.MoonClient) {
+	var req GcodeRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if req.Script == "" {
+		writeError(w, http.StatusBadRequest, errors.New("script is required"))
+		return
+	}
+	if err := client.RunGcode(req.Script); err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ok)
+}
+
+func startPrint(w http.ResponseWriter, r *http.Request, client *moonraker.MoonClient) {
+	var req PrintRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if req.Filename == "" {
+		writeError(w, http.StatusBadRequest, errors.New("filename is required"))
+		return
+	}
+	if err := client.Print(req.Filename); err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ok)
+}
@@ -0,0 +1,314 @@
+// Package gcodemeta extracts the metadata Moonraker's file scanner reports
+// from local G-code files, so files can be checked before they are uploaded.
+// PrusaSlicer, SuperSlicer, OrcaSlicer, Cura and ideaMaker output is
+// recognised.
+package gcodemeta
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	moonraker "github.com/derek-elliott/go-moonraker"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type Thumbnail struct {
+	Width, Height int
+	// Format is "png", "jpg" or "qoi".
+	Format string
+	Data   []byte
+}
+
+type Metadata struct {
+	Slicer        string
+	SlicerVersion string
+	// Heights are in millimetres.
+	LayerHeight      float64
+	FirstLayerHeight float64
+	ObjectHeight     float64
+	// FilamentTotal is the filament length in millimetres, summed over all
+	// extruders.
+	FilamentTotal float64
+	// EstimatedTime is the slicer's print time estimate in seconds.
+	EstimatedTime      float64
+	FirstLayerExtrTemp float64
+	FirstLayerBedTemp  float64
+	Thumbnails         []Thumbnail
+	Size               int64
+	// Modified is a Unix timestamp, set by ParseFile.
+	Modified float64
+}
+
+// GcodeMetadata converts the metadata into the form Moonraker reports for the
+// file once uploaded as filename. Thumbnail paths follow Moonraker's .thumbs
+// naming, though the images only exist once Moonraker has scanned the file.
+func (m *Metadata) GcodeMetadata(filename string) moonraker.GcodeMetadata {
+	meta := moonraker.GcodeMetadata{
+		Filename:           filename,
+		Size:               int(m.Size),
+		Modified:           m.Modified,
+		Slicer:             m.Slicer,
+		SlicerVersion:      m.SlicerVersion,
+		LayerHeight:        float32(m.LayerHeight),
+		FirstLayerHeight:   float32(m.FirstLayerHeight),
+		ObjectHeight:       float32(m.ObjectHeight),
+		FilamentTotal:      float32(m.FilamentTotal),
+		EstimatedTime:      m.EstimatedTime,
+		FirstLayerExtrTemp: m.FirstLayerExtrTemp,
+		FirstLayerBedTemp:  m.FirstLayerBedTemp,
+	}
+	base := strings.TrimSuffix(path.Base(filename), path.Ext(filename))
+	for _, t := range m.Thumbnails {
+		meta.Thumbnails = append(meta.Thumbnails, moonraker.Thumbnail{
+			Width:        t.Width,
+			Height:       t.Height,
+			Size:         len(t.Data),
+			RelativePath: fmt.Sprintf(".thumbs/%s-%dx%d.%s", base, t.Width, t.Height, t.Format),
+		})
+	}
+	return meta
+}
+
+func ParseFile(name string) (*Metadata, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	m, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	m.Modified = float64(info.ModTime().UnixNano()) / 1e9
+	return m, nil
+}
+
+// scan holds what a single pass over the file collects. Slicers then pick
+// their fields out of it.
+type scan struct {
+	slicer  *slicer
+	version string
+	// comments maps lower-cased comment keys, split at " = " or ":", to
+	// their last value.
+	comments map[string]string
+	// settings holds Cura's embedded ;SETTING_3 profile.
+	settings     map[string]string
+	curaSettings strings.Builder
+	// layerHeights maps ;LAYER:n to the ;HEIGHT: that follows it.
+	layerHeights map[int]float64
+	layer        int
+
+	firstExtrTemp, firstBedTemp float64
+	z, maxPrintZ                float64
+	thumbnails                  []Thumbnail
+	size                        int64
+}
+
+var thumbnailBegin = regexp.MustCompile(`^\s*thumbnail(?:_(PNG|JPG|QOI))? begin (\d+)x(\d+) (\d+)`)
+
+// Parse reads a whole G-code file and extracts its metadata. Files from
+// unknown slicers report "Unknown" as slicer, with whatever could be derived
+// from the G-code itself.
+func Parse(r io.Reader) (*Metadata, error) {
+	s := &scan{comments: make(map[string]string), settings: make(map[string]string), layerHeights: make(map[int]float64), layer: -1}
+	br := bufio.NewReaderSize(r, 64*1024)
+	var thumb *Thumbnail
+	var encoded strings.Builder
+	for {
+		line, err := br.ReadString('\n')
+		s.size += int64(len(line))
+		if line != "" {
+			line = strings.TrimRight(line, "\r\n")
+			if strings.HasPrefix(line, ";") {
+				comment := line[1:]
+				switch {
+				case thumb != nil && strings.Contains(comment, "thumbnail") && strings.Contains(comment, " end"):
+					if data, err := base64.StdEncoding.DecodeString(encoded.String()); err == nil {
+						thumb.Data = data
+						s.thumbnails = append(s.thumbnails, *thumb)
+					}
+					thumb = nil
+				case thumb != nil:
+					encoded.WriteString(strings.TrimSpace(comment))
+				default:
+					if m := thumbnailBegin.FindStringSubmatch(comment); m != nil {
+						format := strings.ToLower(m[1])
+						if format == "" {
+							format = "png"
+						}
+						w, _ := strconv.Atoi(m[2])
+						h, _ := strconv.Atoi(m[3])
+						thumb = &Thumbnail{Width: w, Height: h, Format: format}
+						encoded.Reset()
+					} else {
+						s.comment(comment)
+					}
+				}
+			} else {
+				s.command(line)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Cura escapes the profile's newlines twice.
+	settings := strings.NewReplacer(`\\n`, "\n", `\n`, "\n").Replace(s.curaSettings.String())
+	for _, line := range strings.Split(settings, "\n") {
+		if key, value, ok := strings.Cut(line, " = "); ok {
+			s.settings[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	m := &Metadata{Slicer: "Unknown", Size: s.size, Thumbnails: s.thumbnails}
+	if s.slicer != nil {
+		m.Slicer, m.SlicerVersion = s.slicer.name, s.version
+		s.slicer.extract(s, m)
+	}
+	if m.ObjectHeight == 0 {
+		m.ObjectHeight = s.maxPrintZ
+	}
+	if m.FirstLayerExtrTemp == 0 {
+		m.FirstLayerExtrTemp = s.firstExtrTemp
+	}
+	if m.FirstLayerBedTemp == 0 {
+		m.FirstLayerBedTemp = s.firstBedTemp
+	}
+	return m, nil
+}
+
+func (s *scan) comment(comment string) {
+	if s.slicer == nil {
+		for i := range slicers {
+			if m := slicers[i].detect.FindStringSubmatch(comment); m != nil {
+				s.slicer, s.version = &slicers[i], m[1]
+				break
+			}
+		}
+	}
+	if strings.HasPrefix(comment, "SETTING_3 ") {
+		s.curaSettings.WriteString(strings.TrimPrefix(comment, "SETTING_3 "))
+		return
+	}
+	key, value, ok := strings.Cut(comment, " = ")
+	if !ok {
+		key, value, ok = strings.Cut(comment, ":")
+	}
+	if !ok {
+		return
+	}
+	key = strings.ToLower(strings.TrimSpace(key))
+	value = strings.TrimSpace(value)
+	s.comments[key] = value
+	switch key {
+	case "layer":
+		s.layer, _ = strconv.Atoi(value)
+	case "height":
+		if _, seen := s.layerHeights[s.layer]; !seen && s.layer >= 0 {
+			s.layerHeights[s.layer], _ = strconv.ParseFloat(value, 64)
+		}
+	case "model printing time":
+		// OrcaSlicer puts several values on one line:
+		// "; model printing time: 1h 2m; total estimated time: 1h 9m".
+		for _, part := range strings.Split(comment, ";") {
+			if k, v, ok := strings.Cut(part, ":"); ok {
+				s.comments[strings.ToLower(strings.TrimSpace(k))] = strings.TrimSpace(v)
+			}
+		}
+	}
+}
+
+// command follows the toolhead's Z to find the height of the highest
+// extruding move, and notes the first heater temperatures set.
+func (s *scan) command(line string) {
+	if i := strings.IndexByte(line, ';'); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return
+	}
+	params := make(map[byte]float64, len(fields)-1)
+	for _, f := range fields[1:] {
+		if len(f) > 1 {
+			if v, err := strconv.ParseFloat(f[1:], 64); err == nil {
+				params[f[0]&^0x20] = v
+			}
+		}
+	}
+	switch strings.ToUpper(fields[0]) {
+	case "G0", "G1":
+		if z, ok := params['Z']; ok {
+			s.z = z
+		}
+		_, x := params['X']
+		_, y := params['Y']
+		if e, ok := params['E']; ok && e > 0 && (x || y) && s.z > s.maxPrintZ {
+			s.maxPrintZ = s.z
+		}
+	case "M104", "M109":
+		if temp := params['S']; temp > 0 && s.firstExtrTemp == 0 {
+			if tool, ok := params['T']; !ok || tool == 0 {
+				s.firstExtrTemp = temp
+			}
+		}
+	case "M140", "M190":
+		if temp := params['S']; temp > 0 && s.firstBedTemp == 0 {
+			s.firstBedTemp = temp
+		}
+	}
+}
+
+func parseFloat(value string) float64 {
+	v, _ := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	return v
+}
+
+// first parses the first entry of a comma separated per-extruder list.
+func first(value string) float64 {
+	v, _, _ := strings.Cut(value, ",")
+	return parseFloat(v)
+}
+
+// sum adds up a comma separated per-extruder list, dropping unit suffixes
+// like Cura's "1.2m".
+func sum(value, unit string) float64 {
+	var total float64
+	for _, v := range strings.Split(value, ",") {
+		total += parseFloat(strings.TrimSuffix(strings.TrimSpace(v), unit))
+	}
+	return total
+}
+
+var durationPart = regexp.MustCompile(`(\d+(?:\.\d+)?)\s*([dhms])`)
+
+// parseDuration reads slicer durations like "1d 2h 3m 4s".
+func parseDuration(value string) float64 {
+	var secs float64
+	for _, m := range durationPart.FindAllStringSubmatch(value, -1) {
+		v := parseFloat(m[1])
+		switch m[2] {
+		case "d":
+			secs += v * 86400
+		case "h":
+			secs += v * 3600
+		case "m":
+			secs += v * 60
+		case "s":
+			secs += v
+		}
+	}
+	return secs
+}
@@ -0,0 +1,216 @@
+package gcodemeta
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func thumbnailComment(t *testing.T, width, height int) string {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	encoded := base64.StdEncoding.EncodeToString(buf.Bytes())
+	var b strings.Builder
+	fmt.Fprintf(&b, ";\n; thumbnail begin %dx%d %d\n", width, height, len(encoded))
+	for len(encoded) > 0 {
+		n := 78
+		if n > len(encoded) {
+			n = len(encoded)
+		}
+		b.WriteString("; " + encoded[:n] + "\n")
+		encoded = encoded[n:]
+	}
+	b.WriteString("; thumbnail end\n;\n")
+	return b.String()
+}
+
+func TestParse_PrusaSlicer(t *testing.T) {
+	assert := assert.New(t)
+	gcode := "; generated by PrusaSlicer 2.6.0+linux-x64-GTK3 on 2023-06-20 at 10:00:00 UTC\n" +
+		thumbnailComment(t, 32, 32) + thumbnailComment(t, 300, 300) +
+		`M140 S60
+M104 S215
+G28
+G1 Z0.2 F720
+G1 X10 Y10 E1.5
+G1 Z10.2
+G1 X20 Y10 E2
+G1 Z30 ; park
+; filament used [mm] = 1234.56, 100.0
+; estimated printing time (normal mode) = 1d 2h 3m 4s
+; max_layer_z = 10.2
+; first_layer_bed_temperature = 65
+; first_layer_height = 0.25
+; first_layer_temperature = 220,215
+; layer_height = 0.2
+`
+	m, err := Parse(strings.NewReader(gcode))
+	assert.NoError(err)
+	assert.Equal("PrusaSlicer", m.Slicer)
+	assert.Equal("2.6.0+linux-x64-GTK3", m.SlicerVersion)
+	assert.Equal(0.2, m.LayerHeight)
+	assert.Equal(0.25, m.FirstLayerHeight)
+	assert.Equal(10.2, m.ObjectHeight)
+	assert.InDelta(1334.56, m.FilamentTotal, 1e-9)
+	assert.Equal(float64(93784), m.EstimatedTime)
+	assert.Equal(220.0, m.FirstLayerExtrTemp)
+	assert.Equal(65.0, m.FirstLayerBedTemp)
+	assert.Equal(int64(len(gcode)), m.Size)
+	if assert.Len(m.Thumbnails, 2) {
+		assert.Equal(300, m.Thumbnails[1].Width)
+		assert.Equal("png", m.Thumbnails[1].Format)
+		img, err := png.Decode(bytes.NewReader(m.Thumbnails[1].Data))
+		assert.NoError(err)
+		assert.Equal(300, img.Bounds().Dx())
+	}
+
+	meta := m.GcodeMetadata("voron/cube.gcode")
+	assert.Equal("voron/cube.gcode", meta.Filename)
+	assert.Equal(float32(0.25), meta.FirstLayerHeight)
+	assert.Equal(".thumbs/cube-300x300.png", meta.Thumbnails[1].RelativePath)
+	assert.Equal(len(m.Thumbnails[1].Data), meta.Thumbnails[1].Size)
+}
+
+func TestParse_SuperSlicer(t *testing.T) {
+	assert := assert.New(t)
+	m, err := Parse(strings.NewReader(`; generated by SuperSlicer 2.4.58.5 on 2023-01-01 at 10:00:00 UTC
+G1 Z0.3
+G1 X1 Y1 E1
+; first_layer_height = 75%
+; nozzle_diameter = 0.4,0.4
+; layer_height = 0.2
+`))
+	assert.NoError(err)
+	assert.Equal("SuperSlicer", m.Slicer)
+	assert.InDelta(0.3, m.FirstLayerHeight, 1e-9)
+	assert.Equal(0.3, m.ObjectHeight)
+}
+
+func TestParse_OrcaSlicer(t *testing.T) {
+	assert := assert.New(t)
+	m, err := Parse(strings.NewReader(`; HEADER_BLOCK_START
+; generated by OrcaSlicer 1.8.0 on 2023-11-01 at 12:00:00
+; model printing time: 1h 2m 3s; total estimated time: 1h 8m 3s
+; total filament length [mm] : 4321.5
+; max_z_height: 24.6
+; HEADER_BLOCK_END
+; thumbnail_JPG begin 2x2 8
+; /9j/4AAQ
+; thumbnail_JPG end
+M190 S100
+; curr_bed_type = Textured PEI Plate
+; textured_plate_temp_initial_layer = 65
+; hot_plate_temp_initial_layer = 70
+; nozzle_temperature_initial_layer = 250
+; initial_layer_print_height = 0.24
+; layer_height = 0.16
+`))
+	assert.NoError(err)
+	assert.Equal("OrcaSlicer", m.Slicer)
+	assert.Equal("1.8.0", m.SlicerVersion)
+	assert.Equal(float64(4083), m.EstimatedTime)
+	assert.Equal(4321.5, m.FilamentTotal)
+	assert.Equal(24.6, m.ObjectHeight)
+	assert.Equal(0.16, m.LayerHeight)
+	assert.Equal(0.24, m.FirstLayerHeight)
+	assert.Equal(250.0, m.FirstLayerExtrTemp)
+	assert.Equal(65.0, m.FirstLayerBedTemp)
+	if assert.Len(m.Thumbnails, 1) {
+		assert.Equal("jpg", m.Thumbnails[0].Format)
+		assert.Equal([]byte{0xff, 0xd8, 0xff, 0xe0, 0x00, 0x10}, m.Thumbnails[0].Data)
+	}
+}
+
+func TestParse_Cura(t *testing.T) {
+	assert := assert.New(t)
+	m, err := Parse(strings.NewReader(`;FLAVOR:Marlin
+;TIME:6127
+;Filament used: 1.5m, 0.25m
+;Layer height: 0.2
+;MINX:10
+;MINZ:0.3
+;MAXZ:15.1
+;Generated with Cura_SteamEngine 5.4.0
+M140 S50
+M109 S200
+;LAYER:0
+G0 Z0.3
+G1 X1 Y1 E1
+;End of Gcode
+;SETTING_3 {"global_quality": "[general]\\nversion = 4\\nname = Fine\\n\\n[values]\\nlayer_height = 0
+;SETTING_3 .2\\nlayer_height_0 = 0.3\\n", "extruder_quality": ["[values]\\nmaterial_print_temperature_l
+;SETTING_3 ayer_0 = 205\\nmaterial_bed_temperature_layer_0 = 55\\n"]}
+`))
+	assert.NoError(err)
+	assert.Equal("Cura", m.Slicer)
+	assert.Equal("5.4.0", m.SlicerVersion)
+	assert.Equal(float64(6127), m.EstimatedTime)
+	assert.Equal(1750.0, m.FilamentTotal)
+	assert.Equal(0.2, m.LayerHeight)
+	assert.Equal(0.3, m.FirstLayerHeight)
+	assert.Equal(15.1, m.ObjectHeight)
+	assert.Equal(205.0, m.FirstLayerExtrTemp)
+	assert.Equal(55.0, m.FirstLayerBedTemp)
+}
+
+func TestParse_IdeaMaker(t *testing.T) {
+	assert := assert.New(t)
+	m, err := Parse(strings.NewReader(`;Sliced by ideaMaker 4.2.1.6011, 2022-03-01
+;Dimension:253.000000 253.000000 300.000000 0.400000
+;Bounding Box:84.200 168.800 84.200 168.800 0.300 20.100
+;Print Time: 2571
+;Material#1 Used: 1250.5
+;Material#2 Used: 100
+M190 S60
+M109 T0 S210
+;LAYER:0
+;Z:0.3
+;HEIGHT:0.3
+G1 Z0.3
+G1 X90 Y90 E2
+;LAYER:1
+;Z:0.5
+;HEIGHT:0.2
+G1 Z0.5
+`))
+	assert.NoError(err)
+	assert.Equal("ideaMaker", m.Slicer)
+	assert.Equal("4.2.1.6011", m.SlicerVersion)
+	assert.Equal(0.3, m.FirstLayerHeight)
+	assert.Equal(0.2, m.LayerHeight)
+	assert.Equal(20.1, m.ObjectHeight)
+	assert.Equal(1350.5, m.FilamentTotal)
+	assert.Equal(float64(2571), m.EstimatedTime)
+	assert.Equal(210.0, m.FirstLayerExtrTemp)
+	assert.Equal(60.0, m.FirstLayerBedTemp)
+}
+
+func TestParseFile_Unknown(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "hand.gcode")
+	os.WriteFile(path, []byte("M104 T1 S240\nM104 S200\nG1 Z0.2\nG1 X5 E1\nG1 Z5\n"), 0o644)
+	m, err := ParseFile(path)
+	assert.NoError(err)
+	assert.Equal("Unknown", m.Slicer)
+	assert.Equal(0.2, m.ObjectHeight)
+	assert.Equal(200.0, m.FirstLayerExtrTemp)
+	assert.NotZero(m.Modified)
+
+	_, err = ParseFile(filepath.Join(t.TempDir(), "missing.gcode"))
+	assert.Error(err)
+}
+
+func TestParseDuration(t *testing.T) {
+	assert.Equal(t, float64(3723), parseDuration("1h 2m 3s"))
+	assert.Equal(t, float64(45), parseDuration("45s"))
+	assert.Equal(t, float64(0), parseDuration(""))
+}
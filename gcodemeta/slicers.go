@@ -0,0 +1,106 @@
+package gcodemeta
+
+import (
+	"regexp"
+	"strings"
+)
+
+type slicer struct {
+	name string
+	// detect matches the slicer's header comment, capturing its version.
+	detect  *regexp.Regexp
+	extract func(s *scan, m *Metadata)
+}
+
+var slicers = []slicer{
+	{"PrusaSlicer", regexp.MustCompile(`^\s*generated by PrusaSlicer (\S+)`), prusaFamily},
+	{"SuperSlicer", regexp.MustCompile(`^\s*generated by SuperSlicer (\S+)`), prusaFamily},
+	{"OrcaSlicer", regexp.MustCompile(`^\s*generated by OrcaSlicer (\S+)`), prusaFamily},
+	{"Cura", regexp.MustCompile(`^Generated with Cura_SteamEngine (\S+)`), cura},
+	{"ideaMaker", regexp.MustCompile(`^Sliced by ideaMaker (\S+?),`), ideaMaker},
+}
+
+// lookup returns the first of keys present in the comments.
+func (s *scan) lookup(keys ...string) (string, bool) {
+	for _, key := range keys {
+		if v, ok := s.comments[key]; ok && v != "" {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// orcaBedTemps maps OrcaSlicer's curr_bed_type to the setting holding that
+// plate's first layer temperature.
+var orcaBedTemps = map[string]string{
+	"cool plate":         "cool_plate_temp_initial_layer",
+	"engineering plate":  "eng_plate_temp_initial_layer",
+	"high temp plate":    "hot_plate_temp_initial_layer",
+	"textured pei plate": "textured_plate_temp_initial_layer",
+}
+
+// prusaFamily reads the config block PrusaSlicer and its forks append to
+// the file, along with the print statistics above it.
+func prusaFamily(s *scan, m *Metadata) {
+	m.LayerHeight = parseFloat(s.comments["layer_height"])
+	if v, ok := s.lookup("first_layer_height", "initial_layer_print_height"); ok {
+		if strings.HasSuffix(v, "%") {
+			// SuperSlicer allows a percentage of the nozzle diameter.
+			m.FirstLayerHeight = parseFloat(strings.TrimSuffix(v, "%")) / 100 * first(s.comments["nozzle_diameter"])
+		} else {
+			m.FirstLayerHeight = parseFloat(v)
+		}
+	}
+	if v, ok := s.lookup("max_layer_z", "max_z_height"); ok {
+		m.ObjectHeight = parseFloat(v)
+	}
+	if v, ok := s.lookup("filament used [mm]", "total filament length [mm]"); ok {
+		m.FilamentTotal = sum(v, "")
+	}
+	if v, ok := s.lookup("estimated printing time (normal mode)", "total estimated time"); ok {
+		m.EstimatedTime = parseDuration(v)
+	}
+	if v, ok := s.lookup("first_layer_temperature", "nozzle_temperature_initial_layer"); ok {
+		m.FirstLayerExtrTemp = first(v)
+	}
+	if v, ok := s.lookup("first_layer_bed_temperature"); ok {
+		m.FirstLayerBedTemp = first(v)
+	} else if key, ok := orcaBedTemps[strings.ToLower(s.comments["curr_bed_type"])]; ok {
+		m.FirstLayerBedTemp = first(s.comments[key])
+	}
+}
+
+// cura reads the header Cura writes and the profile it embeds in the
+// ;SETTING_3 comments at the end of the file.
+func cura(s *scan, m *Metadata) {
+	m.LayerHeight = parseFloat(s.comments["layer height"])
+	if m.LayerHeight == 0 {
+		m.LayerHeight = parseFloat(s.settings["layer_height"])
+	}
+	m.FirstLayerHeight = parseFloat(s.settings["layer_height_0"])
+	if m.FirstLayerHeight == 0 {
+		m.FirstLayerHeight = parseFloat(s.comments["minz"])
+	}
+	m.ObjectHeight = parseFloat(s.comments["maxz"])
+	m.FilamentTotal = sum(s.comments["filament used"], "m") * 1000
+	m.EstimatedTime = parseFloat(s.comments["time"])
+	m.FirstLayerExtrTemp = parseFloat(s.settings["material_print_temperature_layer_0"])
+	m.FirstLayerBedTemp = parseFloat(s.settings["material_bed_temperature_layer_0"])
+}
+
+// ideaMaker reports each layer's thickness and the print's bounding box. The
+// first layer temperatures come from the M109/M190 commands.
+func ideaMaker(s *scan, m *Metadata) {
+	m.FirstLayerHeight = s.layerHeights[0]
+	m.LayerHeight = s.layerHeights[1]
+	// ";Bounding Box: minX maxX minY maxY minZ maxZ"
+	if box := strings.Fields(s.comments["bounding box"]); len(box) == 6 {
+		m.ObjectHeight = parseFloat(box[5])
+	}
+	for key, value := range s.comments {
+		if strings.HasPrefix(key, "material#") && strings.HasSuffix(key, " used") {
+			m.FilamentTotal += parseFloat(value)
+		}
+	}
+	m.EstimatedTime = parseFloat(s.comments["print time"])
+}
@@ -0,0 +1,164 @@
+package go_moonraker
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type HealthAlertKind string
+
+const (
+	AlertUnderVoltage     HealthAlertKind = "under_voltage"
+	AlertFrequencyCapped  HealthAlertKind = "frequency_capped"
+	AlertThrottled        HealthAlertKind = "throttled"
+	AlertTemperatureLimit HealthAlertKind = "temperature_limit"
+	AlertHighCPUTemp      HealthAlertKind = "high_cpu_temp"
+	AlertMemoryGrowth     HealthAlertKind = "memory_growth"
+)
+
+type HealthAlert struct {
+	Kind    HealthAlertKind `json:"kind"`
+	Message string          `json:"message"`
+	Value   float64         `json:"value"`
+	Time    time.Time       `json:"time"`
+}
+
+type HealthMonitorOptions struct {
+	// CPU temperature in °C above which an alert is raised.
+	MaxCPUTemp float64
+	// Moonraker memory growth, as a fraction of the lowest usage seen within
+	// MemoryWindow, above which an alert is raised.
+	MaxMemoryGrowth float64
+	MemoryWindow    time.Duration
+	// Minimum time between two alerts of the same kind.
+	Cooldown time.Duration
+	OnAlert  func(HealthAlert)
+}
+
+type memorySample struct {
+	time   time.Time
+	memory int
+}
+
+type HealthMonitor struct {
+	opts HealthMonitorOptions
+
+	mu        sync.Mutex
+	memory    []memorySample
+	lastAlert map[HealthAlertKind]time.Time
+	throttled ThrottledState
+	now       func() time.Time
+}
+
+func NewHealthMonitor(opts HealthMonitorOptions) *HealthMonitor {
+	if opts.MaxCPUTemp <= 0 {
+		opts.MaxCPUTemp = 80
+	}
+	if opts.MaxMemoryGrowth <= 0 {
+		opts.MaxMemoryGrowth = 0.5
+	}
+	if opts.MemoryWindow <= 0 {
+		opts.MemoryWindow = time.Hour
+	}
+	if opts.Cooldown <= 0 {
+		opts.Cooldown = 5 * time.Minute
+	}
+	return &HealthMonitor{
+		opts:      opts,
+		lastAlert: make(map[HealthAlertKind]time.Time),
+		now:       time.Now,
+	}
+}
+
+var throttledAlerts = []struct {
+	cond ThrottledCondition
+	kind HealthAlertKind
+}{
+	{UnderVoltage, AlertUnderVoltage},
+	{FrequencyCapped, AlertFrequencyCapped},
+	{Throttled, AlertThrottled},
+	{TemperatureLimit, AlertTemperatureLimit},
+}
+
+// ObserveThrottled raises an alert for every active throttling condition
+// that was not active in the previously observed state.
+func (m *HealthMonitor) ObserveThrottled(state ThrottledState) []HealthAlert {
+	m.mu.Lock()
+	var alerts []HealthAlert
+	for _, t := range throttledAlerts {
+		if state.Has(t.cond) && !m.throttled.Has(t.cond) {
+			alerts = m.raise(alerts, t.kind, t.cond.String(), float64(state.Bits))
+		}
+	}
+	m.throttled = state
+	m.mu.Unlock()
+	return m.deliver(alerts)
+}
+
+func (m *HealthMonitor) ObserveStats(update *ProcStatUpdate) []HealthAlert {
+	m.mu.Lock()
+	var alerts []HealthAlert
+	if temp := float64(update.CpuTemp); temp > m.opts.MaxCPUTemp {
+		alerts = m.raise(alerts, AlertHighCPUTemp, fmt.Sprintf("CPU temperature %.1f°C exceeds %.1f°C", temp, m.opts.MaxCPUTemp), temp)
+	}
+	if mem := update.MoonrakerStats.Memory; mem > 0 {
+		now := m.now()
+		m.memory = append(m.memory, memorySample{now, mem})
+		cutoff := now.Add(-m.opts.MemoryWindow)
+		for len(m.memory) > 1 && m.memory[0].time.Before(cutoff) {
+			m.memory = m.memory[1:]
+		}
+		low := m.memory[0].memory
+		for _, sample := range m.memory {
+			if sample.memory < low {
+				low = sample.memory
+			}
+		}
+		if growth := float64(mem-low) / float64(low); growth > m.opts.MaxMemoryGrowth {
+			alerts = m.raise(alerts, AlertMemoryGrowth, fmt.Sprintf("Moonraker memory grew %.0f%% to %d %s within %s",
+				growth*100, mem, update.MoonrakerStats.MemUnits, m.opts.MemoryWindow), growth)
+		}
+	}
+	m.mu.Unlock()
+	return m.deliver(alerts)
+}
+
+// The caller must hold m.mu.
+func (m *HealthMonitor) raise(alerts []HealthAlert, kind HealthAlertKind, msg string, value float64) []HealthAlert {
+	now := m.now()
+	if last, ok := m.lastAlert[kind]; ok && now.Sub(last) < m.opts.Cooldown {
+		return alerts
+	}
+	m.lastAlert[kind] = now
+	return append(alerts, HealthAlert{Kind: kind, Message: msg, Value: value, Time: now})
+}
+
+// deliver passes alerts to OnAlert. It is called without m.mu held, so the
+// callback may use the monitor.
+func (m *HealthMonitor) deliver(alerts []HealthAlert) []HealthAlert {
+	if m.opts.OnAlert != nil {
+		for _, alert := range alerts {
+			m.opts.OnAlert(alert)
+		}
+	}
+	return alerts
+}
+
+// MonitorHealth seeds a HealthMonitor from the current proc stats and keeps
+// feeding it from proc stat and throttling notifications until the returned
+// function is called.
+func (c *MoonClient) MonitorHealth(opts HealthMonitorOptions) (*HealthMonitor, func(), error) {
+	monitor := NewHealthMonitor(opts)
+	stats, err := c.ProcStats()
+	if err != nil {
+		return nil, nil, err
+	}
+	monitor.ObserveThrottled(stats.ThrottledState)
+	cancelStats := c.OnProcStatUpdate(func(update *ProcStatUpdate) { monitor.ObserveStats(update) })
+	cancelThrottled := c.OnCPUThrottled(func(state *ThrottledState) { monitor.ObserveThrottled(*state) })
+	return monitor, func() {
+		cancelStats()
+		cancelThrottled()
+	}, nil
+}
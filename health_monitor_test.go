@@ -0,0 +1,103 @@
+package go_moonraker
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+const procStatsJSON = `{
+	"moonraker_stats": [{"time": 1626612666.85, "cpu_usage": 2.66, "memory": 24732, "mem_units": "kB"}],
+	"throttled_state": {"bits": 327685, "flags": ["Under-Voltage Detected", "Currently Throttled", "Previously Under-Volted", "Previously Throttled"]},
+	"cpu_temp": 45.622,
+	"network": {
+		"lo": {"rx_bytes": 113516429, "tx_bytes": 113516429, "bandwidth": 3342.68},
+		"wlan0": {"rx_bytes": 48471767, "tx_bytes": 113430843, "rx_drop": 2, "bandwidth": 4455.91}
+	},
+	"system_cpu_usage": {"cpu": 2.53, "cpu0": 3.03, "cpu1": 5.1, "cpu2": 1.02, "cpu3": 1.02},
+	"system_memory": {"total": 8038676, "available": 7281916, "used": 756760},
+	"system_uptime": 2876970.38,
+	"websocket_connections": 4
+}`
+
+func TestProcStats_Decode(t *testing.T) {
+	assert := assert.New(t)
+	var stats ProcStats
+	assert.NoError(json.Unmarshal([]byte(procStatsJSON), &stats))
+	assert.InDelta(2.66, stats.MoonrakerStats[0].CPUUsage, 0.001)
+	assert.Equal(int64(113430843), stats.Network["wlan0"].TxBytes)
+	assert.InDelta(4455.91, stats.Network["wlan0"].Bandwidth, 0.001)
+	assert.Equal(2.53, stats.SystemCpuUsage.Total())
+	assert.Equal([]float64{3.03, 5.1, 1.02, 1.02}, stats.SystemCpuUsage.Cores())
+	assert.Equal(756760, stats.SystemMemory.Used)
+	assert.Equal([]ThrottledCondition{UnderVoltage, Throttled, PreviouslyUnderVolted, PreviouslyThrottled}, stats.ThrottledState.Conditions())
+	for i, cond := range stats.ThrottledState.Conditions() {
+		assert.Equal(stats.ThrottledState.Flags[i], cond.String())
+	}
+}
+
+func TestHealthMonitor(t *testing.T) {
+	assert := assert.New(t)
+	var delivered []HealthAlert
+	m := NewHealthMonitor(HealthMonitorOptions{
+		MaxCPUTemp:      70,
+		MaxMemoryGrowth: 0.2,
+		MemoryWindow:    10 * time.Minute,
+		Cooldown:        time.Minute,
+		OnAlert:         func(alert HealthAlert) { delivered = append(delivered, alert) },
+	})
+	now := time.Date(2022, 7, 18, 12, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+
+	alerts := m.ObserveThrottled(ThrottledState{Bits: 1 << 16})
+	assert.Empty(alerts)
+	alerts = m.ObserveThrottled(ThrottledState{Bits: 1<<0 | 1<<2 | 1<<16})
+	assert.Len(alerts, 2)
+	assert.Equal(AlertUnderVoltage, alerts[0].Kind)
+	assert.Equal(AlertThrottled, alerts[1].Kind)
+	assert.Empty(m.ObserveThrottled(ThrottledState{Bits: 1<<0 | 1<<2 | 1<<16}))
+
+	stats := func(temp float32, memory int) *ProcStatUpdate {
+		return &ProcStatUpdate{CpuTemp: temp, MoonrakerStats: MoonrakerStats{Memory: memory, MemUnits: "kB"}}
+	}
+	assert.Empty(m.ObserveStats(stats(50, 20000)))
+	alerts = m.ObserveStats(stats(75, 21000))
+	assert.Len(alerts, 1)
+	assert.Equal(AlertHighCPUTemp, alerts[0].Kind)
+	now = now.Add(30 * time.Second)
+	assert.Empty(m.ObserveStats(stats(76, 22000)), "cooldown suppresses repeated alert")
+
+	now = now.Add(2 * time.Minute)
+	alerts = m.ObserveStats(stats(60, 25000))
+	assert.Len(alerts, 1)
+	assert.Equal(AlertMemoryGrowth, alerts[0].Kind)
+	assert.InDelta(0.25, alerts[0].Value, 0.001)
+
+	now = now.Add(20 * time.Minute)
+	assert.Empty(m.ObserveStats(stats(60, 25500)), "old samples leave the window")
+	assert.Len(delivered, 4)
+}
+
+func TestHealthMonitor_AlertCallbackUsesMonitor(t *testing.T) {
+	var m *HealthMonitor
+	var nested []HealthAlert
+	m = NewHealthMonitor(HealthMonitorOptions{OnAlert: func(alert HealthAlert) {
+		if alert.Kind == AlertHighCPUTemp {
+			nested = m.ObserveThrottled(ThrottledState{Bits: 1 << 0})
+		}
+	}})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.ObserveStats(&ProcStatUpdate{CpuTemp: 90})
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("OnAlert deadlocked calling back into the monitor")
+	}
+	if assert.Len(t, nested, 1) {
+		assert.Equal(t, AlertUnderVoltage, nested[0].Kind)
+	}
+}
@@ -0,0 +1,282 @@
+package go_moonraker
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+type OutcomeStats struct {
+	Total       int     `json:"total"`
+	Completed   int     `json:"completed"`
+	Failed      int     `json:"failed"`
+	Cancelled   int     `json:"cancelled"`
+	InProgress  int     `json:"in_progress"`
+	SuccessRate float64 `json:"success_rate"`
+	FailureRate float64 `json:"failure_rate"`
+	CancelRate  float64 `json:"cancel_rate"`
+}
+
+type OutcomeReport struct {
+	Overall  OutcomeStats             `json:"overall"`
+	ByFile   map[string]*OutcomeStats `json:"by_file"`
+	BySlicer map[string]*OutcomeStats `json:"by_slicer"`
+}
+
+type EstimateStats struct {
+	Samples          int     `json:"samples"`
+	MeanErrorSeconds float64 `json:"mean_error_seconds"`
+	MeanAbsError     float64 `json:"mean_abs_error_seconds"`
+	MeanErrorPercent float64 `json:"mean_error_percent"`
+	MeanAbsPercent   float64 `json:"mean_abs_error_percent"`
+}
+
+type EstimateReport struct {
+	Overall  EstimateStats             `json:"overall"`
+	BySlicer map[string]*EstimateStats `json:"by_slicer"`
+}
+
+type UsagePeriod string
+
+const (
+	UsagePerDay  UsagePeriod = "day"
+	UsagePerWeek UsagePeriod = "week"
+)
+
+type FilamentUsage struct {
+	Start        time.Time `json:"start"`
+	Jobs         int       `json:"jobs"`
+	FilamentUsed float64   `json:"filament_used"`
+}
+
+type UtilizationReport struct {
+	Start         time.Time     `json:"start"`
+	End           time.Time     `json:"end"`
+	BusyTime      time.Duration `json:"busy_time"`
+	PrintTime     time.Duration `json:"print_time"`
+	Jobs          int           `json:"jobs"`
+	Utilization   float64       `json:"utilization"`
+	PrintingRatio float64       `json:"printing_ratio"`
+}
+
+type HistoryReport struct {
+	Outcomes       OutcomeReport     `json:"outcomes"`
+	Estimates      EstimateReport    `json:"estimates"`
+	FilamentByDay  []FilamentUsage   `json:"filament_by_day"`
+	FilamentByWeek []FilamentUsage   `json:"filament_by_week"`
+	Utilization    UtilizationReport `json:"utilization"`
+}
+
+func (s *OutcomeStats) add(job *Job) {
+	s.Total++
+	switch job.Status {
+	case "completed":
+		s.Completed++
+	case "cancelled":
+		s.Cancelled++
+	case "in_progress":
+		s.InProgress++
+	default:
+		s.Failed++
+	}
+	if finished := s.Total - s.InProgress; finished > 0 {
+		s.SuccessRate = float64(s.Completed) / float64(finished)
+		s.FailureRate = float64(s.Failed) / float64(finished)
+		s.CancelRate = float64(s.Cancelled) / float64(finished)
+	}
+}
+
+func slicerName(job *Job) string {
+	if job.Metadata.Slicer == "" {
+		return "unknown"
+	}
+	return job.Metadata.Slicer
+}
+
+func AnalyzeOutcomes(jobs []Job) OutcomeReport {
+	report := OutcomeReport{
+		ByFile:   make(map[string]*OutcomeStats),
+		BySlicer: make(map[string]*OutcomeStats),
+	}
+	for i := range jobs {
+		job := &jobs[i]
+		report.Overall.add(job)
+		if report.ByFile[job.Filename] == nil {
+			report.ByFile[job.Filename] = &OutcomeStats{}
+		}
+		report.ByFile[job.Filename].add(job)
+		slicer := slicerName(job)
+		if report.BySlicer[slicer] == nil {
+			report.BySlicer[slicer] = &OutcomeStats{}
+		}
+		report.BySlicer[slicer].add(job)
+	}
+	return report
+}
+
+func (s *EstimateStats) add(estimated, actual float64) {
+	diff := actual - estimated
+	pct := diff / estimated * 100
+	n := float64(s.Samples)
+	s.MeanErrorSeconds = (s.MeanErrorSeconds*n + diff) / (n + 1)
+	s.MeanAbsError = (s.MeanAbsError*n + math.Abs(diff)) / (n + 1)
+	s.MeanErrorPercent = (s.MeanErrorPercent*n + pct) / (n + 1)
+	s.MeanAbsPercent = (s.MeanAbsPercent*n + math.Abs(pct)) / (n + 1)
+	s.Samples++
+}
+
+// Only completed jobs are compared, since an aborted print says nothing about
+// how good the slicer's estimate was.
+func AnalyzeEstimates(jobs []Job) EstimateReport {
+	report := EstimateReport{BySlicer: make(map[string]*EstimateStats)}
+	for i := range jobs {
+		job := &jobs[i]
+		estimated := job.Metadata.EstimatedTime
+		if job.Status != "completed" || estimated <= 0 || job.PrintDuration <= 0 {
+			continue
+		}
+		report.Overall.add(estimated, job.PrintDuration)
+		slicer := slicerName(job)
+		if report.BySlicer[slicer] == nil {
+			report.BySlicer[slicer] = &EstimateStats{}
+		}
+		report.BySlicer[slicer].add(estimated, job.PrintDuration)
+	}
+	return report
+}
+
+func periodStart(t time.Time, period UsagePeriod) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	if period == UsagePerWeek {
+		offset := (int(day.Weekday()) + 6) % 7
+		return day.AddDate(0, 0, -offset)
+	}
+	return day
+}
+
+func AnalyzeFilamentUsage(jobs []Job, period UsagePeriod, loc *time.Location) []FilamentUsage {
+	if loc == nil {
+		loc = time.Local
+	}
+	buckets := make(map[time.Time]*FilamentUsage)
+	for i := range jobs {
+		job := &jobs[i]
+		if job.StartTime <= 0 {
+			continue
+		}
+		start := periodStart(unixTime(job.StartTime).In(loc), period)
+		bucket, ok := buckets[start]
+		if !ok {
+			bucket = &FilamentUsage{Start: start}
+			buckets[start] = bucket
+		}
+		bucket.Jobs++
+		bucket.FilamentUsed += float64(job.FilamentUsed)
+	}
+	usage := make([]FilamentUsage, 0, len(buckets))
+	for _, bucket := range buckets {
+		usage = append(usage, *bucket)
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Start.Before(usage[j].Start) })
+	return usage
+}
+
+func AnalyzeUtilization(jobs []Job, start, end time.Time) UtilizationReport {
+	report := UtilizationReport{Start: start, End: end}
+	window := end.Sub(start)
+	if window <= 0 {
+		return report
+	}
+	for i := range jobs {
+		job := &jobs[i]
+		if job.StartTime <= 0 {
+			continue
+		}
+		jobStart := unixTime(job.StartTime)
+		jobEnd := jobStart.Add(seconds(job.TotalDuration))
+		if job.EndTime > 0 {
+			jobEnd = unixTime(job.EndTime)
+		}
+		if jobStart.Before(start) {
+			jobStart = start
+		}
+		if jobEnd.After(end) {
+			jobEnd = end
+		}
+		busy := jobEnd.Sub(jobStart)
+		if busy <= 0 {
+			continue
+		}
+		report.Jobs++
+		report.BusyTime += busy
+		if job.TotalDuration > 0 {
+			report.PrintTime += time.Duration(float64(busy) * math.Min(job.PrintDuration/job.TotalDuration, 1))
+		}
+	}
+	report.Utilization = float64(report.BusyTime) / float64(window) * 100
+	report.PrintingRatio = float64(report.PrintTime) / float64(window) * 100
+	return report
+}
+
+func AnalyzeHistory(jobs []Job, start, end time.Time, loc *time.Location) *HistoryReport {
+	return &HistoryReport{
+		Outcomes:       AnalyzeOutcomes(jobs),
+		Estimates:      AnalyzeEstimates(jobs),
+		FilamentByDay:  AnalyzeFilamentUsage(jobs, UsagePerDay, loc),
+		FilamentByWeek: AnalyzeFilamentUsage(jobs, UsagePerWeek, loc),
+		Utilization:    AnalyzeUtilization(jobs, start, end),
+	}
+}
+
+func (c *MoonClient) JobHistoryAll(since float64, before float64) ([]Job, error) {
+	var jobs []Job
+	err := c.WalkJobHistory(0, since, before, "asc", func(job *Job) error {
+		jobs = append(jobs, *job)
+		return nil
+	})
+	return jobs, err
+}
+
+// AnalyzeJobHistory analyses the jobs that started between start and end.
+// Moonraker filters history on start time, so for utilization it also fetches
+// jobs that started up to the longest recorded job before start, which may
+// still have been running when the window opened.
+func (c *MoonClient) AnalyzeJobHistory(start, end time.Time) (*HistoryReport, error) {
+	since := start
+	if !start.IsZero() {
+		totals, err := c.JobHistoryTotals()
+		if err != nil {
+			return nil, err
+		}
+		since = start.Add(-seconds(math.Max(totals.JobTotals.LongestJob, totals.JobTotals.LongestPrint)))
+	}
+	jobs, err := c.JobHistoryAll(unixSeconds(since), unixSeconds(end))
+	if err != nil {
+		return nil, err
+	}
+	started := make([]Job, 0, len(jobs))
+	for _, job := range jobs {
+		if !unixTime(job.StartTime).Before(start) {
+			started = append(started, job)
+		}
+	}
+	report := AnalyzeHistory(started, start, end, start.Location())
+	report.Utilization = AnalyzeUtilization(jobs, start, end)
+	return report, nil
+}
+
+func unixTime(ts float64) time.Time {
+	sec := int64(ts)
+	return time.Unix(sec, int64((ts-float64(sec))*1e9))
+}
+
+func unixSeconds(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.UnixNano()) / 1e9
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
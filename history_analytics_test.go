@@ -0,0 +1,89 @@
+package go_moonraker
+
+import (
+	"context"
+	"github.com/creachadair/jrpc2/handler"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+var analyticsJobs = []Job{
+	{Filename: "a.gcode", Status: "completed", StartTime: 1650000000, EndTime: 1650003600, PrintDuration: 3300, TotalDuration: 3600, FilamentUsed: 1000, Metadata: GcodeMetadata{Slicer: "PrusaSlicer", EstimatedTime: 3000}},
+	{Filename: "a.gcode", Status: "cancelled", StartTime: 1650010000, EndTime: 1650010600, PrintDuration: 600, TotalDuration: 600, FilamentUsed: 100, Metadata: GcodeMetadata{Slicer: "PrusaSlicer", EstimatedTime: 3000}},
+	{Filename: "b.gcode", Status: "error", StartTime: 1650090000, EndTime: 1650091800, PrintDuration: 1800, TotalDuration: 1800, FilamentUsed: 400, Metadata: GcodeMetadata{Slicer: "Cura", EstimatedTime: 2000}},
+	{Filename: "b.gcode", Status: "completed", StartTime: 1650100000, EndTime: 1650101800, PrintDuration: 1800, TotalDuration: 1800, FilamentUsed: 500, Metadata: GcodeMetadata{Slicer: "Cura", EstimatedTime: 2000}},
+}
+
+func TestAnalyzeOutcomes(t *testing.T) {
+	assert := assert.New(t)
+	report := AnalyzeOutcomes(analyticsJobs)
+	assert.Equal(4, report.Overall.Total)
+	assert.Equal(0.5, report.Overall.SuccessRate)
+	assert.Equal(0.25, report.Overall.FailureRate)
+	assert.Equal(0.25, report.Overall.CancelRate)
+	assert.Equal(1, report.ByFile["a.gcode"].Cancelled)
+	assert.Equal(0.5, report.BySlicer["Cura"].FailureRate)
+}
+
+func TestAnalyzeEstimates(t *testing.T) {
+	assert := assert.New(t)
+	report := AnalyzeEstimates(analyticsJobs)
+	assert.Equal(2, report.Overall.Samples)
+	assert.InDelta(50, report.Overall.MeanErrorSeconds, 0.001)
+	assert.InDelta(10, report.BySlicer["PrusaSlicer"].MeanErrorPercent, 0.001)
+	assert.InDelta(-10, report.BySlicer["Cura"].MeanErrorPercent, 0.001)
+	assert.InDelta(10, report.Overall.MeanAbsPercent, 0.001)
+}
+
+func TestAnalyzeFilamentUsage(t *testing.T) {
+	assert := assert.New(t)
+	days := AnalyzeFilamentUsage(analyticsJobs, UsagePerDay, time.UTC)
+	assert.Len(days, 2)
+	assert.Equal(time.Date(2022, 4, 15, 0, 0, 0, 0, time.UTC), days[0].Start)
+	assert.Equal(2, days[0].Jobs)
+	assert.InDelta(1100, days[0].FilamentUsed, 0.001)
+	weeks := AnalyzeFilamentUsage(analyticsJobs, UsagePerWeek, time.UTC)
+	assert.Len(weeks, 1)
+	assert.Equal(time.Date(2022, 4, 11, 0, 0, 0, 0, time.UTC), weeks[0].Start)
+	assert.InDelta(2000, weeks[0].FilamentUsed, 0.001)
+}
+
+func TestAnalyzeUtilization(t *testing.T) {
+	assert := assert.New(t)
+	start := time.Unix(1650001800, 0)
+	end := start.Add(10 * time.Hour)
+	report := AnalyzeUtilization(analyticsJobs, start, end)
+	assert.Equal(2, report.Jobs)
+	assert.Equal(40*time.Minute, report.BusyTime)
+	assert.InDelta(40.0/600*100, report.Utilization, 0.001)
+}
+
+func TestMoonClient_AnalyzeJobHistory(t *testing.T) {
+	assert := assert.New(t)
+	c, _ := newTestClient(t, handler.Map{
+		"server.history.totals": handler.New(func(ctx context.Context) (JobHistoryTotals, error) {
+			return JobHistoryTotals{JobTotals: HistoryTotals{LongestJob: 3600, LongestPrint: 3300}}, nil
+		}),
+		"server.history.list": handler.New(func(ctx context.Context, params struct {
+			Since  float64 `json:"since"`
+			Before float64 `json:"before"`
+		}) (JobHistory, error) {
+			var jobs []Job
+			for _, job := range analyticsJobs {
+				if job.StartTime >= params.Since && job.StartTime < params.Before {
+					jobs = append(jobs, job)
+				}
+			}
+			return JobHistory{Count: len(jobs), Jobs: jobs}, nil
+		}),
+	})
+
+	// The first job started half an hour before the window and ran into it.
+	start := time.Unix(1650001800, 0)
+	report, err := c.AnalyzeJobHistory(start, start.Add(10*time.Hour))
+	assert.NoError(err)
+	assert.Equal(1, report.Outcomes.Overall.Total)
+	assert.Equal(2, report.Utilization.Jobs)
+	assert.Equal(40*time.Minute, report.Utilization.BusyTime)
+}
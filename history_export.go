@@ -0,0 +1,203 @@
+package go_moonraker
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+type HistoryExportFormat string
+
+const (
+	HistoryExportCSV   HistoryExportFormat = "csv"
+	HistoryExportJSONL HistoryExportFormat = "jsonl"
+)
+
+type HistoryColumn struct {
+	Name  string
+	Value func(job *Job) interface{}
+	Kind  HistoryColumnKind
+}
+
+type HistoryColumnKind int
+
+const (
+	ColumnPlain HistoryColumnKind = iota
+	ColumnTimestamp
+	ColumnDuration
+)
+
+var HistoryColumns = []HistoryColumn{
+	{"job_id", func(j *Job) interface{} { return j.JobId }, ColumnPlain},
+	{"filename", func(j *Job) interface{} { return j.Filename }, ColumnPlain},
+	{"status", func(j *Job) interface{} { return j.Status }, ColumnPlain},
+	{"exists", func(j *Job) interface{} { return j.Exists }, ColumnPlain},
+	{"start_time", func(j *Job) interface{} { return j.StartTime }, ColumnTimestamp},
+	{"end_time", func(j *Job) interface{} { return j.EndTime }, ColumnTimestamp},
+	{"print_duration", func(j *Job) interface{} { return j.PrintDuration }, ColumnDuration},
+	{"total_duration", func(j *Job) interface{} { return j.TotalDuration }, ColumnDuration},
+	{"filament_used", func(j *Job) interface{} { return j.FilamentUsed }, ColumnPlain},
+	{"slicer", func(j *Job) interface{} { return j.Metadata.Slicer }, ColumnPlain},
+	{"slicer_version", func(j *Job) interface{} { return j.Metadata.SlicerVersion }, ColumnPlain},
+	{"layer_height", func(j *Job) interface{} { return j.Metadata.LayerHeight }, ColumnPlain},
+	{"first_layer_height", func(j *Job) interface{} { return j.Metadata.FirstLayerHeight }, ColumnPlain},
+	{"object_height", func(j *Job) interface{} { return j.Metadata.ObjectHeight }, ColumnPlain},
+	{"filament_total", func(j *Job) interface{} { return j.Metadata.FilamentTotal }, ColumnPlain},
+	{"estimated_time", func(j *Job) interface{} { return j.Metadata.EstimatedTime }, ColumnDuration},
+	{"first_layer_bed_temp", func(j *Job) interface{} { return j.Metadata.FirstLayerBedTemp }, ColumnPlain},
+	{"first_layer_extr_temp", func(j *Job) interface{} { return j.Metadata.FirstLayerExtrTemp }, ColumnPlain},
+	{"size", func(j *Job) interface{} { return j.Metadata.Size }, ColumnPlain},
+	{"modified", func(j *Job) interface{} { return j.Metadata.Modified }, ColumnTimestamp},
+}
+
+var DefaultHistoryColumns = []string{
+	"job_id", "filename", "status", "start_time", "end_time", "print_duration", "total_duration",
+	"filament_used", "slicer", "filament_total", "estimated_time",
+}
+
+type HistoryExportOptions struct {
+	Format   HistoryExportFormat
+	Columns  []string
+	Raw      bool
+	Location *time.Location
+	PageSize int
+	Since    float64
+	Before   float64
+	Order    string
+}
+
+type JobHistoryExporter struct {
+	opts    HistoryExportOptions
+	columns []HistoryColumn
+	csv     *csv.Writer
+	json    *json.Encoder
+}
+
+func NewJobHistoryExporter(w io.Writer, opts HistoryExportOptions) (*JobHistoryExporter, error) {
+	if opts.Format == "" {
+		opts.Format = HistoryExportCSV
+	}
+	if len(opts.Columns) == 0 {
+		opts.Columns = DefaultHistoryColumns
+	}
+	if opts.Location == nil {
+		opts.Location = time.Local
+	}
+	e := &JobHistoryExporter{opts: opts}
+	for _, name := range opts.Columns {
+		col, ok := lookupHistoryColumn(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown history column: %s", name)
+		}
+		e.columns = append(e.columns, col)
+	}
+	switch opts.Format {
+	case HistoryExportCSV:
+		e.csv = csv.NewWriter(w)
+		if err := e.csv.Write(opts.Columns); err != nil {
+			return nil, err
+		}
+	case HistoryExportJSONL:
+		e.json = json.NewEncoder(w)
+	default:
+		return nil, fmt.Errorf("unknown history export format: %s", opts.Format)
+	}
+	return e, nil
+}
+
+func lookupHistoryColumn(name string) (HistoryColumn, bool) {
+	for _, col := range HistoryColumns {
+		if col.Name == name {
+			return col, true
+		}
+	}
+	return HistoryColumn{}, false
+}
+
+func (e *JobHistoryExporter) Write(job *Job) error {
+	if e.csv != nil {
+		record := make([]string, len(e.columns))
+		for i, col := range e.columns {
+			record[i] = e.formatString(col, col.Value(job))
+		}
+		return e.csv.Write(record)
+	}
+	row := make(map[string]interface{}, len(e.columns))
+	for _, col := range e.columns {
+		v := col.Value(job)
+		if !e.opts.Raw && col.Kind != ColumnPlain {
+			row[col.Name] = e.formatString(col, v)
+		} else {
+			row[col.Name] = v
+		}
+	}
+	return e.json.Encode(row)
+}
+
+func (e *JobHistoryExporter) Flush() error {
+	if e.csv != nil {
+		e.csv.Flush()
+		return e.csv.Error()
+	}
+	return nil
+}
+
+func (e *JobHistoryExporter) formatString(col HistoryColumn, v interface{}) string {
+	if !e.opts.Raw {
+		switch col.Kind {
+		case ColumnTimestamp:
+			return FormatTimestamp(v.(float64), e.opts.Location)
+		case ColumnDuration:
+			return FormatDuration(v.(float64))
+		}
+	}
+	switch value := v.(type) {
+	case string:
+		return value
+	case float32:
+		return strconv.FormatFloat(float64(value), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+func FormatTimestamp(ts float64, loc *time.Location) string {
+	if ts <= 0 {
+		return ""
+	}
+	return unixTime(ts).In(loc).Format("2006-01-02 15:04:05")
+}
+
+func FormatDuration(secs float64) string {
+	d := seconds(secs).Round(time.Second)
+	if d <= 0 {
+		return "0s"
+	}
+	h := d / time.Hour
+	m := (d % time.Hour) / time.Minute
+	s := (d % time.Minute) / time.Second
+	switch {
+	case h > 0:
+		return fmt.Sprintf("%dh%02dm%02ds", h, m, s)
+	case m > 0:
+		return fmt.Sprintf("%dm%02ds", m, s)
+	default:
+		return fmt.Sprintf("%ds", s)
+	}
+}
+
+func (c *MoonClient) ExportJobHistory(w io.Writer, opts HistoryExportOptions) error {
+	exporter, err := NewJobHistoryExporter(w, opts)
+	if err != nil {
+		return err
+	}
+	if err := c.WalkJobHistory(opts.PageSize, opts.Since, opts.Before, opts.Order, exporter.Write); err != nil {
+		return err
+	}
+	return exporter.Flush()
+}
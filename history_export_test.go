@@ -0,0 +1,108 @@
+package go_moonraker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/creachadair/jrpc2/handler"
+	"github.com/creachadair/jrpc2/server"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+var exportJobs = []Job{
+	{
+		JobId:         "000001",
+		Filename:      "benchy.gcode",
+		Status:        "completed",
+		StartTime:     1650000000,
+		EndTime:       1650005025,
+		PrintDuration: 4980,
+		TotalDuration: 5025.4,
+		FilamentUsed:  4012.5,
+		Metadata:      GcodeMetadata{Slicer: "PrusaSlicer", FilamentTotal: 4100, EstimatedTime: 4800},
+	},
+	{
+		JobId:         "000002",
+		Filename:      "cube, small.gcode",
+		Status:        "cancelled",
+		StartTime:     1650010000,
+		EndTime:       1650010059,
+		PrintDuration: 42,
+		TotalDuration: 59,
+		Metadata:      GcodeMetadata{Slicer: "Cura"},
+	},
+}
+
+func TestJobHistoryExporter_CSV(t *testing.T) {
+	assert := assert.New(t)
+	var buf bytes.Buffer
+	exporter, err := NewJobHistoryExporter(&buf, HistoryExportOptions{
+		Columns:  []string{"job_id", "filename", "start_time", "total_duration", "slicer", "estimated_time"},
+		Location: time.UTC,
+	})
+	assert.NoError(err)
+	for i := range exportJobs {
+		assert.NoError(exporter.Write(&exportJobs[i]))
+	}
+	assert.NoError(exporter.Flush())
+	expected := "job_id,filename,start_time,total_duration,slicer,estimated_time\n" +
+		"000001,benchy.gcode,2022-04-15 05:20:00,1h23m45s,PrusaSlicer,1h20m00s\n" +
+		"000002,\"cube, small.gcode\",2022-04-15 08:06:40,59s,Cura,0s\n"
+	assert.Equal(expected, buf.String())
+}
+
+func TestJobHistoryExporter_JSONL(t *testing.T) {
+	assert := assert.New(t)
+	var buf bytes.Buffer
+	exporter, err := NewJobHistoryExporter(&buf, HistoryExportOptions{
+		Format:  HistoryExportJSONL,
+		Columns: []string{"job_id", "print_duration", "filament_used"},
+		Raw:     true,
+	})
+	assert.NoError(err)
+	assert.NoError(exporter.Write(&exportJobs[0]))
+	assert.NoError(exporter.Flush())
+	assert.JSONEq(`{"job_id":"000001","print_duration":4980,"filament_used":4012.5}`, buf.String())
+}
+
+func TestJobHistoryExporter_UnknownColumn(t *testing.T) {
+	_, err := NewJobHistoryExporter(&bytes.Buffer{}, HistoryExportOptions{Columns: []string{"nozzle"}})
+	assert.Error(t, err)
+}
+
+func TestMoonClient_ExportJobHistory(t *testing.T) {
+	assert := assert.New(t)
+	var starts []int
+	loc := server.NewLocal(handler.Map{
+		"server.history.list": handler.New(func(ctx context.Context, params struct {
+			Limit int `json:"limit"`
+			Start int `json:"start"`
+		}) (JobHistory, error) {
+			starts = append(starts, params.Start)
+			end := params.Start + params.Limit
+			if end > len(exportJobs) {
+				end = len(exportJobs)
+			}
+			return JobHistory{Count: len(exportJobs), Jobs: exportJobs[params.Start:end]}, nil
+		}),
+	}, nil)
+	defer loc.Close()
+	historyClient := &MoonClient{Conn: loc.Client}
+
+	var buf bytes.Buffer
+	err := historyClient.ExportJobHistory(&buf, HistoryExportOptions{
+		Format:   HistoryExportJSONL,
+		Columns:  []string{"job_id"},
+		PageSize: 1,
+	})
+	assert.NoError(err)
+	assert.Equal([]int{0, 1}, starts)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(lines, 2)
+	var row map[string]string
+	assert.NoError(json.Unmarshal([]byte(lines[1]), &row))
+	assert.Equal("000002", row["job_id"])
+}
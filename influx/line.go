@@ -0,0 +1,108 @@
+package influx
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Point is a single line protocol record. Field values may be float64,
+// float32, int, int64, bool or string; values of other types are formatted
+// as strings.
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]interface{}
+	Time        time.Time
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	keyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	stringEscaper      = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+)
+
+// AppendLine appends the point in line protocol, terminated by a newline,
+// with its timestamp in units of precision. Points without any writable
+// field are skipped, since InfluxDB rejects them.
+func (p Point) AppendLine(buf []byte, precision time.Duration) []byte {
+	fields := make([]string, 0, len(p.Fields))
+	for key, value := range p.Fields {
+		if formatField(value) != "" {
+			fields = append(fields, key)
+		}
+	}
+	if len(fields) == 0 {
+		return buf
+	}
+	sort.Strings(fields)
+	tags := make([]string, 0, len(p.Tags))
+	for key, value := range p.Tags {
+		// Empty tag values are not allowed in line protocol.
+		if value != "" {
+			tags = append(tags, key)
+		}
+	}
+	sort.Strings(tags)
+
+	buf = append(buf, measurementEscaper.Replace(p.Measurement)...)
+	for _, key := range tags {
+		buf = append(buf, ',')
+		buf = append(buf, keyEscaper.Replace(key)...)
+		buf = append(buf, '=')
+		buf = append(buf, keyEscaper.Replace(p.Tags[key])...)
+	}
+	for i, key := range fields {
+		if i == 0 {
+			buf = append(buf, ' ')
+		} else {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, keyEscaper.Replace(key)...)
+		buf = append(buf, '=')
+		buf = append(buf, formatField(p.Fields[key])...)
+	}
+	if !p.Time.IsZero() {
+		if precision <= 0 {
+			precision = time.Nanosecond
+		}
+		buf = append(buf, ' ')
+		buf = strconv.AppendInt(buf, p.Time.UnixNano()/int64(precision), 10)
+	}
+	return append(buf, '\n')
+}
+
+func (p Point) String() string {
+	return strings.TrimSuffix(string(p.AppendLine(nil, time.Nanosecond)), "\n")
+}
+
+func formatFloat(v float64) string {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return ""
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func formatField(value interface{}) string {
+	switch v := value.(type) {
+	case float64:
+		return formatFloat(v)
+	case float32:
+		return formatFloat(float64(v))
+	case int:
+		return strconv.Itoa(v) + "i"
+	case int64:
+		return strconv.FormatInt(v, 10) + "i"
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return `"` + stringEscaper.Replace(v) + `"`
+	case nil:
+		return ""
+	default:
+		return `"` + stringEscaper.Replace(fmt.Sprint(v)) + `"`
+	}
+}
@@ -0,0 +1,33 @@
+package influx
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPoint_AppendLine(t *testing.T) {
+	assert := assert.New(t)
+	p := Point{
+		Measurement: "klipper status",
+		Tags:        map[string]string{"object": "heater_generic chamber", "printer": "voron,2=4", "empty": ""},
+		Fields: map[string]interface{}{
+			"temperature": 35.5,
+			"target":      float32(0),
+			"count":       3,
+			"active":      true,
+			"filename":    `C:\benchy "v2".gcode`,
+			"bad":         math.NaN(),
+			"missing":     nil,
+		},
+		Time: time.Unix(1658150000, 123456789),
+	}
+	assert.Equal(`klipper\ status,object=heater_generic\ chamber,printer=voron\,2\=4 active=true,count=3i,filename="C:\\benchy \"v2\".gcode",target=0,temperature=35.5 1658150000123456789`+"\n",
+		string(p.AppendLine(nil, time.Nanosecond)))
+	assert.Equal(`klipper\ status,object=heater_generic\ chamber,printer=voron\,2\=4 active=true,count=3i,filename="C:\\benchy \"v2\".gcode",target=0,temperature=35.5 1658150000`+"\n",
+		string(p.AppendLine(nil, time.Second)))
+
+	assert.Empty(Point{Measurement: "m", Fields: map[string]interface{}{"bad": math.Inf(1)}}.AppendLine(nil, 0))
+	assert.Equal("m v=1", Point{Measurement: "m", Fields: map[string]interface{}{"v": 1.0}}.String())
+}
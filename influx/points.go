@@ -0,0 +1,174 @@
+package influx
+
+import (
+	"encoding/json"
+	"fmt"
+	moonraker "github.com/derek-elliott/go-moonraker"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+type PointOptions struct {
+	// Measurement for printer object status, "klipper" by default. Each point
+	// is tagged with object=<name>.
+	StatusMeasurement string
+	// Per-object measurement names overriding StatusMeasurement, e.g.
+	// {"extruder": "temperature", "heater_bed": "temperature"}.
+	Measurements map[string]string
+	// Measurement for Moonraker process and host statistics,
+	// "moonraker_proc_stats" by default.
+	ProcStatsMeasurement string
+	// Measurement for per-interface network statistics, tagged with
+	// interface=<name>, "moonraker_network" by default.
+	NetworkMeasurement string
+	// Only these object fields are kept if set, e.g. {"temperature",
+	// "target", "power", "progress"}.
+	Fields []string
+}
+
+func (o PointOptions) withDefaults() PointOptions {
+	if o.StatusMeasurement == "" {
+		o.StatusMeasurement = "klipper"
+	}
+	if o.ProcStatsMeasurement == "" {
+		o.ProcStatsMeasurement = "moonraker_proc_stats"
+	}
+	if o.NetworkMeasurement == "" {
+		o.NetworkMeasurement = "moonraker_network"
+	}
+	return o
+}
+
+// StatusPoints turns a status update into one point per object. Nested values
+// are flattened with underscores, so toolhead position becomes position_0
+// through position_3. Numbers are always written as floats to avoid field
+// type conflicts between updates.
+func StatusPoints(update moonraker.StatusUpdate, t time.Time, opts PointOptions) []Point {
+	opts = opts.withDefaults()
+	var points []Point
+	for object, raw := range update {
+		var values map[string]interface{}
+		if err := json.Unmarshal(raw, &values); err != nil {
+			continue
+		}
+		fields := make(map[string]interface{})
+		for key, value := range values {
+			flatten(fields, key, value)
+		}
+		if len(opts.Fields) > 0 {
+			for key := range fields {
+				if !keepField(opts.Fields, key) {
+					delete(fields, key)
+				}
+			}
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		measurement, ok := opts.Measurements[object]
+		if !ok {
+			measurement = opts.StatusMeasurement
+		}
+		points = append(points, Point{
+			Measurement: measurement,
+			Tags:        map[string]string{"object": object},
+			Fields:      fields,
+			Time:        t,
+		})
+	}
+	return points
+}
+
+// keepField matches a flattened key against the configured field names, so
+// "position" keeps position_0 and friends.
+func keepField(keep []string, key string) bool {
+	for _, k := range keep {
+		if key == k || strings.HasPrefix(key, k+"_") {
+			return true
+		}
+	}
+	return false
+}
+
+func flatten(fields map[string]interface{}, key string, value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, inner := range v {
+			flatten(fields, key+"_"+k, inner)
+		}
+	case []interface{}:
+		for i, inner := range v {
+			flatten(fields, fmt.Sprintf("%s_%d", key, i), inner)
+		}
+	case nil:
+	default:
+		fields[key] = v
+	}
+}
+
+// ProcStatPoints turns a proc stat update into a point for the process and
+// host statistics and one point per network interface.
+func ProcStatPoints(update *moonraker.ProcStatUpdate, t time.Time, opts PointOptions) []Point {
+	opts = opts.withDefaults()
+	fields := map[string]interface{}{
+		"cpu_temp":              float64(update.CpuTemp),
+		"moonraker_cpu_usage":   float64(update.MoonrakerStats.CPUUsage),
+		"moonraker_memory":      float64(update.MoonrakerStats.Memory),
+		"system_memory_total":   float64(update.SystemMemory.Total),
+		"system_memory_used":    float64(update.SystemMemory.Used),
+		"system_memory_avail":   float64(update.SystemMemory.Available),
+		"websocket_connections": float64(update.WebsocketConnections),
+	}
+	for core, usage := range update.SystemCpuUsage {
+		fields[core+"_usage"] = usage
+	}
+	points := []Point{{Measurement: opts.ProcStatsMeasurement, Fields: fields, Time: t}}
+	for iface, stats := range update.Network {
+		points = append(points, Point{
+			Measurement: opts.NetworkMeasurement,
+			Tags:        map[string]string{"interface": iface},
+			Fields: map[string]interface{}{
+				"rx_bytes":  float64(stats.RxBytes),
+				"tx_bytes":  float64(stats.TxBytes),
+				"rx_errs":   float64(stats.RxErrs),
+				"tx_errs":   float64(stats.TxErrs),
+				"rx_drop":   float64(stats.RxDrop),
+				"tx_drop":   float64(stats.TxDrop),
+				"bandwidth": stats.Bandwidth,
+			},
+			Time: t,
+		})
+	}
+	return points
+}
+
+// Record writes the current state of the given printer objects, and then
+// every status and proc stat update, to w until the returned function is
+// called. Points are timestamped with the local time they were received.
+func Record(client *moonraker.MoonClient, w *Writer, opts PointOptions, objects ...string) (func(), error) {
+	add := func(points []Point) {
+		if err := w.Add(points...); err != nil {
+			log.WithError(err).Error("writing points")
+		}
+	}
+	cancelStatus := client.OnStatusUpdate(func(update moonraker.StatusUpdate) {
+		add(StatusPoints(update, time.Now(), opts))
+	})
+	cancelStats := client.OnProcStatUpdate(func(update *moonraker.ProcStatUpdate) {
+		add(ProcStatPoints(update, time.Now(), opts))
+	})
+	cancel := func() {
+		cancelStatus()
+		cancelStats()
+	}
+	if len(objects) > 0 {
+		status, err := client.SubscribeObjects(objects...)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		add(StatusPoints(status, time.Now(), opts))
+	}
+	return cancel, nil
+}
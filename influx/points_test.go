@@ -0,0 +1,110 @@
+package influx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/creachadair/jrpc2"
+	"github.com/creachadair/jrpc2/channel"
+	"github.com/creachadair/jrpc2/handler"
+	moonraker "github.com/derek-elliott/go-moonraker"
+	"github.com/stretchr/testify/assert"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func sortedLines(points []Point) []string {
+	var lines []string
+	for _, p := range points {
+		lines = append(lines, p.String())
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func TestStatusPoints(t *testing.T) {
+	update := moonraker.StatusUpdate{
+		"extruder":    json.RawMessage(`{"temperature": 209.8, "target": 210, "power": 0.4}`),
+		"toolhead":    json.RawMessage(`{"position": [10, 20, 0.2, 100], "homed_axes": "xyz", "extruder": "extruder"}`),
+		"print_stats": json.RawMessage(`{"state": "printing", "info": {"current_layer": 3}}`),
+		"webhooks":    json.RawMessage(`{"state_message": null}`),
+	}
+	points := StatusPoints(update, time.Time{}, PointOptions{Measurements: map[string]string{"extruder": "temperature"}})
+	assert.Equal(t, []string{
+		`klipper,object=print_stats info_current_layer=3,state="printing"`,
+		`klipper,object=toolhead extruder="extruder",homed_axes="xyz",position_0=10,position_1=20,position_2=0.2,position_3=100`,
+		`temperature,object=extruder power=0.4,target=210,temperature=209.8`,
+	}, sortedLines(points))
+
+	points = StatusPoints(update, time.Time{}, PointOptions{Fields: []string{"temperature", "position"}})
+	assert.Equal(t, []string{
+		`klipper,object=extruder temperature=209.8`,
+		`klipper,object=toolhead position_0=10,position_1=20,position_2=0.2,position_3=100`,
+	}, sortedLines(points))
+}
+
+func TestProcStatPoints(t *testing.T) {
+	update := &moonraker.ProcStatUpdate{
+		MoonrakerStats: moonraker.MoonrakerStats{CPUUsage: 2.5, Memory: 24000},
+		CpuTemp:        48.5,
+		Network:        map[string]moonraker.NetworkStats{"wlan0": {RxBytes: 1000, TxBytes: 2000, Bandwidth: 12.5}},
+		SystemCpuUsage: moonraker.CPUUsage{"cpu": 10, "cpu0": 12},
+		SystemMemory:   moonraker.SystemMemory{Total: 1000, Available: 600, Used: 400},
+	}
+	assert.Equal(t, []string{
+		"moonraker_network,interface=wlan0 bandwidth=12.5,rx_bytes=1000,rx_drop=0,rx_errs=0,tx_bytes=2000,tx_drop=0,tx_errs=0",
+		"moonraker_proc_stats cpu0_usage=12,cpu_temp=48.5,cpu_usage=10,moonraker_cpu_usage=2.5,moonraker_memory=24000,system_memory_avail=600,system_memory_total=1000,system_memory_used=400,websocket_connections=0",
+	}, sortedLines(ProcStatPoints(update, time.Time{}, PointOptions{})))
+}
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestRecord(t *testing.T) {
+	assert := assert.New(t)
+	cch, sch := channel.Direct()
+	srv := jrpc2.NewServer(handler.Map{
+		"printer.objects.subscribe": handler.New(func(ctx context.Context, params moonraker.QueryObjectParams) (json.RawMessage, error) {
+			return json.RawMessage(`{"eventtime": 1, "status": {"heater_bed": {"temperature": 21.5, "target": 0}}}`), nil
+		}),
+	}, &jrpc2.ServerOptions{AllowPush: true}).Start(sch)
+	client := moonraker.NewClientFromChannel(cch, "printer.local", nil)
+	defer func() {
+		client.Close()
+		srv.Stop()
+		srv.Wait()
+	}()
+
+	out := &syncBuffer{}
+	w := NewWriter(out, WriterOptions{BatchSize: 1, Tags: map[string]string{"printer": "voron"}})
+	stop, err := Record(client, w, PointOptions{}, "heater_bed")
+	assert.NoError(err)
+	assert.Eventually(func() bool {
+		return strings.Contains(out.String(), "klipper,object=heater_bed,printer=voron target=0,temperature=21.5 ")
+	}, time.Second, 5*time.Millisecond)
+
+	srv.Notify(context.Background(), "notify_status_update", []interface{}{map[string]interface{}{"heater_bed": map[string]float64{"target": 60}}, 2.0})
+	srv.Notify(context.Background(), "notify_proc_stat_update", []interface{}{map[string]interface{}{"cpu_temp": 50.5}})
+	assert.Eventually(func() bool {
+		s := out.String()
+		return strings.Contains(s, "klipper,object=heater_bed,printer=voron target=60 ") && strings.Contains(s, "cpu_temp=50.5")
+	}, time.Second, 5*time.Millisecond)
+	stop()
+}
@@ -0,0 +1,192 @@
+// Package influx converts printer status updates and host statistics into
+// InfluxDB line protocol and writes them in batches to an io.Writer or an
+// InfluxDB HTTP write endpoint.
+package influx
+
+import (
+	"bytes"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type WriterOptions struct {
+	// Number of points buffered before they are written, 500 by default.
+	BatchSize int
+	// Buffered points are also written at this interval if it is set.
+	FlushInterval time.Duration
+	// Points waiting while earlier batches are still being written are
+	// capped at this many, ten batches by default and never less than one.
+	// The oldest are dropped.
+	MaxBuffered int
+	// Timestamp precision, nanoseconds by default. It has to match the
+	// precision the endpoint expects.
+	Precision time.Duration
+	// Tags added to every point, such as the printer name.
+	Tags map[string]string
+}
+
+// Writer batches points and writes each batch with a single Write call, so
+// an HTTPEndpoint sends one request per batch. Batches are written from the
+// writer's own goroutine, so Add never waits for the output.
+type Writer struct {
+	out  io.Writer
+	opts WriterOptions
+
+	mu      sync.Mutex
+	pending []Point
+	// writeMu keeps batches in order when Flush races the background writes.
+	writeMu   sync.Mutex
+	full      chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewWriter(out io.Writer, opts WriterOptions) *Writer {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+	if opts.MaxBuffered < opts.BatchSize {
+		opts.MaxBuffered = 10 * opts.BatchSize
+	}
+	if opts.Precision <= 0 {
+		opts.Precision = time.Nanosecond
+	}
+	w := &Writer{
+		out:  out,
+		opts: opts,
+		full: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// run writes full batches, and everything buffered every FlushInterval, until
+// the writer is closed.
+func (w *Writer) run() {
+	defer close(w.done)
+	var tick <-chan time.Time
+	if w.opts.FlushInterval > 0 {
+		ticker := time.NewTicker(w.opts.FlushInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-w.full:
+		case <-tick:
+		case <-w.stop:
+			return
+		}
+		if err := w.Flush(); err != nil {
+			log.WithError(err).Error("flushing points")
+		}
+	}
+}
+
+// Add buffers points and hands the batch to the background writer once it is
+// full. It doesn't write itself, so the returned error is always nil; failed
+// background writes are logged.
+func (w *Writer) Add(points ...Point) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, p := range points {
+		if len(w.opts.Tags) > 0 {
+			tags := make(map[string]string, len(w.opts.Tags)+len(p.Tags))
+			for key, value := range w.opts.Tags {
+				tags[key] = value
+			}
+			for key, value := range p.Tags {
+				tags[key] = value
+			}
+			p.Tags = tags
+		}
+		w.pending = append(w.pending, p)
+	}
+	if over := len(w.pending) - w.opts.MaxBuffered; over > 0 {
+		log.Warnf("dropping %d points, the output is not keeping up", over)
+		w.pending = append(w.pending[:0], w.pending[over:]...)
+	}
+	if len(w.pending) >= w.opts.BatchSize {
+		select {
+		case w.full <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Flush writes the buffered points now. Points of a failed batch are dropped
+// rather than retried, so a dead endpoint can't grow the buffer without bound.
+func (w *Writer) Flush() error {
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+	w.mu.Lock()
+	var buf []byte
+	for _, p := range w.pending {
+		buf = p.AppendLine(buf, w.opts.Precision)
+	}
+	w.pending = w.pending[:0]
+	w.mu.Unlock()
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := w.out.Write(buf)
+	return err
+}
+
+// Close stops the background writer and writes any buffered points.
+func (w *Writer) Close() error {
+	w.closeOnce.Do(func() {
+		close(w.stop)
+		<-w.done
+	})
+	return w.Flush()
+}
+
+// defaultClient is used by an HTTPEndpoint without a Client. Its timeout keeps
+// an unresponsive InfluxDB from stalling the writer indefinitely.
+var defaultClient = &http.Client{Timeout: 30 * time.Second}
+
+// HTTPEndpoint posts every Write to an InfluxDB write URL, such as
+// http://influx:8086/api/v2/write?org=farm&bucket=printers&precision=ns
+// or http://influx:8086/write?db=printers for InfluxDB 1.x.
+type HTTPEndpoint struct {
+	URL string
+	// Sent as "Authorization: Token <Token>" when set.
+	Token string
+	// Client sends the requests. When nil, a client with a 30 second timeout
+	// is used.
+	Client *http.Client
+}
+
+func (e *HTTPEndpoint) Write(data []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, e.URL, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if e.Token != "" {
+		req.Header.Set("Authorization", "Token "+e.Token)
+	}
+	client := e.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return 0, fmt.Errorf("write to %s failed: %s: %s", e.URL, resp.Status, bytes.TrimSpace(body))
+	}
+	return len(data), nil
+}
@@ -0,0 +1,140 @@
+package influx
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingWriter struct {
+	mu     sync.Mutex
+	writes []string
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writes = append(w.writes, string(p))
+	return len(p), nil
+}
+
+func (w *recordingWriter) count() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.writes)
+}
+
+func point(v float64) Point {
+	return Point{Measurement: "m", Tags: map[string]string{"printer": "own"}, Fields: map[string]interface{}{"v": v}}
+}
+
+func TestWriter_Batching(t *testing.T) {
+	assert := assert.New(t)
+	out := &recordingWriter{}
+	w := NewWriter(out, WriterOptions{BatchSize: 3, Tags: map[string]string{"printer": "voron", "site": "lab"}})
+	p := point(1)
+	assert.NoError(w.Add(p, point(2)))
+	assert.Equal(0, out.count())
+	assert.NoError(w.Add(point(3)))
+	assert.Eventually(func() bool { return out.count() == 1 }, time.Second, 5*time.Millisecond)
+	assert.Equal([]string{"m,printer=own,site=lab v=1\nm,printer=own,site=lab v=2\nm,printer=own,site=lab v=3\n"}, out.writes)
+	assert.Equal(map[string]string{"printer": "own"}, p.Tags, "caller's tags are not modified")
+
+	assert.NoError(w.Add(point(4)))
+	assert.NoError(w.Close())
+	assert.Equal("m,printer=own,site=lab v=4\n", out.writes[1])
+	assert.NoError(w.Flush())
+	assert.Len(out.writes, 2)
+}
+
+func TestWriter_FlushInterval(t *testing.T) {
+	out := &recordingWriter{}
+	w := NewWriter(out, WriterOptions{FlushInterval: 10 * time.Millisecond})
+	defer w.Close()
+	w.Add(point(1))
+	assert.Eventually(t, func() bool { return out.count() == 1 }, time.Second, 5*time.Millisecond)
+}
+
+func TestWriter_SlowOutput(t *testing.T) {
+	assert := assert.New(t)
+	release := make(chan struct{})
+	out := &blockingWriter{release: release}
+	w := NewWriter(out, WriterOptions{BatchSize: 1, MaxBuffered: 2})
+	added := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			w.Add(point(float64(i)))
+		}
+		close(added)
+	}()
+	select {
+	case <-added:
+	case <-time.After(time.Second):
+		t.Fatal("Add waited for the output")
+	}
+	close(release)
+	assert.NoError(w.Close())
+	assert.LessOrEqual(out.points(), 3, "points beyond MaxBuffered are dropped")
+	assert.Contains(out.String(), "v=9\n")
+}
+
+type blockingWriter struct {
+	release chan struct{}
+	recordingWriter
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return w.recordingWriter.Write(p)
+}
+
+func (w *blockingWriter) points() int {
+	return strings.Count(w.String(), "\n")
+}
+
+func (w *blockingWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return strings.Join(w.writes, "")
+}
+
+func TestHTTPEndpoint(t *testing.T) {
+	assert := assert.New(t)
+	var body, auth, query string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		body, auth, query = string(data), r.Header.Get("Authorization"), r.URL.RawQuery
+		if strings.Contains(body, "bad") {
+			http.Error(w, `{"code":"invalid","message":"unable to parse"}`, http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	w := NewWriter(&HTTPEndpoint{URL: srv.URL + "/api/v2/write?org=farm&bucket=printers&precision=s", Token: "secret"},
+		WriterOptions{Precision: time.Second})
+	assert.NoError(w.Add(Point{Measurement: "m", Fields: map[string]interface{}{"v": 1.5}, Time: time.Unix(100, 0)}))
+	assert.NoError(w.Flush())
+	assert.Equal("m v=1.5 100\n", body)
+	assert.Equal("Token secret", auth)
+	assert.Equal("org=farm&bucket=printers&precision=s", query)
+
+	w.Add(Point{Measurement: "bad", Fields: map[string]interface{}{"v": 1.0}})
+	err := w.Flush()
+	assert.Error(err)
+	assert.Contains(err.Error(), "400 Bad Request")
+	assert.Contains(err.Error(), "unable to parse")
+
+	var buf bytes.Buffer
+	w = NewWriter(&buf, WriterOptions{})
+	w.Add(point(1))
+	w.Close()
+	assert.Equal("m,printer=own v=1\n", buf.String())
+}
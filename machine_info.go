@@ -1,18 +1,25 @@
 package go_moonraker
 
+import (
+	"net"
+	"sort"
+	"strings"
+)
+
 type MachineInfo struct {
-	SystemInfo     SystemInfo     `json:"system_info"`
-	Virtualization Virtualization `json:"virtualization"`
-	Python         Python         `json:"python"`
-	Network        Network        `json:"network"`
+	SystemInfo SystemInfo `json:"system_info"`
 }
 
 type SystemInfo struct {
-	CPUInfo           CPUInfo      `json:"cpu_info"`
-	SDInfo            SDInfo       `json:"sd_info"`
-	Distribution      DistInfo     `json:"distribution"`
-	AvailableServices []string     `json:"available_services"`
-	ServiceState      ServiceState `json:"service_state"`
+	CPUInfo           CPUInfo                 `json:"cpu_info"`
+	SDInfo            SDInfo                  `json:"sd_info"`
+	Distribution      DistInfo                `json:"distribution"`
+	AvailableServices []string                `json:"available_services"`
+	ServiceState      ServiceState            `json:"service_state"`
+	Virtualization    Virtualization          `json:"virtualization"`
+	Python            Python                  `json:"python"`
+	Network           Network                 `json:"network"`
+	Canbus            map[string]CanInterface `json:"canbus"`
 }
 
 type CPUInfo struct {
@@ -54,11 +61,7 @@ type VersionParts struct {
 	BuildNumber string `json:"build_number"`
 }
 
-type ServiceState struct {
-	Klipper    StateReport `json:"klipper"`
-	KlipperMCU StateReport `json:"klipper_mcu"`
-	Moonraker  StateReport `json:"moonraker"`
-}
+type ServiceState map[string]StateReport
 
 type StateReport struct {
 	ActiveState string `json:"active_state"`
@@ -71,13 +74,11 @@ type Virtualization struct {
 }
 
 type Python struct {
-	Version       []string `json:"version"`
-	VersionString string   `json:"versionString"`
+	Version       []interface{} `json:"version"`
+	VersionString string        `json:"version_string"`
 }
 
-type Network struct {
-	WLan0 NetDef `json:"wlan0"`
-}
+type Network map[string]NetDef
 
 type NetDef struct {
 	MACAddress  string      `json:"mac_address"`
@@ -89,3 +90,137 @@ type IPAddress struct {
 	Address     string `json:"address"`
 	IsLinkLocal bool   `json:"is_link_local"`
 }
+
+type CanInterface struct {
+	TxQueueLen int    `json:"tx_queue_len"`
+	Bitrate    int    `json:"bitrate"`
+	Driver     string `json:"driver"`
+}
+
+func interfaceRank(name string) int {
+	switch {
+	case strings.HasPrefix(name, "eth"), strings.HasPrefix(name, "en"):
+		return 0
+	case strings.HasPrefix(name, "wlan"), strings.HasPrefix(name, "wl"):
+		return 1
+	case name == "lo", strings.HasPrefix(name, "docker"), strings.HasPrefix(name, "veth"), strings.HasPrefix(name, "br-"):
+		return 3
+	default:
+		return 2
+	}
+}
+
+// PrimaryIP picks the address other hosts are most likely to reach the
+// printer on: wired before wireless before anything else, IPv4 before IPv6,
+// and never loopback or link-local addresses.
+func (n Network) PrimaryIP() (string, bool) {
+	names := make([]string, 0, len(n))
+	for name := range n {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		ri, rj := interfaceRank(names[i]), interfaceRank(names[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return names[i] < names[j]
+	})
+	for _, family := range []string{"ipv4", "ipv6"} {
+		for _, name := range names {
+			if interfaceRank(name) == 3 {
+				continue
+			}
+			for _, addr := range n[name].IPAddresses {
+				ip := net.ParseIP(addr.Address)
+				if addr.Family != family || addr.IsLinkLocal || ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+					continue
+				}
+				return addr.Address, true
+			}
+		}
+	}
+	return "", false
+}
+
+func (s ServiceState) Active(service string) bool {
+	return s[service].ActiveState == "active"
+}
+
+type SudoInfo struct {
+	SudoAccess      bool     `json:"sudo_access"`
+	LinuxUser       string   `json:"linux_user"`
+	SudoRequested   bool     `json:"sudo_requested"`
+	RequestMessages []string `json:"request_messages"`
+}
+
+type SudoPasswordResp struct {
+	SudoResponses []string `json:"sudo_responses"`
+	IsRestarting  bool     `json:"is_restarting"`
+}
+
+type USBDevice struct {
+	BusNum       int    `json:"bus_num"`
+	DeviceNum    int    `json:"device_num"`
+	USBLocation  string `json:"usb_location"`
+	VendorId     string `json:"vendor_id"`
+	ProductId    string `json:"product_id"`
+	Manufacturer string `json:"manufacturer"`
+	Product      string `json:"product"`
+	Serial       string `json:"serial"`
+	Class        string `json:"class"`
+	Subclass     string `json:"subclass"`
+	Protocol     string `json:"protocol"`
+	Description  string `json:"description"`
+}
+
+type SerialDevice struct {
+	DeviceType     string `json:"device_type"`
+	DevicePath     string `json:"device_path"`
+	DeviceName     string `json:"device_name"`
+	DriverName     string `json:"driver_name"`
+	PathByHardware string `json:"path_by_hardware"`
+	PathById       string `json:"path_by_id"`
+	USBLocation    string `json:"usb_location"`
+}
+
+// Klipper MCUs flashed with a USB serial bootloader or firmware show up with a
+// stable /dev/serial/by-id path, which is what belongs in printer.cfg.
+func (d SerialDevice) IsKlipperMCU() bool {
+	return strings.Contains(d.PathById, "usb-Klipper_") || strings.Contains(d.PathById, "usb-katapult_")
+}
+
+type VideoDevices struct {
+	V4L2Devices      []V4L2Device      `json:"v4l2_devices"`
+	LibcameraDevices []LibcameraDevice `json:"libcamera_devices"`
+}
+
+type V4L2Device struct {
+	DeviceName   string      `json:"device_name"`
+	DevicePath   string      `json:"device_path"`
+	CameraName   string      `json:"camera_name"`
+	DriverName   string      `json:"driver_name"`
+	AltName      string      `json:"alt_name"`
+	HardwareBus  string      `json:"hardware_bus"`
+	USBLocation  string      `json:"usb_location"`
+	Version      string      `json:"version"`
+	Capabilities []string    `json:"capabilities"`
+	Modes        []VideoMode `json:"modes"`
+}
+
+type LibcameraDevice struct {
+	LibcameraId string      `json:"libcamera_id"`
+	Model       string      `json:"model"`
+	Modes       []VideoMode `json:"modes"`
+}
+
+type VideoMode struct {
+	Format      string   `json:"format"`
+	Description string   `json:"description"`
+	Flags       []string `json:"flags"`
+	Resolutions []string `json:"resolutions"`
+}
+
+type CanbusUUID struct {
+	UUID        string `json:"uuid"`
+	Application string `json:"application"`
+}
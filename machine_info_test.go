@@ -0,0 +1,117 @@
+package go_moonraker
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/creachadair/jrpc2/handler"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestMoonClient_Peripherals(t *testing.T) {
+	assert := assert.New(t)
+	var canInterface string
+	c, _ := newTestClient(t, handler.Map{
+		"machine.peripherals.serial": handler.New(func(ctx context.Context) (map[string][]SerialDevice, error) {
+			return map[string][]SerialDevice{"serial_devices": {
+				{DeviceType: "hardware_uart", DevicePath: "/dev/ttyAMA0", DeviceName: "ttyAMA0"},
+				{DeviceType: "usb", DevicePath: "/dev/ttyACM0", PathById: "/dev/serial/by-id/usb-Klipper_stm32f446xx_1A0036000551-if00"},
+			}}, nil
+		}),
+		"machine.peripherals.canbus": handler.New(func(ctx context.Context, params map[string]string) (map[string][]CanbusUUID, error) {
+			canInterface = params["interface"]
+			return map[string][]CanbusUUID{"can_uuids": {{UUID: "11AABBCCDD", Application: "Klipper"}}}, nil
+		}),
+		"machine.sudo.info": handler.New(func(ctx context.Context, params map[string]bool) (SudoInfo, error) {
+			return SudoInfo{SudoAccess: params["check_access"], LinuxUser: "pi"}, nil
+		}),
+	})
+
+	serial, err := c.SerialDevices()
+	assert.NoError(err)
+	assert.Len(serial, 2)
+	assert.False(serial[0].IsKlipperMCU())
+	assert.True(serial[1].IsKlipperMCU())
+
+	uuids, err := c.CanbusUUIDs("can1")
+	assert.NoError(err)
+	assert.Equal("can1", canInterface)
+	assert.Equal([]CanbusUUID{{"11AABBCCDD", "Klipper"}}, uuids)
+
+	sudo, err := c.SudoInfo(true)
+	assert.NoError(err)
+	assert.True(sudo.SudoAccess)
+	assert.Equal("pi", sudo.LinuxUser)
+}
+
+func TestMoonClient_OnServiceTransition(t *testing.T) {
+	assert := assert.New(t)
+	c, srv := newTestClient(t, handler.Map{})
+	transitions := make(chan ServiceTransition, 4)
+	c.OnServiceTransition(func(tr ServiceTransition) { transitions <- tr })
+
+	active := StateReport{ActiveState: "active", SubState: "running"}
+	inactive := StateReport{ActiveState: "inactive", SubState: "dead"}
+	notify := func(states map[string]StateReport) {
+		assert.NoError(srv.Notify(context.Background(), "notify_service_state_changed", []interface{}{states}))
+	}
+	next := func() ServiceTransition {
+		select {
+		case tr := <-transitions:
+			return tr
+		case <-time.After(time.Second):
+			t.Fatal("no service transition delivered")
+		}
+		return ServiceTransition{}
+	}
+
+	notify(map[string]StateReport{"klipper": active})
+	assert.Equal(ServiceTransition{"klipper", StateReport{}, active}, next())
+	notify(map[string]StateReport{"klipper": inactive})
+	assert.Equal(ServiceTransition{"klipper", active, inactive}, next())
+}
+
+const systemInfoJSON = `{"system_info": {
+	"python": {"version": [3, 9, 2, "final", 0], "version_string": "3.9.2 (default, Feb 28 2021, 17:03:44)"},
+	"available_services": ["klipper", "moonraker", "crowsnest"],
+	"service_state": {
+		"klipper": {"active_state": "active", "sub_state": "running"},
+		"crowsnest": {"active_state": "inactive", "sub_state": "dead"}
+	},
+	"virtualization": {"virt_type": "none", "virt_identifier": "none"},
+	"network": {
+		"lo": {"mac_address": "00:00:00:00:00:00", "ip_addresses": [{"family": "ipv4", "address": "127.0.0.1", "is_link_local": false}]},
+		"can0": {"mac_address": "", "ip_addresses": []},
+		"wlan0": {"mac_address": "b8:27:eb:00:00:01", "ip_addresses": [{"family": "ipv4", "address": "192.168.1.127", "is_link_local": false}]},
+		"eth0": {"mac_address": "b8:27:eb:00:00:02", "ip_addresses": [
+			{"family": "ipv6", "address": "fe80::ba27:ebff:fe00:2", "is_link_local": true},
+			{"family": "ipv4", "address": "10.0.0.250", "is_link_local": false}
+		]}
+	},
+	"canbus": {"can0": {"tx_queue_len": 128, "bitrate": 500000, "driver": "mcp251x"}}
+}}`
+
+func TestMachineInfo_Decode(t *testing.T) {
+	assert := assert.New(t)
+	var info MachineInfo
+	assert.NoError(json.Unmarshal([]byte(systemInfoJSON), &info))
+	sys := info.SystemInfo
+	assert.Len(sys.Network, 4)
+	assert.Equal("b8:27:eb:00:00:01", sys.Network["wlan0"].MACAddress)
+	assert.True(sys.ServiceState.Active("klipper"))
+	assert.False(sys.ServiceState.Active("crowsnest"))
+	assert.False(sys.ServiceState.Active("moonraker"))
+	assert.Equal(500000, sys.Canbus["can0"].Bitrate)
+	assert.Contains(sys.Python.VersionString, "3.9.2")
+
+	ip, ok := sys.Network.PrimaryIP()
+	assert.True(ok)
+	assert.Equal("10.0.0.250", ip)
+	delete(sys.Network, "eth0")
+	ip, _ = sys.Network.PrimaryIP()
+	assert.Equal("192.168.1.127", ip)
+	delete(sys.Network, "wlan0")
+	_, ok = sys.Network.PrimaryIP()
+	assert.False(ok)
+}
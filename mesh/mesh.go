@@ -0,0 +1,301 @@
+// Package mesh analyses and renders Klipper bed meshes: range and deviation,
+// the best fitting tilt plane, differences between profiles, and PNG, SVG
+// and ASCII views.
+package mesh
+
+import (
+	"errors"
+	"fmt"
+	moonraker "github.com/derek-elliott/go-moonraker"
+	"math"
+	"sort"
+)
+
+// Mesh is a grid of probed Z offsets. Points is indexed [row][column], with
+// rows running from MinY to MaxY and columns from MinX to MaxX, the same
+// layout Klipper reports.
+type Mesh struct {
+	Name       string
+	MinX, MaxX float64
+	MinY, MaxY float64
+	Points     [][]float64
+}
+
+var ErrEmpty = errors.New("mesh has no points")
+
+func convert(points [][]float32) ([][]float64, error) {
+	if len(points) == 0 || len(points[0]) == 0 {
+		return nil, ErrEmpty
+	}
+	out := make([][]float64, len(points))
+	for i, row := range points {
+		if len(row) != len(points[0]) {
+			return nil, fmt.Errorf("row %d has %d points, expected %d", i, len(row), len(points[0]))
+		}
+		out[i] = make([]float64, len(row))
+		for j, z := range row {
+			out[i][j] = float64(z)
+		}
+	}
+	return out, nil
+}
+
+// FromBedMesh returns the probed points of the active mesh.
+func FromBedMesh(bm *moonraker.BedMesh) (*Mesh, error) {
+	if bm == nil || len(bm.MeshMin) < 2 || len(bm.MeshMax) < 2 {
+		return nil, ErrEmpty
+	}
+	points, err := convert(bm.ProbedMatrix)
+	if err != nil {
+		return nil, err
+	}
+	return &Mesh{
+		Name: bm.ProfileName,
+		MinX: float64(bm.MeshMin[0]), MaxX: float64(bm.MeshMax[0]),
+		MinY: float64(bm.MeshMin[1]), MaxY: float64(bm.MeshMax[1]),
+		Points: points,
+	}, nil
+}
+
+func FromProfile(name string, p moonraker.BedMeshProfile) (*Mesh, error) {
+	points, err := convert(p.Points)
+	if err != nil {
+		return nil, fmt.Errorf("profile %s: %w", name, err)
+	}
+	mp := p.MeshParams
+	return &Mesh{
+		Name: name,
+		MinX: float64(mp.MinX), MaxX: float64(mp.MaxX),
+		MinY: float64(mp.MinY), MaxY: float64(mp.MaxY),
+		Points: points,
+	}, nil
+}
+
+// Current fetches the mesh that is currently loaded.
+func Current(client *moonraker.MoonClient) (*Mesh, error) {
+	objects, err := client.QueryPrinterObjects("bed_mesh")
+	if err != nil {
+		return nil, err
+	}
+	return FromBedMesh(objects.BedMesh)
+}
+
+// Profiles fetches every profile stored in the printer's config.
+func Profiles(client *moonraker.MoonClient) (map[string]*Mesh, error) {
+	objects, err := client.QueryPrinterObjects("bed_mesh")
+	if err != nil {
+		return nil, err
+	}
+	meshes := make(map[string]*Mesh)
+	if objects.BedMesh == nil {
+		return meshes, nil
+	}
+	for name, p := range objects.BedMesh.Profiles {
+		m, err := FromProfile(name, p)
+		if err != nil {
+			return nil, err
+		}
+		meshes[name] = m
+	}
+	return meshes, nil
+}
+
+// ProfileNames returns the names of meshes in sorted order.
+func ProfileNames(meshes map[string]*Mesh) []string {
+	names := make([]string, 0, len(meshes))
+	for name := range meshes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m *Mesh) Rows() int { return len(m.Points) }
+
+func (m *Mesh) Cols() int {
+	if len(m.Points) == 0 {
+		return 0
+	}
+	return len(m.Points[0])
+}
+
+// X returns the bed coordinate of a column.
+func (m *Mesh) X(col int) float64 {
+	if m.Cols() < 2 {
+		return m.MinX
+	}
+	return m.MinX + (m.MaxX-m.MinX)*float64(col)/float64(m.Cols()-1)
+}
+
+// Y returns the bed coordinate of a row.
+func (m *Mesh) Y(row int) float64 {
+	if m.Rows() < 2 {
+		return m.MinY
+	}
+	return m.MinY + (m.MaxY-m.MinY)*float64(row)/float64(m.Rows()-1)
+}
+
+type Point struct {
+	X, Y, Z float64
+}
+
+type Stats struct {
+	Min, Max Point
+	// Range is Max.Z - Min.Z, the figure usually quoted for a mesh.
+	Range  float64
+	Mean   float64
+	StdDev float64
+}
+
+func (m *Mesh) Stats() Stats {
+	var s Stats
+	n := 0
+	for i, row := range m.Points {
+		for j, z := range row {
+			p := Point{m.X(j), m.Y(i), z}
+			if n == 0 || z < s.Min.Z {
+				s.Min = p
+			}
+			if n == 0 || z > s.Max.Z {
+				s.Max = p
+			}
+			s.Mean += z
+			n++
+		}
+	}
+	if n == 0 {
+		return s
+	}
+	s.Mean /= float64(n)
+	for _, row := range m.Points {
+		for _, z := range row {
+			s.StdDev += (z - s.Mean) * (z - s.Mean)
+		}
+	}
+	s.StdDev = math.Sqrt(s.StdDev / float64(n))
+	s.Range = s.Max.Z - s.Min.Z
+	return s
+}
+
+// Plane is z = SlopeX*x + SlopeY*y + Offset in bed coordinates.
+type Plane struct {
+	SlopeX, SlopeY, Offset float64
+	// RMS of the points' distance from the plane, the unevenness left once
+	// the tilt is taken out.
+	Residual float64
+}
+
+func (p Plane) At(x, y float64) float64 {
+	return p.SlopeX*x + p.SlopeY*y + p.Offset
+}
+
+// TiltX and TiltY return the plane's tilt in degrees along each axis.
+func (p Plane) TiltX() float64 { return math.Atan(p.SlopeX) * 180 / math.Pi }
+func (p Plane) TiltY() float64 { return math.Atan(p.SlopeY) * 180 / math.Pi }
+
+// FitPlane finds the least squares plane through the mesh, which shows how
+// far the bed is tilted relative to the gantry.
+func (m *Mesh) FitPlane() (Plane, error) {
+	// Normal equations for [a b c] minimising sum (a*x + b*y + c - z)^2.
+	var sxx, sxy, sx, syy, sy, n, sxz, syz, sz float64
+	for i, row := range m.Points {
+		y := m.Y(i)
+		for j, z := range row {
+			x := m.X(j)
+			sxx += x * x
+			sxy += x * y
+			sx += x
+			syy += y * y
+			sy += y
+			n++
+			sxz += x * z
+			syz += y * z
+			sz += z
+		}
+	}
+	a := [3][4]float64{
+		{sxx, sxy, sx, sxz},
+		{sxy, syy, sy, syz},
+		{sx, sy, n, sz},
+	}
+	sol, ok := solve3(a)
+	if !ok {
+		return Plane{}, errors.New("mesh points are collinear, no plane fits")
+	}
+	p := Plane{SlopeX: sol[0], SlopeY: sol[1], Offset: sol[2]}
+	var sq float64
+	for i, row := range m.Points {
+		for j, z := range row {
+			d := z - p.At(m.X(j), m.Y(i))
+			sq += d * d
+		}
+	}
+	p.Residual = math.Sqrt(sq / n)
+	return p, nil
+}
+
+// solve3 solves a 3x3 linear system given as an augmented matrix using
+// Gaussian elimination with partial pivoting.
+func solve3(a [3][4]float64) ([3]float64, bool) {
+	for col := 0; col < 3; col++ {
+		pivot := col
+		for r := col + 1; r < 3; r++ {
+			if math.Abs(a[r][col]) > math.Abs(a[pivot][col]) {
+				pivot = r
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-12 {
+			return [3]float64{}, false
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		for r := 0; r < 3; r++ {
+			if r == col {
+				continue
+			}
+			f := a[r][col] / a[col][col]
+			for c := col; c < 4; c++ {
+				a[r][c] -= f * a[col][c]
+			}
+		}
+	}
+	return [3]float64{a[0][3] / a[0][0], a[1][3] / a[1][1], a[2][3] / a[2][2]}, true
+}
+
+// Flatten returns a copy of the mesh with the plane subtracted.
+func (m *Mesh) Flatten(p Plane) *Mesh {
+	out := m.copyShape(m.Name + " (flattened)")
+	for i, row := range m.Points {
+		for j, z := range row {
+			out.Points[i][j] = z - p.At(m.X(j), m.Y(i))
+		}
+	}
+	return out
+}
+
+// Delta returns to minus from, point by point. Both meshes must cover the
+// same area with the same number of points.
+func Delta(from, to *Mesh) (*Mesh, error) {
+	if from.Rows() != to.Rows() || from.Cols() != to.Cols() {
+		return nil, fmt.Errorf("mesh sizes differ: %dx%d and %dx%d", from.Cols(), from.Rows(), to.Cols(), to.Rows())
+	}
+	const eps = 1e-3
+	if math.Abs(from.MinX-to.MinX) > eps || math.Abs(from.MaxX-to.MaxX) > eps ||
+		math.Abs(from.MinY-to.MinY) > eps || math.Abs(from.MaxY-to.MaxY) > eps {
+		return nil, errors.New("meshes cover different areas")
+	}
+	out := to.copyShape(to.Name + " - " + from.Name)
+	for i, row := range to.Points {
+		for j, z := range row {
+			out.Points[i][j] = z - from.Points[i][j]
+		}
+	}
+	return out, nil
+}
+
+func (m *Mesh) copyShape(name string) *Mesh {
+	out := &Mesh{Name: name, MinX: m.MinX, MaxX: m.MaxX, MinY: m.MinY, MaxY: m.MaxY, Points: make([][]float64, m.Rows())}
+	for i := range out.Points {
+		out.Points[i] = make([]float64, m.Cols())
+	}
+	return out
+}
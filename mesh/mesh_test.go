@@ -0,0 +1,122 @@
+package mesh
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/creachadair/jrpc2"
+	"github.com/creachadair/jrpc2/channel"
+	"github.com/creachadair/jrpc2/handler"
+	moonraker "github.com/derek-elliott/go-moonraker"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const bedMeshStatus = `{"eventtime": 1, "status": {"bed_mesh": {
+	"profile_name": "default",
+	"mesh_min": [10, 20],
+	"mesh_max": [210, 220],
+	"probed_matrix": [[0.1, 0.0, -0.1], [0.05, 0.0, -0.05], [0.2, 0.1, 0.0]],
+	"mesh_matrix": [[0.1, 0.0, -0.1]],
+	"profiles": {
+		"default": {
+			"points": [[0.1, 0.0, -0.1], [0.05, 0.0, -0.05], [0.2, 0.1, 0.0]],
+			"mesh_params": {"min_x": 10, "max_x": 210, "min_y": 20, "max_y": 220, "x_count": 3, "y_count": 3, "mesh_x_pps": 2, "mesh_y_pps": 2, "algo": "lagrange", "tension": 0.2}
+		},
+		"hot": {
+			"points": [[0.15, 0.05, -0.1], [0.05, 0.0, -0.05], [0.2, 0.1, 0.05]],
+			"mesh_params": {"min_x": 10, "max_x": 210, "min_y": 20, "max_y": 220, "x_count": 3, "y_count": 3}
+		}
+	}
+}}}`
+
+func newTestClient(t *testing.T) *moonraker.MoonClient {
+	cch, sch := channel.Direct()
+	srv := jrpc2.NewServer(handler.Map{
+		"printer.objects.query": handler.New(func(ctx context.Context, params moonraker.QueryObjectParams) (json.RawMessage, error) {
+			return json.RawMessage(bedMeshStatus), nil
+		}),
+	}, nil).Start(sch)
+	c := moonraker.NewClientFromChannel(cch, "printer.local", nil)
+	t.Cleanup(func() {
+		c.Close()
+		srv.Stop()
+		srv.Wait()
+	})
+	return c
+}
+
+func TestProfiles(t *testing.T) {
+	assert := assert.New(t)
+	client := newTestClient(t)
+
+	current, err := Current(client)
+	assert.NoError(err)
+	assert.Equal("default", current.Name)
+	assert.Equal(3, current.Cols())
+	assert.Equal(110.0, current.X(1))
+	assert.Equal(220.0, current.Y(2))
+
+	profiles, err := Profiles(client)
+	assert.NoError(err)
+	assert.Equal([]string{"default", "hot"}, ProfileNames(profiles))
+	assert.InDelta(0.15, profiles["hot"].Points[0][0], 1e-6)
+
+	delta, err := Delta(profiles["default"], profiles["hot"])
+	assert.NoError(err)
+	assert.Equal("hot - default", delta.Name)
+	assert.InDelta(0.05, delta.Points[0][0], 1e-6)
+	assert.InDelta(0.05, delta.Points[0][1], 1e-6)
+	assert.InDelta(0, delta.Points[1][1], 1e-6)
+	assert.InDelta(0.05, delta.Points[2][2], 1e-6)
+
+	_, err = Delta(profiles["default"], &Mesh{Points: [][]float64{{0}}})
+	assert.EqualError(err, "mesh sizes differ: 3x3 and 1x1")
+}
+
+func TestStats(t *testing.T) {
+	assert := assert.New(t)
+	m := &Mesh{MinX: 0, MaxX: 100, MinY: 0, MaxY: 100, Points: [][]float64{{-0.1, 0}, {0.1, 0.2}}}
+	s := m.Stats()
+	assert.Equal(Point{0, 0, -0.1}, s.Min)
+	assert.Equal(Point{100, 100, 0.2}, s.Max)
+	assert.InDelta(0.3, s.Range, 1e-9)
+	assert.InDelta(0.05, s.Mean, 1e-9)
+	assert.InDelta(0.1118, s.StdDev, 1e-4)
+}
+
+func TestFitPlane(t *testing.T) {
+	assert := assert.New(t)
+	// A bed tilted 0.002 mm/mm along X and -0.001 along Y, with one bump.
+	m := &Mesh{MinX: 0, MaxX: 200, MinY: 0, MaxY: 200, Points: make([][]float64, 5)}
+	for i := range m.Points {
+		m.Points[i] = make([]float64, 5)
+		for j := range m.Points[i] {
+			m.Points[i][j] = 0.002*m.X(j) - 0.001*m.Y(i) + 0.05
+		}
+	}
+	p, err := m.FitPlane()
+	assert.NoError(err)
+	assert.InDelta(0.002, p.SlopeX, 1e-9)
+	assert.InDelta(-0.001, p.SlopeY, 1e-9)
+	assert.InDelta(0.05, p.Offset, 1e-9)
+	assert.InDelta(0, p.Residual, 1e-9)
+	assert.InDelta(0.1146, p.TiltX(), 1e-4)
+
+	flat := m.Flatten(p)
+	assert.InDelta(0, flat.Stats().Range, 1e-9)
+
+	m.Points[2][2] += 0.1
+	p, err = m.FitPlane()
+	assert.NoError(err)
+	assert.Greater(p.Residual, 0.01)
+
+	_, err = (&Mesh{MinX: 0, MaxX: 100, Points: [][]float64{{0, 1, 2}}}).FitPlane()
+	assert.Error(err)
+}
+
+func TestFromBedMesh_Empty(t *testing.T) {
+	_, err := FromBedMesh(&moonraker.BedMesh{MeshMin: []float32{0, 0}, MeshMax: []float32{1, 1}})
+	assert.ErrorIs(t, err, ErrEmpty)
+	_, err = FromBedMesh(nil)
+	assert.ErrorIs(t, err, ErrEmpty)
+}
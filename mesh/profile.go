@@ -0,0 +1,246 @@
+package mesh
+
+import (
+	"errors"
+	"fmt"
+	moonraker "github.com/derek-elliott/go-moonraker"
+	"strings"
+	"time"
+)
+
+// CalibrateParams are the optional arguments of BED_MESH_CALIBRATE. Zero
+// values leave Klipper's configured defaults in place.
+type CalibrateParams struct {
+	// Profile stores the result under this name instead of "default".
+	Profile string
+	// Method is "automatic" by default, or "manual" for paper probing.
+	Method     string
+	MeshMin    *[2]float64
+	MeshMax    *[2]float64
+	ProbeCount *[2]int
+	MeshPPS    *[2]int
+	Algorithm  string
+	// Adaptive only probes the area of the objects defined for the current
+	// print, growing it by AdaptiveMargin millimetres.
+	Adaptive       bool
+	AdaptiveMargin float64
+}
+
+// quote formats a G-code parameter value, quoting names with spaces. Names
+// that would start a comment or end the command are rejected.
+func quote(value string) (string, error) {
+	if value == "" || strings.ContainsAny(value, "\"\n\r;#") {
+		return "", fmt.Errorf("invalid profile name %q", value)
+	}
+	if strings.ContainsAny(value, " \t") {
+		return `"` + value + `"`, nil
+	}
+	return value, nil
+}
+
+func (p CalibrateParams) gcode() (string, error) {
+	args := []string{"BED_MESH_CALIBRATE"}
+	if p.Profile != "" {
+		name, err := quote(p.Profile)
+		if err != nil {
+			return "", err
+		}
+		args = append(args, "PROFILE="+name)
+	}
+	if p.Method != "" {
+		args = append(args, "METHOD="+p.Method)
+	}
+	if p.MeshMin != nil {
+		args = append(args, fmt.Sprintf("MESH_MIN=%g,%g", p.MeshMin[0], p.MeshMin[1]))
+	}
+	if p.MeshMax != nil {
+		args = append(args, fmt.Sprintf("MESH_MAX=%g,%g", p.MeshMax[0], p.MeshMax[1]))
+	}
+	if p.ProbeCount != nil {
+		args = append(args, fmt.Sprintf("PROBE_COUNT=%d,%d", p.ProbeCount[0], p.ProbeCount[1]))
+	}
+	if p.MeshPPS != nil {
+		args = append(args, fmt.Sprintf("MESH_PPS=%d,%d", p.MeshPPS[0], p.MeshPPS[1]))
+	}
+	if p.Algorithm != "" {
+		args = append(args, "ALGORITHM="+p.Algorithm)
+	}
+	if p.Adaptive {
+		args = append(args, "ADAPTIVE=1")
+		if p.AdaptiveMargin > 0 {
+			args = append(args, fmt.Sprintf("ADAPTIVE_MARGIN=%g", p.AdaptiveMargin))
+		}
+	}
+	return strings.Join(args, " "), nil
+}
+
+// Calibrate probes a new mesh and returns it once probing has finished. The
+// G-code call blocks until Klipper completes the command, which can take
+// minutes on large grids.
+func Calibrate(client *moonraker.MoonClient, params CalibrateParams) (*Mesh, error) {
+	code, err := params.gcode()
+	if err != nil {
+		return nil, err
+	}
+	if err := client.RunGcode(code); err != nil {
+		return nil, err
+	}
+	return Current(client)
+}
+
+func profileCommand(client *moonraker.MoonClient, action, name string) error {
+	value, err := quote(name)
+	if err != nil {
+		return err
+	}
+	return client.RunGcode(fmt.Sprintf("BED_MESH_PROFILE %s=%s", action, value))
+}
+
+// Load activates a stored profile and returns it.
+func Load(client *moonraker.MoonClient, name string) (*Mesh, error) {
+	if err := profileCommand(client, "LOAD", name); err != nil {
+		return nil, err
+	}
+	return Current(client)
+}
+
+// Save stores the active mesh as a profile. Profiles only survive a restart
+// once written with SaveConfig.
+func Save(client *moonraker.MoonClient, name string) (*Mesh, error) {
+	if err := profileCommand(client, "SAVE", name); err != nil {
+		return nil, err
+	}
+	profiles, err := Profiles(client)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("profile %s was not saved", name)
+	}
+	return m, nil
+}
+
+// Remove deletes a stored profile. Like Save, it needs SaveConfig to last.
+func Remove(client *moonraker.MoonClient, name string) error {
+	return profileCommand(client, "REMOVE", name)
+}
+
+// Clear unloads the active mesh.
+func Clear(client *moonraker.MoonClient) error {
+	return client.RunGcode("BED_MESH_CLEAR")
+}
+
+type SaveConfigOptions struct {
+	// How long to wait for Klipper to come back after the restart, two
+	// minutes by default.
+	Timeout time.Duration
+	// How often the Klippy state is checked, one second by default.
+	PollInterval time.Duration
+	// How long to wait for Klipper to start restarting after SAVE_CONFIG
+	// returned, five seconds by default.
+	RestartGrace time.Duration
+}
+
+var ErrPrinting = errors.New("printer is busy printing")
+
+// SaveConfig writes pending profile changes to printer.cfg with SAVE_CONFIG,
+// which restarts Klipper. It does nothing when no changes are pending and
+// refuses to run during a print. Otherwise it waits for Klipper to be ready
+// again and then reloads the profile that was active before, since Klipper
+// only loads "default" on startup. The active mesh is returned, or nil when
+// none is loaded.
+func SaveConfig(client *moonraker.MoonClient, opts SaveConfigOptions) (*Mesh, error) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 2 * time.Minute
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	if opts.RestartGrace <= 0 {
+		opts.RestartGrace = 5 * time.Second
+	}
+
+	var resp struct {
+		Status struct {
+			moonraker.PrinterObjects
+			Configfile *struct {
+				SaveConfigPending bool `json:"save_config_pending"`
+			} `json:"configfile"`
+		} `json:"status"`
+	}
+	params := moonraker.QueryObjectParams{Objects: map[string]interface{}{
+		"print_stats": nil,
+		"bed_mesh":    nil,
+		"configfile":  []string{"save_config_pending"},
+	}}
+	if err := client.QueryObject(params, &resp); err != nil {
+		return nil, err
+	}
+	objects := resp.Status
+	if objects.Configfile != nil && !objects.Configfile.SaveConfigPending {
+		return current(client)
+	}
+	if s := objects.PrintStats; s != nil && (s.State == "printing" || s.State == "paused") {
+		return nil, ErrPrinting
+	}
+	var active string
+	if objects.BedMesh != nil {
+		active = objects.BedMesh.ProfileName
+	}
+
+	saveErr := client.RunGcode("SAVE_CONFIG")
+	restarted, err := waitForRestart(client, opts)
+	if err != nil {
+		return nil, err
+	}
+	if saveErr != nil && !restarted {
+		// The call also fails when Klippy goes away before answering, so
+		// only an error Klippy stayed ready through, like an invalid
+		// config, is a real one.
+		return nil, saveErr
+	}
+
+	if active != "" && active != "default" {
+		if m, err := Load(client, active); err == nil {
+			return m, nil
+		}
+		// The profile may have been removed before saving; fall through
+		// and report whatever Klipper loaded.
+	}
+	return current(client)
+}
+
+// current is Current, with no active mesh reported as nil.
+func current(client *moonraker.MoonClient) (*Mesh, error) {
+	m, err := Current(client)
+	if errors.Is(err, ErrEmpty) {
+		return nil, nil
+	}
+	return m, err
+}
+
+// waitForRestart waits for Klippy to leave the ready state and come back,
+// and reports whether it was seen leaving. If it never leaves within
+// RestartGrace the restart may have happened between polls.
+func waitForRestart(client *moonraker.MoonClient, opts SaveConfigOptions) (bool, error) {
+	ready := func() bool {
+		info, err := client.QueryServerInfo()
+		return err == nil && info.KlippyConnected && info.KlippyState == "ready"
+	}
+	left := false
+	grace := time.Now().Add(opts.RestartGrace)
+	for !left && time.Now().Before(grace) {
+		if left = !ready(); !left {
+			time.Sleep(opts.PollInterval)
+		}
+	}
+	deadline := time.Now().Add(opts.Timeout)
+	for !ready() {
+		if time.Now().After(deadline) {
+			return left, fmt.Errorf("klipper was not ready within %s of SAVE_CONFIG", opts.Timeout)
+		}
+		time.Sleep(opts.PollInterval)
+	}
+	return left, nil
+}
@@ -0,0 +1,204 @@
+package mesh
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/creachadair/jrpc2"
+	"github.com/creachadair/jrpc2/channel"
+	"github.com/creachadair/jrpc2/handler"
+	moonraker "github.com/derek-elliott/go-moonraker"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeKlipper keeps just enough bed mesh state to follow the profile
+// commands.
+type fakeKlipper struct {
+	mu         sync.Mutex
+	gcode      []string
+	printState string
+	active     string
+	profiles   map[string][][]float32
+	// restartPolls is how many server.info calls report "startup" after
+	// SAVE_CONFIG.
+	restartPolls int
+	neverReady   bool
+	// pending is Klipper's save_config_pending, and badConfig makes
+	// SAVE_CONFIG fail without restarting.
+	pending   bool
+	badConfig bool
+}
+
+var flatPoints = [][]float32{{0, 0.01}, {0.02, 0.03}}
+
+func (k *fakeKlipper) client(t *testing.T) *moonraker.MoonClient {
+	cch, sch := channel.Direct()
+	srv := jrpc2.NewServer(handler.Map{
+		"printer.gcode.script": handler.New(func(ctx context.Context, params map[string]string) (string, error) {
+			k.mu.Lock()
+			defer k.mu.Unlock()
+			script := params["script"]
+			k.gcode = append(k.gcode, script)
+			fields := strings.Fields(script)
+			switch {
+			case fields[0] == "BED_MESH_CALIBRATE":
+				k.active = "default"
+				for _, f := range fields[1:] {
+					if strings.HasPrefix(f, "PROFILE=") {
+						k.active = strings.TrimPrefix(f, "PROFILE=")
+					}
+				}
+				k.profiles[k.active] = flatPoints
+				k.pending = true
+			case strings.HasPrefix(script, "BED_MESH_PROFILE LOAD="):
+				name := strings.Trim(strings.TrimPrefix(script, "BED_MESH_PROFILE LOAD="), `"`)
+				if _, ok := k.profiles[name]; !ok {
+					return "", jrpc2.Errorf(400, "bed_mesh: Unknown profile [%s]", name)
+				}
+				k.active = name
+			case strings.HasPrefix(script, "BED_MESH_PROFILE SAVE="):
+				k.profiles[strings.Trim(strings.TrimPrefix(script, "BED_MESH_PROFILE SAVE="), `"`)] = flatPoints
+				k.pending = true
+			case fields[0] == "SAVE_CONFIG":
+				if k.badConfig {
+					return "", jrpc2.Errorf(400, "Unable to parse existing config on SAVE_CONFIG")
+				}
+				k.pending = false
+				k.restartPolls = 2
+				k.active = "default"
+				return "", jrpc2.Errorf(503, "Klippy Disconnected")
+			}
+			return "ok", nil
+		}),
+		"server.info": handler.New(func(ctx context.Context) (moonraker.ServerInfo, error) {
+			k.mu.Lock()
+			defer k.mu.Unlock()
+			if k.restartPolls > 0 || k.neverReady {
+				k.restartPolls--
+				return moonraker.ServerInfo{KlippyConnected: false, KlippyState: "startup"}, nil
+			}
+			return moonraker.ServerInfo{KlippyConnected: true, KlippyState: "ready"}, nil
+		}),
+		"printer.objects.query": handler.New(func(ctx context.Context, params moonraker.QueryObjectParams) (json.RawMessage, error) {
+			k.mu.Lock()
+			defer k.mu.Unlock()
+			profiles := make(map[string]moonraker.BedMeshProfile)
+			for name, points := range k.profiles {
+				profiles[name] = moonraker.BedMeshProfile{Points: points, MeshParams: moonraker.BedMeshParams{MaxX: 100, MaxY: 100, XCount: 2, YCount: 2}}
+			}
+			bm := moonraker.BedMesh{ProfileName: k.active, MeshMin: []float32{0, 0}, MeshMax: []float32{100, 100}, Profiles: profiles}
+			if k.active != "" {
+				bm.ProbedMatrix = k.profiles[k.active]
+			}
+			return json.Marshal(map[string]interface{}{"eventtime": 1, "status": map[string]interface{}{
+				"bed_mesh":    bm,
+				"print_stats": moonraker.PrintStats{State: k.printState},
+				"configfile":  map[string]bool{"save_config_pending": k.pending},
+			}})
+		}),
+	}, nil).Start(sch)
+	c := moonraker.NewClientFromChannel(cch, "printer.local", nil)
+	t.Cleanup(func() {
+		c.Close()
+		srv.Stop()
+		srv.Wait()
+	})
+	return c
+}
+
+func TestCalibrateParams_Gcode(t *testing.T) {
+	assert := assert.New(t)
+	code, err := CalibrateParams{}.gcode()
+	assert.NoError(err)
+	assert.Equal("BED_MESH_CALIBRATE", code)
+
+	code, err = CalibrateParams{
+		Profile:    "hot bed",
+		MeshMin:    &[2]float64{20, 20.5},
+		MeshMax:    &[2]float64{230, 230},
+		ProbeCount: &[2]int{7, 7},
+		Algorithm:  "bicubic",
+		Adaptive:   true, AdaptiveMargin: 5,
+	}.gcode()
+	assert.NoError(err)
+	assert.Equal(`BED_MESH_CALIBRATE PROFILE="hot bed" MESH_MIN=20,20.5 MESH_MAX=230,230 PROBE_COUNT=7,7 ALGORITHM=bicubic ADAPTIVE=1 ADAPTIVE_MARGIN=5`, code)
+
+	for _, name := range []string{`a"b`, "a;b", "a#b", "a\nG28"} {
+		_, err = CalibrateParams{Profile: name}.gcode()
+		assert.Error(err, name)
+	}
+}
+
+func TestProfileCommands(t *testing.T) {
+	assert := assert.New(t)
+	k := &fakeKlipper{profiles: map[string][][]float32{"default": {{0, 0}, {0, 0}}}}
+	client := k.client(t)
+
+	m, err := Calibrate(client, CalibrateParams{Profile: "pei", ProbeCount: &[2]int{2, 2}})
+	assert.NoError(err)
+	assert.Equal("pei", m.Name)
+	assert.InDelta(0.03, m.Points[1][1], 1e-6)
+
+	m, err = Save(client, "pei 110C")
+	assert.NoError(err)
+	assert.Equal("pei 110C", m.Name)
+	m, err = Load(client, "default")
+	assert.NoError(err)
+	assert.Equal("default", m.Name)
+	assert.NoError(Remove(client, "pei"))
+	_, err = Load(client, "missing")
+	assert.EqualError(err, "[400] bed_mesh: Unknown profile [missing]")
+	assert.NoError(Clear(client))
+
+	assert.Equal([]string{
+		"BED_MESH_CALIBRATE PROFILE=pei PROBE_COUNT=2,2",
+		`BED_MESH_PROFILE SAVE="pei 110C"`,
+		"BED_MESH_PROFILE LOAD=default",
+		"BED_MESH_PROFILE REMOVE=pei",
+		"BED_MESH_PROFILE LOAD=missing",
+		"BED_MESH_CLEAR",
+	}, k.gcode)
+}
+
+func TestSaveConfig(t *testing.T) {
+	assert := assert.New(t)
+	k := &fakeKlipper{active: "pei", pending: true, profiles: map[string][][]float32{"default": flatPoints, "pei": flatPoints}}
+	client := k.client(t)
+	opts := SaveConfigOptions{PollInterval: time.Millisecond, RestartGrace: 20 * time.Millisecond, Timeout: time.Second}
+
+	m, err := SaveConfig(client, opts)
+	assert.NoError(err)
+	if assert.NotNil(m) {
+		assert.Equal("pei", m.Name)
+	}
+	assert.Equal([]string{"SAVE_CONFIG", "BED_MESH_PROFILE LOAD=pei"}, k.gcode)
+	assert.Zero(k.restartPolls)
+
+	// Nothing is pending, so Klipper isn't restarted.
+	k.printState = "paused"
+	m, err = SaveConfig(client, opts)
+	assert.NoError(err)
+	if assert.NotNil(m) {
+		assert.Equal("pei", m.Name)
+	}
+	assert.Len(k.gcode, 2)
+
+	k.pending = true
+	_, err = SaveConfig(client, opts)
+	assert.ErrorIs(err, ErrPrinting)
+
+	k.printState = "standby"
+	k.badConfig = true
+	_, err = SaveConfig(client, opts)
+	assert.EqualError(err, "[400] Unable to parse existing config on SAVE_CONFIG")
+	assert.True(k.pending)
+
+	k.badConfig = false
+	k.neverReady = true
+	_, err = SaveConfig(client, SaveConfigOptions{PollInterval: time.Millisecond, Timeout: 20 * time.Millisecond})
+	assert.EqualError(err, fmt.Sprintf("klipper was not ready within %s of SAVE_CONFIG", 20*time.Millisecond))
+}
@@ -0,0 +1,122 @@
+package mesh
+
+import (
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"strings"
+)
+
+// ASCII renders the mesh as a table of offsets in millimetres. The back of
+// the bed (MaxY) is printed first so the grid reads like the bed seen from
+// the front.
+func (m *Mesh) ASCII() string {
+	var b strings.Builder
+	if m.Name != "" {
+		fmt.Fprintf(&b, "%s\n", m.Name)
+	}
+	fmt.Fprintf(&b, "%8s", "Y \\ X")
+	for j := 0; j < m.Cols(); j++ {
+		fmt.Fprintf(&b, " %7.1f", m.X(j))
+	}
+	b.WriteString("\n")
+	for i := m.Rows() - 1; i >= 0; i-- {
+		fmt.Fprintf(&b, "%8.1f", m.Y(i))
+		for _, z := range m.Points[i] {
+			fmt.Fprintf(&b, " %+7.3f", z)
+		}
+		b.WriteString("\n")
+	}
+	s := m.Stats()
+	fmt.Fprintf(&b, "range %.3f  min %+.3f  max %+.3f  stddev %.3f\n", s.Range, s.Min.Z, s.Max.Z, s.StdDev)
+	return b.String()
+}
+
+type RenderOptions struct {
+	// Size of a mesh point in pixels, 40 by default.
+	CellSize int
+	// Scale is the offset, in millimetres, shown at full colour. Zero scales
+	// to the largest offset in the mesh. Use the same Scale to compare
+	// meshes side by side.
+	Scale float64
+}
+
+func (o RenderOptions) withDefaults(m *Mesh) RenderOptions {
+	if o.CellSize <= 0 {
+		o.CellSize = 40
+	}
+	if o.Scale <= 0 {
+		s := m.Stats()
+		o.Scale = math.Max(math.Abs(s.Min.Z), math.Abs(s.Max.Z))
+		if o.Scale == 0 {
+			o.Scale = 1
+		}
+	}
+	return o
+}
+
+// Color maps an offset to a diverging colour: blue below zero, white at
+// zero and red above, saturating at ±scale.
+func Color(z, scale float64) color.RGBA {
+	t := math.Max(-1, math.Min(1, z/scale))
+	fade := uint8(math.Round(255 * (1 - math.Abs(t))))
+	if t < 0 {
+		return color.RGBA{fade, fade, 255, 255}
+	}
+	return color.RGBA{255, fade, fade, 255}
+}
+
+// Image draws the mesh as a heatmap with one square per point, back of the
+// bed at the top.
+func (m *Mesh) Image(opts RenderOptions) image.Image {
+	opts = opts.withDefaults(m)
+	cell := opts.CellSize
+	img := image.NewRGBA(image.Rect(0, 0, m.Cols()*cell, m.Rows()*cell))
+	for i, row := range m.Points {
+		top := (m.Rows() - 1 - i) * cell
+		for j, z := range row {
+			c := Color(z, opts.Scale)
+			for y := top; y < top+cell; y++ {
+				for x := j * cell; x < (j+1)*cell; x++ {
+					img.SetRGBA(x, y, c)
+				}
+			}
+		}
+	}
+	return img
+}
+
+func (m *Mesh) PNG(w io.Writer, opts RenderOptions) error {
+	return png.Encode(w, m.Image(opts))
+}
+
+// SVG writes the heatmap as an SVG document with each point's offset
+// printed in its cell.
+func (m *Mesh) SVG(w io.Writer, opts RenderOptions) error {
+	opts = opts.withDefaults(m)
+	cell := opts.CellSize
+	width, height := m.Cols()*cell, m.Rows()*cell
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n", width, height, width, height)
+	if m.Name != "" {
+		fmt.Fprintf(&b, "<title>%s</title>\n", html.EscapeString(m.Name))
+	}
+	fontSize := float64(cell) / 4
+	for i, row := range m.Points {
+		top := (m.Rows() - 1 - i) * cell
+		for j, z := range row {
+			c := Color(z, opts.Scale)
+			fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d" fill="#%02x%02x%02x"><title>X%.1f Y%.1f: %+.3f</title></rect>`+"\n",
+				j*cell, top, cell, cell, c.R, c.G, c.B, m.X(j), m.Y(i), z)
+			fmt.Fprintf(&b, `<text x="%d" y="%d" font-family="monospace" font-size="%g" text-anchor="middle" dominant-baseline="middle">%+.3f</text>`+"\n",
+				j*cell+cell/2, top+cell/2, fontSize, z)
+		}
+	}
+	b.WriteString("</svg>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
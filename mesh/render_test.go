@@ -0,0 +1,61 @@
+package mesh
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func testMesh() *Mesh {
+	return &Mesh{Name: "default", MinX: 10, MaxX: 210, MinY: 20, MaxY: 220, Points: [][]float64{
+		{-0.1, 0, 0.05},
+		{0, 0.2, 0.1},
+	}}
+}
+
+func TestMesh_ASCII(t *testing.T) {
+	assert.Equal(t, `default
+   Y \ X    10.0   110.0   210.0
+   220.0  +0.000  +0.200  +0.100
+    20.0  -0.100  +0.000  +0.050
+range 0.300  min -0.100  max +0.200  stddev 0.093
+`, testMesh().ASCII())
+}
+
+func TestColor(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(color.RGBA{255, 255, 255, 255}, Color(0, 0.2))
+	assert.Equal(color.RGBA{255, 0, 0, 255}, Color(0.2, 0.2))
+	assert.Equal(color.RGBA{0, 0, 255, 255}, Color(-0.5, 0.2))
+	assert.Equal(color.RGBA{128, 128, 255, 255}, Color(-0.1, 0.2))
+}
+
+func TestMesh_PNG(t *testing.T) {
+	assert := assert.New(t)
+	var buf bytes.Buffer
+	assert.NoError(testMesh().PNG(&buf, RenderOptions{CellSize: 10}))
+	img, err := png.Decode(&buf)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(30, img.Bounds().Dx())
+	assert.Equal(20, img.Bounds().Dy())
+	// The back row is drawn on top, so the highest point is at the top
+	// middle and the lowest at the bottom left.
+	assert.Equal(color.RGBA{255, 0, 0, 255}, color.RGBAModel.Convert(img.At(15, 5)))
+	assert.Equal(color.RGBA{128, 128, 255, 255}, color.RGBAModel.Convert(img.At(5, 15)))
+}
+
+func TestMesh_SVG(t *testing.T) {
+	assert := assert.New(t)
+	var buf bytes.Buffer
+	assert.NoError(testMesh().SVG(&buf, RenderOptions{CellSize: 20, Scale: 0.4}))
+	out := buf.String()
+	assert.True(strings.HasPrefix(out, `<svg xmlns="http://www.w3.org/2000/svg" width="60" height="40"`))
+	assert.Equal(6, strings.Count(out, "<rect "))
+	assert.Contains(out, `<rect x="20" y="0" width="20" height="20" fill="#ff8080"><title>X110.0 Y220.0: +0.200</title></rect>`)
+	assert.Contains(out, `>-0.100</text>`)
+}
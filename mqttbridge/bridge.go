@@ -0,0 +1,380 @@
+// Package mqttbridge mirrors the state of a Moonraker instance onto MQTT
+// topics and maps command topics onto MoonClient actions.
+//
+// With a prefix of moonraker/voron the bridge publishes:
+//
+//	moonraker/voron/availability           "online" or "offline", retained
+//	moonraker/voron/status/<object>        full object state as JSON, retained
+//	moonraker/voron/notification/<method>  notification payload as JSON
+//	moonraker/voron/result                 outcome of each command
+//
+// and accepts commands on:
+//
+//	moonraker/voron/command/pause
+//	moonraker/voron/command/resume
+//	moonraker/voron/command/cancel
+//	moonraker/voron/command/emergency_stop
+//	moonraker/voron/command/firmware_restart
+//	moonraker/voron/command/gcode           payload is the G-code script
+//	moonraker/voron/command/power/<device>  payload is on, off or toggle
+package mqttbridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/creachadair/jrpc2"
+	moonraker "github.com/derek-elliott/go-moonraker"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	PayloadOnline  = "online"
+	PayloadOffline = "offline"
+)
+
+var DefaultObjects = []string{
+	"webhooks", "print_stats", "virtual_sdcard", "display_status", "idle_timeout",
+	"toolhead", "gcode_move", "extruder", "heater_bed", "fan",
+}
+
+var DefaultNotifications = []string{
+	"notify_gcode_response", "notify_klippy_ready", "notify_klippy_shutdown", "notify_klippy_disconnected",
+	"notify_filelist_changed", "notify_history_changed", "notify_job_queue_changed", "notify_power_changed",
+	"notify_service_state_changed",
+}
+
+type Options struct {
+	// Name identifies the printer in topics and Home Assistant.
+	Name string
+	// Topic prefix, "moonraker/<Name>" by default.
+	Prefix string
+	QoS    byte
+	// Printer objects mirrored to status topics, DefaultObjects by default.
+	Objects []string
+	// Notification methods forwarded to notification topics,
+	// DefaultNotifications by default.
+	Notifications []string
+	// Publish Home Assistant MQTT discovery payloads under DiscoveryPrefix,
+	// "homeassistant" by default.
+	Discovery       bool
+	DiscoveryPrefix string
+}
+
+func (o Options) withDefaults() Options {
+	if o.Name == "" {
+		o.Name = "printer"
+	}
+	if o.Prefix == "" {
+		o.Prefix = "moonraker/" + o.Name
+	}
+	if o.Objects == nil {
+		o.Objects = DefaultObjects
+	}
+	if o.Notifications == nil {
+		o.Notifications = DefaultNotifications
+	}
+	if o.DiscoveryPrefix == "" {
+		o.DiscoveryPrefix = "homeassistant"
+	}
+	return o
+}
+
+// AvailabilityTopic is where the bridge reports whether it is online. Use it
+// as the MQTT last will topic, with PayloadOffline retained, so subscribers
+// notice when the bridge goes away without stopping.
+func (o Options) AvailabilityTopic() string {
+	return o.withDefaults().Prefix + "/availability"
+}
+
+// maxQueuedNotifications bounds the notifications waiting for a slow broker.
+// The oldest are dropped beyond it.
+const maxQueuedNotifications = 256
+
+type message struct {
+	topic   string
+	payload []byte
+}
+
+// Bridge publishes from its own goroutine, so a slow or unreachable broker
+// never holds up the Moonraker client. Status changes are coalesced per
+// object while they wait, and only the newest state is published.
+type Bridge struct {
+	client *moonraker.MoonClient
+	broker Broker
+	opts   Options
+
+	mu            sync.Mutex
+	state         map[string]map[string]json.RawMessage
+	eventTimes    map[string]map[string]float64
+	pending       map[string][]byte
+	notifications []message
+	cancels       []func()
+	wake          chan struct{}
+	stop          chan struct{}
+	done          chan struct{}
+}
+
+func New(client *moonraker.MoonClient, broker Broker, opts Options) *Bridge {
+	return &Bridge{
+		client:     client,
+		broker:     broker,
+		opts:       opts.withDefaults(),
+		state:      make(map[string]map[string]json.RawMessage),
+		eventTimes: make(map[string]map[string]float64),
+		pending:    make(map[string][]byte),
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+func (b *Bridge) topic(parts ...string) string {
+	return b.opts.Prefix + "/" + strings.Join(parts, "/")
+}
+
+// ObjectTopic returns the status topic of a printer object. Spaces in object
+// names such as "heater_generic chamber" become underscores.
+func (b *Bridge) ObjectTopic(object string) string {
+	return b.topic("status", strings.ReplaceAll(object, " ", "_"))
+}
+
+// Start subscribes to the printer objects and command topics, publishes the
+// current state and, if enabled, the Home Assistant discovery payloads.
+func (b *Bridge) Start() error {
+	if err := b.broker.Subscribe(b.topic("command", "#"), b.opts.QoS, b.handleCommand); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.cancels = append(b.cancels, b.client.OnNotification("notify_status_update", b.handleStatus))
+	for _, method := range b.opts.Notifications {
+		b.cancels = append(b.cancels, b.client.OnNotification(method, b.forwardNotification))
+	}
+	b.mu.Unlock()
+
+	params := moonraker.QueryObjectParams{Objects: make(map[string]interface{}, len(b.opts.Objects))}
+	for _, object := range b.opts.Objects {
+		params.Objects[object] = nil
+	}
+	var resp struct {
+		EventTime float64                `json:"eventtime"`
+		Status    moonraker.StatusUpdate `json:"status"`
+	}
+	if err := b.client.Subscribe(params, &resp); err != nil {
+		b.Stop()
+		return err
+	}
+	b.applyStatus(resp.Status, resp.EventTime)
+	// Publish the initial state before the publisher starts, so it is
+	// retained by the time Start returns.
+	b.publishQueued()
+	b.mu.Lock()
+	b.stop, b.done = make(chan struct{}), make(chan struct{})
+	go b.run(b.stop, b.done)
+	b.mu.Unlock()
+
+	if b.opts.Discovery {
+		if err := b.publishDiscovery(); err != nil {
+			b.Stop()
+			return err
+		}
+	}
+	return b.broker.Publish(b.opts.AvailabilityTopic(), b.opts.QoS, true, []byte(PayloadOnline))
+}
+
+// Stop detaches the bridge from the client and broker, publishes what is
+// still queued and marks the printer offline.
+func (b *Bridge) Stop() error {
+	b.mu.Lock()
+	cancels := b.cancels
+	b.cancels = nil
+	stop, done := b.stop, b.done
+	b.stop, b.done = nil, nil
+	b.mu.Unlock()
+	for _, cancel := range cancels {
+		cancel()
+	}
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+	if err := b.broker.Unsubscribe(b.topic("command", "#")); err != nil {
+		return err
+	}
+	return b.broker.Publish(b.opts.AvailabilityTopic(), b.opts.QoS, true, []byte(PayloadOffline))
+}
+
+// run publishes queued messages until stop is closed.
+func (b *Bridge) run(stop, done chan struct{}) {
+	defer close(done)
+	for {
+		select {
+		case <-b.wake:
+			b.publishQueued()
+		case <-stop:
+			b.publishQueued()
+			return
+		}
+	}
+}
+
+func (b *Bridge) signal() {
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (b *Bridge) publishQueued() {
+	b.mu.Lock()
+	pending, notifications := b.pending, b.notifications
+	b.pending, b.notifications = make(map[string][]byte), nil
+	b.mu.Unlock()
+
+	objects := make([]string, 0, len(pending))
+	for object := range pending {
+		objects = append(objects, object)
+	}
+	sort.Strings(objects)
+	for _, object := range objects {
+		if err := b.broker.Publish(b.ObjectTopic(object), b.opts.QoS, true, pending[object]); err != nil {
+			log.WithError(err).Errorf("publishing status of %s", object)
+		}
+	}
+	for _, msg := range notifications {
+		if err := b.broker.Publish(msg.topic, b.opts.QoS, false, msg.payload); err != nil {
+			log.WithError(err).Errorf("publishing %s", msg.topic)
+		}
+	}
+}
+
+// handleStatus applies a notify_status_update, whose params are the changed
+// fields and the eventtime they were sampled at.
+func (b *Bridge) handleStatus(req *jrpc2.Request) {
+	var params []json.RawMessage
+	if err := req.UnmarshalParams(&params); err != nil || len(params) == 0 {
+		log.WithError(err).Error("decoding notify_status_update")
+		return
+	}
+	var update moonraker.StatusUpdate
+	if err := json.Unmarshal(params[0], &update); err != nil {
+		log.WithError(err).Error("decoding notify_status_update")
+		return
+	}
+	var eventTime float64
+	if len(params) > 1 {
+		json.Unmarshal(params[1], &eventTime)
+	}
+	b.applyStatus(update, eventTime)
+}
+
+// applyStatus merges an update into the known object state and queues the
+// complete state of every object it changed, so retained messages always hold
+// every field rather than just the last changes. Fields older than the ones
+// already known, such as those of the initial query racing a notification,
+// are ignored.
+func (b *Bridge) applyStatus(update moonraker.StatusUpdate, eventTime float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for object, raw := range update {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			log.WithError(err).Errorf("decoding status of %s", object)
+			continue
+		}
+		if b.state[object] == nil {
+			b.state[object] = make(map[string]json.RawMessage)
+			b.eventTimes[object] = make(map[string]float64)
+		}
+		changed := false
+		for field, value := range fields {
+			if last, ok := b.eventTimes[object][field]; ok && eventTime < last {
+				continue
+			}
+			b.state[object][field] = value
+			b.eventTimes[object][field] = eventTime
+			changed = true
+		}
+		if !changed {
+			continue
+		}
+		payload, err := json.Marshal(b.state[object])
+		if err != nil {
+			log.WithError(err).Errorf("encoding status of %s", object)
+			continue
+		}
+		b.pending[object] = payload
+	}
+	b.signal()
+}
+
+func (b *Bridge) forwardNotification(req *jrpc2.Request) {
+	var payload json.RawMessage
+	if err := moonraker.UnmarshalNotification(req, &payload); err != nil {
+		// Notifications like notify_klippy_ready carry no params.
+		payload = json.RawMessage("null")
+	}
+	topic := b.topic("notification", strings.TrimPrefix(req.Method(), "notify_"))
+	b.mu.Lock()
+	if len(b.notifications) == maxQueuedNotifications {
+		log.Warnf("dropping queued %s, the broker is not keeping up", b.notifications[0].topic)
+		b.notifications = b.notifications[1:]
+	}
+	b.notifications = append(b.notifications, message{topic, payload})
+	b.mu.Unlock()
+	b.signal()
+}
+
+type CommandResult struct {
+	Command string `json:"command"`
+	OK      bool   `json:"ok"`
+	Error   string `json:"error,omitempty"`
+}
+
+func (b *Bridge) handleCommand(topic string, payload []byte) {
+	command := strings.TrimPrefix(topic, b.topic("command")+"/")
+	// Commands call into Moonraker and publish their result, neither of which
+	// may block the MQTT client's delivery goroutine.
+	go func() {
+		result := CommandResult{Command: command, OK: true}
+		if err := b.runCommand(command, strings.TrimSpace(string(payload))); err != nil {
+			result.OK = false
+			result.Error = err.Error()
+		}
+		data, _ := json.Marshal(result)
+		if err := b.broker.Publish(b.topic("result"), b.opts.QoS, false, data); err != nil {
+			log.WithError(err).Errorf("publishing result of %s", command)
+		}
+	}()
+}
+
+func (b *Bridge) runCommand(command, payload string) error {
+	if device := strings.TrimPrefix(command, "power/"); device != command {
+		switch action := moonraker.PowerAction(strings.ToLower(payload)); action {
+		case moonraker.PowerActionOn, moonraker.PowerActionOff, moonraker.PowerActionToggle:
+			_, err := b.client.SetPowerDevice(device, action)
+			return err
+		default:
+			return fmt.Errorf("unknown power action: %s", payload)
+		}
+	}
+	switch command {
+	case "pause":
+		return b.client.PausePrint()
+	case "resume":
+		return b.client.ResumePrint()
+	case "cancel":
+		return b.client.CancelPrint()
+	case "emergency_stop":
+		return b.client.EmergencyStop()
+	case "firmware_restart":
+		return b.client.FirmwareRestart()
+	case "gcode":
+		if payload == "" {
+			return fmt.Errorf("empty gcode command")
+		}
+		return b.client.RunGcode(payload)
+	default:
+		return fmt.Errorf("unknown command: %s", command)
+	}
+}
@@ -0,0 +1,270 @@
+package mqttbridge
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/creachadair/jrpc2"
+	"github.com/creachadair/jrpc2/channel"
+	"github.com/creachadair/jrpc2/handler"
+	moonraker "github.com/derek-elliott/go-moonraker"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, methods handler.Map) (*moonraker.MoonClient, *jrpc2.Server) {
+	cch, sch := channel.Direct()
+	srv := jrpc2.NewServer(methods, &jrpc2.ServerOptions{AllowPush: true}).Start(sch)
+	c := moonraker.NewClientFromChannel(cch, "printer.local", nil)
+	t.Cleanup(func() {
+		c.Close()
+		srv.Stop()
+		srv.Wait()
+	})
+	return c, srv
+}
+
+// waitFor subscribes to filter and returns a function that blocks until the
+// next message arrives.
+func waitFor(t *testing.T, broker Broker, filter string) func() (string, []byte) {
+	type message struct {
+		topic   string
+		payload []byte
+	}
+	messages := make(chan message, 16)
+	broker.Subscribe(filter, 0, func(topic string, payload []byte) { messages <- message{topic, payload} })
+	return func() (string, []byte) {
+		select {
+		case msg := <-messages:
+			return msg.topic, msg.payload
+		case <-time.After(time.Second):
+			t.Fatalf("no message on %s", filter)
+		}
+		return "", nil
+	}
+}
+
+func TestBridge(t *testing.T) {
+	assert := assert.New(t)
+	var mu sync.Mutex
+	var calls []string
+	record := func(call string) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, call)
+	}
+	client, srv := newTestClient(t, handler.Map{
+		"printer.objects.subscribe": handler.New(func(ctx context.Context, params moonraker.QueryObjectParams) (json.RawMessage, error) {
+			return json.RawMessage(`{"eventtime": 1, "status": {
+				"extruder": {"temperature": 25.1, "target": 0, "power": 0},
+				"print_stats": {"state": "standby", "filename": ""}
+			}}`), nil
+		}),
+		"printer.print.pause": handler.New(func(ctx context.Context) (string, error) {
+			record("pause")
+			return "ok", nil
+		}),
+		"printer.gcode.script": handler.New(func(ctx context.Context, params map[string]string) (string, error) {
+			record("gcode " + params["script"])
+			return "ok", nil
+		}),
+		"machine.device_power.post_device": handler.New(func(ctx context.Context, params map[string]string) (map[string]string, error) {
+			record("power " + params["device"] + " " + params["action"])
+			return map[string]string{params["device"]: params["action"]}, nil
+		}),
+	})
+	broker := NewMemoryBroker()
+	bridge := New(client, broker, Options{Name: "Voron 2.4", Objects: []string{"extruder", "print_stats"}, Discovery: true})
+	assert.NoError(bridge.Start())
+
+	prefix := "moonraker/Voron 2.4"
+	assert.Equal(PayloadOnline, string(broker.Retained(prefix+"/availability")))
+	assert.JSONEq(`{"temperature": 25.1, "target": 0, "power": 0}`, string(broker.Retained(prefix+"/status/extruder")))
+
+	nextStatus := waitFor(t, broker, prefix+"/status/+")
+	nextStatus()
+	nextStatus()
+	srv.Notify(context.Background(), "notify_status_update", []interface{}{
+		map[string]interface{}{"extruder": map[string]interface{}{"target": 210, "power": 1}}, 2.0,
+	})
+	topic, payload := nextStatus()
+	assert.Equal(prefix+"/status/extruder", topic)
+	assert.JSONEq(`{"temperature": 25.1, "target": 210, "power": 1}`, string(payload))
+	assert.JSONEq(string(payload), string(broker.Retained(topic)))
+
+	nextNotification := waitFor(t, broker, prefix+"/notification/#")
+	srv.Notify(context.Background(), "notify_gcode_response", []interface{}{"// probe at 110,110 is z=0.02"})
+	topic, payload = nextNotification()
+	assert.Equal(prefix+"/notification/gcode_response", topic)
+	assert.Equal(`"// probe at 110,110 is z=0.02"`, string(payload))
+
+	nextResult := waitFor(t, broker, prefix+"/result")
+	result := func() CommandResult {
+		_, payload := nextResult()
+		var result CommandResult
+		assert.NoError(json.Unmarshal(payload, &result))
+		return result
+	}
+	broker.Publish(prefix+"/command/pause", 0, false, []byte("PRESS"))
+	assert.Equal(CommandResult{Command: "pause", OK: true}, result())
+	broker.Publish(prefix+"/command/gcode", 0, false, []byte("G28\n"))
+	assert.Equal(CommandResult{Command: "gcode", OK: true}, result())
+	broker.Publish(prefix+"/command/power/printer", 0, false, []byte("ON"))
+	assert.Equal(CommandResult{Command: "power/printer", OK: true}, result())
+	broker.Publish(prefix+"/command/power/printer", 0, false, []byte("sideways"))
+	assert.Equal(CommandResult{Command: "power/printer", Error: "unknown power action: sideways"}, result())
+	broker.Publish(prefix+"/command/launch", 0, false, nil)
+	assert.False(result().OK)
+	mu.Lock()
+	assert.Equal([]string{"pause", "gcode G28", "power printer on"}, calls)
+	mu.Unlock()
+
+	var config DiscoveryConfig
+	assert.NoError(json.Unmarshal(broker.Retained("homeassistant/sensor/voron_2_4/extruder_temperature/config"), &config))
+	assert.Equal(prefix+"/status/extruder", config.StateTopic)
+	assert.Equal("voron_2_4_extruder_temperature", config.UniqueId)
+	assert.Equal(prefix+"/availability", config.AvailabilityTopic)
+	assert.Equal([]string{"moonraker_voron_2_4"}, config.Device.Identifiers)
+	assert.NoError(json.Unmarshal(broker.Retained("homeassistant/button/voron_2_4/cancel/config"), &config))
+	assert.Equal(prefix+"/command/cancel", config.CommandTopic)
+	assert.Nil(broker.Retained("homeassistant/sensor/voron_2_4/bed_temperature/config"), "heater_bed is not bridged")
+
+	assert.NoError(bridge.Stop())
+	assert.Equal(PayloadOffline, string(broker.Retained(prefix+"/availability")))
+}
+
+func TestTopicMatches(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(TopicMatches("a/+/c", "a/b/c"))
+	assert.False(TopicMatches("a/+/c", "a/b/d"))
+	assert.True(TopicMatches("a/#", "a/b/c"))
+	assert.True(TopicMatches("a/#", "a"))
+	assert.False(TopicMatches("a/b", "a/b/c"))
+	assert.False(TopicMatches("a/b/c", "a/b"))
+}
+
+type fakeToken struct {
+	done chan struct{}
+	err  error
+}
+
+func (t *fakeToken) Wait() bool { <-t.done; return true }
+
+func (t *fakeToken) WaitTimeout(d time.Duration) bool {
+	select {
+	case <-t.done:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+func (t *fakeToken) Done() <-chan struct{} { return t.done }
+func (t *fakeToken) Error() error          { return t.err }
+
+// fakePahoClient completes publishes once the gate is open and records the
+// last retained payload per topic.
+type fakePahoClient struct {
+	mqtt.Client
+	mu       sync.Mutex
+	gate     chan struct{}
+	retained map[string]string
+	count    map[string]int
+	err      error
+}
+
+func newFakePahoClient() *fakePahoClient {
+	gate := make(chan struct{})
+	close(gate)
+	return &fakePahoClient{gate: gate, retained: make(map[string]string), count: make(map[string]int)}
+}
+
+func (c *fakePahoClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	c.mu.Lock()
+	gate, err := c.gate, c.err
+	c.mu.Unlock()
+	token := &fakeToken{done: make(chan struct{}), err: err}
+	go func() {
+		<-gate
+		c.mu.Lock()
+		if retained && err == nil {
+			c.retained[topic] = string(payload.([]byte))
+		}
+		c.count[topic]++
+		c.mu.Unlock()
+		close(token.done)
+	}()
+	return token
+}
+
+func (c *fakePahoClient) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	token := &fakeToken{done: make(chan struct{})}
+	close(token.done)
+	return token
+}
+
+func (c *fakePahoClient) Unsubscribe(topics ...string) mqtt.Token {
+	return c.Subscribe("", 0, nil)
+}
+
+func (c *fakePahoClient) state(topic string) (string, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.retained[topic], c.count[topic]
+}
+
+func TestBridge_SlowPahoBroker(t *testing.T) {
+	assert := assert.New(t)
+	client, srv := newTestClient(t, handler.Map{
+		"printer.objects.subscribe": handler.New(func(ctx context.Context, params moonraker.QueryObjectParams) (json.RawMessage, error) {
+			return json.RawMessage(`{"eventtime": 1, "status": {"extruder": {"temperature": 25, "target": 0}}}`), nil
+		}),
+		"printer.info": handler.New(func(ctx context.Context) (moonraker.PrinterInfo, error) {
+			return moonraker.PrinterInfo{State: "ready"}, nil
+		}),
+	})
+	paho := newFakePahoClient()
+	bridge := New(client, NewPahoBroker(paho), Options{Name: "voron", Objects: []string{"extruder"}, Notifications: []string{}})
+	assert.NoError(bridge.Start())
+	topic := "moonraker/voron/status/extruder"
+	payload, _ := paho.state(topic)
+	assert.JSONEq(`{"temperature": 25, "target": 0}`, payload)
+
+	// Hold every publish, as a broker that stopped acknowledging would.
+	release := make(chan struct{})
+	paho.mu.Lock()
+	paho.gate = release
+	paho.mu.Unlock()
+	for i, temp := range []float64{30, 35, 40} {
+		srv.Notify(context.Background(), "notify_status_update", []interface{}{
+			map[string]interface{}{"extruder": map[string]interface{}{"temperature": temp}}, float64(i + 2),
+		})
+	}
+	// An update sampled before the ones above must not overwrite them.
+	srv.Notify(context.Background(), "notify_status_update", []interface{}{
+		map[string]interface{}{"extruder": map[string]interface{}{"temperature": 20}}, 1.5,
+	})
+
+	info, err := client.Info()
+	assert.NoError(err, "client calls must not wait for the broker")
+	assert.Equal("ready", info.State)
+
+	close(release)
+	assert.Eventually(func() bool {
+		payload, _ := paho.state(topic)
+		return payload == `{"target":0,"temperature":40}`
+	}, time.Second, 5*time.Millisecond)
+	assert.NoError(bridge.Stop())
+	_, count := paho.state(topic)
+	assert.LessOrEqual(count, 3, "queued updates are coalesced")
+	payload, _ = paho.state(topic)
+	assert.Equal(`{"target":0,"temperature":40}`, payload)
+}
+
+func TestPahoBroker_PublishError(t *testing.T) {
+	paho := newFakePahoClient()
+	paho.err = mqtt.ErrNotConnected
+	assert.ErrorIs(t, NewPahoBroker(paho).Publish("a/b", 0, false, []byte("x")), mqtt.ErrNotConnected)
+}
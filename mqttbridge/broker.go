@@ -0,0 +1,150 @@
+package mqttbridge
+
+import (
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"strings"
+	"sync"
+)
+
+type MessageHandler func(topic string, payload []byte)
+
+// Broker is the part of an MQTT client the bridge relies on. NewPahoBroker
+// adapts a paho client, and MemoryBroker provides an embedded broker for
+// tests and single-process setups.
+type Broker interface {
+	Publish(topic string, qos byte, retained bool, payload []byte) error
+	Subscribe(filter string, qos byte, handler MessageHandler) error
+	Unsubscribe(filters ...string) error
+}
+
+type pahoBroker struct {
+	client mqtt.Client
+}
+
+// NewPahoBroker wraps a paho client. The client is expected to be connected
+// (or to auto-reconnect) for as long as the bridge runs.
+func NewPahoBroker(client mqtt.Client) Broker {
+	return &pahoBroker{client}
+}
+
+func (b *pahoBroker) Publish(topic string, qos byte, retained bool, payload []byte) error {
+	token := b.client.Publish(topic, qos, retained, payload)
+	token.Wait()
+	return token.Error()
+}
+
+func (b *pahoBroker) Subscribe(filter string, qos byte, handler MessageHandler) error {
+	token := b.client.Subscribe(filter, qos, func(_ mqtt.Client, msg mqtt.Message) {
+		handler(msg.Topic(), msg.Payload())
+	})
+	token.Wait()
+	return token.Error()
+}
+
+func (b *pahoBroker) Unsubscribe(filters ...string) error {
+	token := b.client.Unsubscribe(filters...)
+	token.Wait()
+	return token.Error()
+}
+
+type memorySubscription struct {
+	filter  string
+	handler MessageHandler
+}
+
+// MemoryBroker is an in-process broker with retained messages and MQTT
+// wildcard matching. Messages are delivered synchronously from Publish.
+type MemoryBroker struct {
+	mu       sync.Mutex
+	retained map[string][]byte
+	subs     []memorySubscription
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{retained: make(map[string][]byte)}
+}
+
+func (b *MemoryBroker) Publish(topic string, qos byte, retained bool, payload []byte) error {
+	payload = append([]byte(nil), payload...)
+	b.mu.Lock()
+	if retained {
+		// An empty retained message clears the retained state of a topic.
+		if len(payload) == 0 {
+			delete(b.retained, topic)
+		} else {
+			b.retained[topic] = payload
+		}
+	}
+	var handlers []MessageHandler
+	for _, sub := range b.subs {
+		if TopicMatches(sub.filter, topic) {
+			handlers = append(handlers, sub.handler)
+		}
+	}
+	b.mu.Unlock()
+	for _, handler := range handlers {
+		handler(topic, payload)
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(filter string, qos byte, handler MessageHandler) error {
+	b.mu.Lock()
+	b.subs = append(b.subs, memorySubscription{filter, handler})
+	retained := make(map[string][]byte)
+	for topic, payload := range b.retained {
+		if TopicMatches(filter, topic) {
+			retained[topic] = payload
+		}
+	}
+	b.mu.Unlock()
+	for topic, payload := range retained {
+		handler(topic, payload)
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Unsubscribe(filters ...string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	subs := b.subs[:0]
+	for _, sub := range b.subs {
+		if !containsString(filters, sub.filter) {
+			subs = append(subs, sub)
+		}
+	}
+	b.subs = subs
+	return nil
+}
+
+// Retained returns the retained payload of a topic, or nil if there is none.
+func (b *MemoryBroker) Retained(topic string) []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.retained[topic]
+}
+
+// TopicMatches reports whether topic matches an MQTT subscription filter,
+// where "+" matches a single level and a trailing "#" any number of levels.
+func TopicMatches(filter, topic string) bool {
+	f := strings.Split(filter, "/")
+	t := strings.Split(topic, "/")
+	for i, level := range f {
+		if level == "#" {
+			return i == len(f)-1
+		}
+		if i >= len(t) || (level != "+" && level != t[i]) {
+			return false
+		}
+	}
+	return len(f) == len(t)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
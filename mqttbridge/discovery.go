@@ -0,0 +1,135 @@
+package mqttbridge
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+type DiscoveryDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+}
+
+// DiscoveryConfig is a Home Assistant MQTT discovery payload for a sensor or
+// button entity.
+type DiscoveryConfig struct {
+	Name              string          `json:"name"`
+	UniqueId          string          `json:"unique_id"`
+	ObjectId          string          `json:"object_id"`
+	Device            DiscoveryDevice `json:"device"`
+	AvailabilityTopic string          `json:"availability_topic"`
+	StateTopic        string          `json:"state_topic,omitempty"`
+	ValueTemplate     string          `json:"value_template,omitempty"`
+	UnitOfMeasurement string          `json:"unit_of_measurement,omitempty"`
+	DeviceClass       string          `json:"device_class,omitempty"`
+	StateClass        string          `json:"state_class,omitempty"`
+	Icon              string          `json:"icon,omitempty"`
+	CommandTopic      string          `json:"command_topic,omitempty"`
+	PayloadPress      string          `json:"payload_press,omitempty"`
+}
+
+// DiscoveryEntity describes one Home Assistant entity and the discovery
+// topic its config is published to.
+type DiscoveryEntity struct {
+	Topic  string
+	Config DiscoveryConfig
+}
+
+type sensorDef struct {
+	id, name, object, template, unit, deviceClass, stateClass, icon string
+}
+
+var discoverySensors = []sensorDef{
+	{"extruder_temperature", "Extruder Temperature", "extruder", "{{ value_json.temperature | round(1) }}", "°C", "temperature", "measurement", ""},
+	{"extruder_target", "Extruder Target", "extruder", "{{ value_json.target | round(1) }}", "°C", "temperature", "measurement", ""},
+	{"bed_temperature", "Bed Temperature", "heater_bed", "{{ value_json.temperature | round(1) }}", "°C", "temperature", "measurement", ""},
+	{"bed_target", "Bed Target", "heater_bed", "{{ value_json.target | round(1) }}", "°C", "temperature", "measurement", ""},
+	{"print_progress", "Print Progress", "virtual_sdcard", "{{ (value_json.progress * 100) | round(1) }}", "%", "", "measurement", "mdi:progress-clock"},
+	{"print_state", "Print State", "print_stats", "{{ value_json.state }}", "", "", "", "mdi:printer-3d"},
+	{"print_filename", "Print Filename", "print_stats", "{{ value_json.filename }}", "", "", "", "mdi:file"},
+	{"print_duration", "Print Duration", "print_stats", "{{ value_json.print_duration | int }}", "s", "duration", "", ""},
+	{"filament_used", "Filament Used", "print_stats", "{{ value_json.filament_used | round(0) }}", "mm", "", "measurement", "mdi:printer-3d-nozzle"},
+	{"klippy_state", "Klippy State", "webhooks", "{{ value_json.state }}", "", "", "", "mdi:state-machine"},
+}
+
+var discoveryButtons = []struct{ command, name, icon string }{
+	{"pause", "Pause Print", "mdi:pause"},
+	{"resume", "Resume Print", "mdi:play"},
+	{"cancel", "Cancel Print", "mdi:stop"},
+	{"emergency_stop", "Emergency Stop", "mdi:alert-octagon"},
+	{"firmware_restart", "Firmware Restart", "mdi:restart"},
+}
+
+// nodeId turns the printer name into the character set Home Assistant
+// accepts in discovery topics and entity ids.
+func nodeId(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+// DiscoveryEntities lists the Home Assistant entities for the bridged
+// printer: sensors for the mirrored objects and buttons for print commands.
+func (b *Bridge) DiscoveryEntities() []DiscoveryEntity {
+	node := nodeId(b.opts.Name)
+	device := DiscoveryDevice{
+		Identifiers:  []string{"moonraker_" + node},
+		Name:         b.opts.Name,
+		Manufacturer: "Klipper",
+		Model:        "Moonraker",
+	}
+	entity := func(component, id string, config DiscoveryConfig) DiscoveryEntity {
+		config.UniqueId = node + "_" + id
+		config.ObjectId = node + "_" + id
+		config.Device = device
+		config.AvailabilityTopic = b.opts.AvailabilityTopic()
+		return DiscoveryEntity{b.opts.DiscoveryPrefix + "/" + component + "/" + node + "/" + id + "/config", config}
+	}
+
+	var entities []DiscoveryEntity
+	for _, s := range discoverySensors {
+		if !containsString(b.opts.Objects, s.object) {
+			continue
+		}
+		entities = append(entities, entity("sensor", s.id, DiscoveryConfig{
+			Name:              s.name,
+			StateTopic:        b.ObjectTopic(s.object),
+			ValueTemplate:     s.template,
+			UnitOfMeasurement: s.unit,
+			DeviceClass:       s.deviceClass,
+			StateClass:        s.stateClass,
+			Icon:              s.icon,
+		}))
+	}
+	for _, button := range discoveryButtons {
+		entities = append(entities, entity("button", button.command, DiscoveryConfig{
+			Name:         button.name,
+			CommandTopic: b.topic("command", button.command),
+			PayloadPress: "PRESS",
+			Icon:         button.icon,
+		}))
+	}
+	return entities
+}
+
+func (b *Bridge) publishDiscovery() error {
+	for _, entity := range b.DiscoveryEntities() {
+		payload, err := json.Marshal(entity.Config)
+		if err != nil {
+			return err
+		}
+		if err := b.broker.Publish(entity.Topic, b.opts.QoS, true, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package go_moonraker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/creachadair/jrpc2"
+	"github.com/creachadair/jrpc2/channel"
+	log "github.com/sirupsen/logrus"
+	"sync"
+)
+
+// dispatchNotification is the jrpc2 OnNotify hook. jrpc2 calls it while
+// holding the client's lock, so it only queues the notification for
+// deliverNotifications.
+func (c *MoonClient) dispatchNotification(req *jrpc2.Request) {
+	c.notifyMu.Lock()
+	if !c.notifyStopped {
+		c.notifyQueue = append(c.notifyQueue, req)
+		c.notifyCond.Signal()
+	}
+	c.notifyMu.Unlock()
+	c.notifyCh.queued()
+}
+
+// deliverNotifications runs the handlers for each queued notification in the
+// order Moonraker sent them, until the client is closed.
+func (c *MoonClient) deliverNotifications() {
+	for {
+		c.notifyMu.Lock()
+		for len(c.notifyQueue) == 0 && !c.notifyStopped {
+			c.notifyCond.Wait()
+		}
+		if c.notifyStopped {
+			c.notifyMu.Unlock()
+			return
+		}
+		req := c.notifyQueue[0]
+		c.notifyQueue[0] = nil
+		c.notifyQueue = c.notifyQueue[1:]
+		handlers := make([]func(*jrpc2.Request), 0, len(c.subscribers[req.Method()]))
+		for _, fn := range c.subscribers[req.Method()] {
+			handlers = append(handlers, fn)
+		}
+		c.notifyMu.Unlock()
+
+		for _, fn := range handlers {
+			fn(req)
+		}
+		if c.notifyHandler != nil {
+			c.notifyHandler(req)
+		}
+	}
+}
+
+func (c *MoonClient) stopNotifications() {
+	if c.notifyCond == nil {
+		return
+	}
+	c.notifyMu.Lock()
+	defer c.notifyMu.Unlock()
+	c.notifyStopped = true
+	c.notifyQueue = nil
+	c.notifyCond.Broadcast()
+}
+
+// OnNotification registers fn for every notification with the given method
+// name. All handlers, including the one passed to NewClient, run one at a time
+// on a single goroutine per client, in the order the notifications arrived.
+// Handlers may call the client, but a slow handler delays every notification
+// after it. The returned function removes the handler.
+func (c *MoonClient) OnNotification(method string, fn func(*jrpc2.Request)) func() {
+	c.notifyMu.Lock()
+	defer c.notifyMu.Unlock()
+	if c.subscribers == nil {
+		c.subscribers = make(map[string]map[int]func(*jrpc2.Request))
+	}
+	if c.subscribers[method] == nil {
+		c.subscribers[method] = make(map[int]func(*jrpc2.Request))
+	}
+	c.notifyID++
+	id := c.notifyID
+	c.subscribers[method][id] = fn
+	return func() {
+		c.notifyMu.Lock()
+		defer c.notifyMu.Unlock()
+		delete(c.subscribers[method], id)
+	}
+}
+
+// Moonraker sends notification params as a positional array, with the payload
+// in the first element.
+func UnmarshalNotification(req *jrpc2.Request, v interface{}) error {
+	var params []json.RawMessage
+	if err := req.UnmarshalParams(&params); err != nil {
+		return err
+	}
+	if len(params) == 0 {
+		return fmt.Errorf("notification %s has no params", req.Method())
+	}
+	return json.Unmarshal(params[0], v)
+}
+
+func onTypedNotification[T any](c *MoonClient, method string, fn func(*T)) func() {
+	return c.OnNotification(method, func(req *jrpc2.Request) {
+		var event T
+		if err := UnmarshalNotification(req, &event); err != nil {
+			log.WithError(err).Errorf("decoding %s", method)
+			return
+		}
+		fn(&event)
+	})
+}
+
+// notifyChannel hands jrpc2 one message per Recv, and after a notification it
+// waits for dispatchNotification to queue it before reading on. jrpc2 delivers
+// each received message from its own goroutine, so without this notifications
+// could reach the queue out of order.
+type notifyChannel struct {
+	channel.Channel
+	pending  []json.RawMessage
+	wait     bool
+	signal   chan struct{}
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+func newNotifyChannel(ch channel.Channel) *notifyChannel {
+	return &notifyChannel{Channel: ch, signal: make(chan struct{}, 1), done: make(chan struct{})}
+}
+
+func (ch *notifyChannel) Recv() ([]byte, error) {
+	if ch.wait {
+		ch.wait = false
+		select {
+		case <-ch.signal:
+		case <-ch.done:
+		}
+	}
+	if len(ch.pending) == 0 {
+		bits, err := ch.Channel.Recv()
+		if err != nil {
+			return nil, err
+		}
+		ch.pending = splitBatch(bits)
+	}
+	msg := ch.pending[0]
+	ch.pending = ch.pending[1:]
+	ch.wait = isNotification(msg)
+	return msg, nil
+}
+
+func (ch *notifyChannel) Close() error {
+	ch.doneOnce.Do(func() { close(ch.done) })
+	return ch.Channel.Close()
+}
+
+func (ch *notifyChannel) queued() {
+	select {
+	case ch.signal <- struct{}{}:
+	default:
+	}
+}
+
+// splitBatch returns the messages of a batch, or bits itself when it is a
+// single message or can't be split.
+func splitBatch(bits []byte) []json.RawMessage {
+	trimmed := bytes.TrimSpace(bits)
+	if len(trimmed) == 0 || trimmed[0] != '[' {
+		return []json.RawMessage{bits}
+	}
+	var msgs []json.RawMessage
+	if err := json.Unmarshal(trimmed, &msgs); err != nil || len(msgs) == 0 {
+		return []json.RawMessage{bits}
+	}
+	return msgs
+}
+
+// isNotification matches jrpc2's notion of a notification: a method, no
+// result or error, and no id.
+func isNotification(msg []byte) bool {
+	var m struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+		Result json.RawMessage `json:"result"`
+		Error  json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(msg, &m); err != nil {
+		return false
+	}
+	id := string(bytes.TrimSpace(m.ID))
+	return m.Method != "" && m.Result == nil && m.Error == nil && (id == "" || id == "null")
+}
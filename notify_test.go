@@ -0,0 +1,185 @@
+package go_moonraker
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/creachadair/jrpc2"
+	"github.com/creachadair/jrpc2/channel"
+	"github.com/creachadair/jrpc2/handler"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, methods handler.Map) (*MoonClient, *jrpc2.Server) {
+	cch, sch := channel.Direct()
+	srv := jrpc2.NewServer(methods, &jrpc2.ServerOptions{AllowPush: true}).Start(sch)
+	c := NewClientFromChannel(cch, "printer.local", nil)
+	t.Cleanup(func() {
+		c.Close()
+		srv.Wait()
+	})
+	return c, srv
+}
+
+func TestMoonClient_OnNotification(t *testing.T) {
+	assert := assert.New(t)
+	fallback := make(chan string, 4)
+	cch, sch := channel.Direct()
+	srv := jrpc2.NewServer(handler.Map{}, &jrpc2.ServerOptions{AllowPush: true}).Start(sch)
+	c := NewClientFromChannel(cch, "", func(req *jrpc2.Request) { fallback <- req.Method() })
+	defer srv.Wait()
+	defer c.Close()
+
+	got := make(chan string, 4)
+	cancel := c.OnNotification("notify_klippy_ready", func(req *jrpc2.Request) { got <- req.Method() })
+	assert.NoError(srv.Notify(context.Background(), "notify_klippy_ready", nil))
+	assert.Equal("notify_klippy_ready", <-got)
+
+	cancel()
+	assert.NoError(srv.Notify(context.Background(), "notify_klippy_ready", nil))
+	assert.NoError(srv.Notify(context.Background(), "notify_klippy_shutdown", nil))
+	for _, method := range []string{"notify_klippy_ready", "notify_klippy_ready", "notify_klippy_shutdown"} {
+		select {
+		case m := <-fallback:
+			assert.Equal(method, m)
+		case <-time.After(time.Second):
+			t.Fatal("notification not passed to fallback handler")
+		}
+	}
+	assert.Empty(got)
+}
+
+func TestMoonClient_OnNotificationOrder(t *testing.T) {
+	assert := assert.New(t)
+	c, srv := newTestClient(t, handler.Map{
+		"printer.info": handler.New(func(ctx context.Context) (PrinterInfo, error) {
+			return PrinterInfo{State: "ready"}, nil
+		}),
+	})
+
+	got := make(chan int, 100)
+	c.OnNotification("notify_status_update", func(req *jrpc2.Request) {
+		var n []int
+		assert.NoError(req.UnmarshalParams(&n))
+		// Handlers run off the connection's lock, so they may call the client.
+		_, err := c.Info()
+		assert.NoError(err)
+		got <- n[0]
+	})
+	for i := 0; i < 100; i++ {
+		assert.NoError(srv.Notify(context.Background(), "notify_status_update", []int{i}))
+	}
+	for i := 0; i < 100; i++ {
+		select {
+		case n := <-got:
+			assert.Equal(i, n)
+		case <-time.After(time.Second):
+			t.Fatalf("notification %d not delivered", i)
+		}
+	}
+}
+
+func TestMoonClient_JumpJobQueue(t *testing.T) {
+	assert := assert.New(t)
+	c, _ := newTestClient(t, handler.Map{
+		"server.job_queue.jump": handler.New(func(ctx context.Context, params map[string]string) (JobQueueItems, error) {
+			return JobQueueItems{
+				QueuedJobs: []JobQueueItem{{Filename: "b.gcode", JobId: params["job_id"]}},
+				QueueState: QueuePaused,
+			}, nil
+		}),
+	})
+	queue, err := c.JumpJobQueue("0002")
+	assert.NoError(err)
+	assert.Equal(QueuePaused, queue.QueueState)
+	assert.Equal("0002", queue.QueuedJobs[0].JobId)
+}
+
+func TestMoonClient_ClearJobQueue(t *testing.T) {
+	assert := assert.New(t)
+	var sent DeleteQueueJobsParams
+	c, _ := newTestClient(t, handler.Map{
+		"server.job_queue.delete_job": handler.New(func(ctx context.Context, params DeleteQueueJobsParams) (JobQueueItems, error) {
+			sent = params
+			return JobQueueItems{QueueState: QueueReady}, nil
+		}),
+	})
+	_, err := c.ClearJobQueue()
+	assert.NoError(err)
+	assert.True(sent.All)
+	assert.Empty(sent.JobIds)
+}
+
+func TestMoonClient_OnJobQueueChanged(t *testing.T) {
+	assert := assert.New(t)
+	c, srv := newTestClient(t, handler.Map{})
+	events := make(chan *JobQueueEvent, 1)
+	c.OnJobQueueChanged(func(event *JobQueueEvent) { events <- event })
+	assert.NoError(srv.Notify(context.Background(), "notify_job_queue_changed", []interface{}{
+		map[string]interface{}{
+			"action":        "jobs_added",
+			"updated_queue": []map[string]interface{}{{"filename": "a.gcode", "job_id": "0001"}},
+			"queue_state":   "ready",
+		},
+	}))
+	select {
+	case event := <-events:
+		assert.Equal("jobs_added", event.Action)
+		assert.Equal(QueueReady, event.QueueState)
+		assert.Equal("a.gcode", event.UpdatedQueue[0].Filename)
+	case <-time.After(time.Second):
+		t.Fatal("no queue event delivered")
+	}
+}
+
+func TestMoonClient_OnFileListChanged(t *testing.T) {
+	assert := assert.New(t)
+	c, srv := newTestClient(t, handler.Map{})
+	events := make(chan *FileListChange, 1)
+	c.OnFileListChanged(func(event *FileListChange) { events <- event })
+	assert.NoError(srv.Notify(context.Background(), "notify_filelist_changed", []interface{}{
+		map[string]interface{}{
+			"action":      "move_file",
+			"item":        map[string]interface{}{"root": "gcodes", "path": "voron/cube.gcode", "size": 4500, "modified": 1676389434.5, "permissions": "rw"},
+			"source_item": map[string]interface{}{"root": "gcodes", "path": "cube.gcode"},
+		},
+	}))
+	select {
+	case event := <-events:
+		assert.Equal(FileMoved, event.Action)
+		assert.Equal(FileListItem{Root: "gcodes", Path: "voron/cube.gcode", Size: 4500, Modified: 1676389434.5, Permissions: "rw"}, event.Item)
+		if assert.NotNil(event.SourceItem) {
+			assert.Equal("cube.gcode", event.SourceItem.Path)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no file list event delivered")
+	}
+}
+
+func TestMoonClient_Metascan(t *testing.T) {
+	assert := assert.New(t)
+	c, srv := newTestClient(t, handler.Map{
+		"server.files.metascan": handler.New(func(ctx context.Context, params map[string]string) (json.RawMessage, error) {
+			return json.RawMessage(`{"filename": "` + params["filename"] + `", "slicer": "PrusaSlicer", "estimated_time": 1234.5}`), nil
+		}),
+	})
+	updates := make(chan *GcodeMetadata, 1)
+	c.OnMetadataUpdate(func(meta *GcodeMetadata) { updates <- meta })
+
+	meta, err := c.MetascanFile("cube.gcode")
+	assert.NoError(err)
+	assert.Equal("cube.gcode", meta.Filename)
+	assert.Equal(1234.5, meta.EstimatedTime)
+
+	assert.NoError(srv.Notify(context.Background(), "notify_metadata_update", []interface{}{
+		map[string]interface{}{"filename": "cube.gcode", "size": 2048, "slicer": "PrusaSlicer"},
+	}))
+	select {
+	case meta := <-updates:
+		assert.Equal("cube.gcode", meta.Filename)
+		assert.Equal(2048, meta.Size)
+	case <-time.After(time.Second):
+		t.Fatal("no metadata update delivered")
+	}
+}
@@ -0,0 +1,151 @@
+package octoprint
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+const maxUploadMemory = 32 << 20
+
+type FileRefs struct {
+	Resource string `json:"resource"`
+	Download string `json:"download"`
+}
+
+type UploadedFile struct {
+	Name   string   `json:"name"`
+	Path   string   `json:"path"`
+	Origin string   `json:"origin"`
+	Refs   FileRefs `json:"refs"`
+}
+
+type UploadResponse struct {
+	Files map[string]UploadedFile `json:"files"`
+	Done  bool                    `json:"done"`
+}
+
+func isTrue(v string) bool {
+	switch strings.ToLower(v) {
+	case "true", "yes", "1":
+		return true
+	}
+	return false
+}
+
+// requestScheme is the scheme the client used to reach the server, so links
+// stay valid behind a TLS terminating proxy.
+func requestScheme(r *http.Request) string {
+	if proto := strings.ToLower(strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-Proto"), ",")[0])); proto == "http" || proto == "https" {
+		return proto
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// cleanFilePath turns a path from a request into one relative to the gcodes
+// root, or returns "" if it doesn't name a file.
+func cleanFilePath(p string) string {
+	name := path.Clean("/" + p)[1:]
+	if strings.HasSuffix(name, "/") {
+		return ""
+	}
+	return name
+}
+
+// upload forwards an OctoPrint file upload to Moonraker's gcodes root. The
+// "print" field starts the print once the upload is stored, and both it and
+// "select" make the file the one the start command prints.
+func (s *Server) upload(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(maxUploadMemory); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid upload: %w", err))
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+	f, header, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("no file included"))
+		return
+	}
+	defer f.Close()
+
+	name := cleanFilePath(path.Join(r.FormValue("path"), path.Base(header.Filename)))
+	if name == "" {
+		writeError(w, http.StatusBadRequest, errors.New("invalid file name"))
+		return
+	}
+	start := isTrue(r.FormValue("print"))
+	if err := s.client.UploadFile(name, f, fmt.Sprint(start)); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if start || isTrue(r.FormValue("select")) {
+		s.selectFile(name)
+	}
+
+	scheme := requestScheme(r)
+	download := url.URL{Scheme: scheme, Host: r.Host, Path: "/downloads/files/local/" + name}
+	resource := url.URL{Scheme: scheme, Host: r.Host, Path: "/api/files/local/" + name}
+	w.Header().Set("Location", resource.String())
+	writeJSON(w, http.StatusCreated, UploadResponse{
+		Files: map[string]UploadedFile{
+			"local": {
+				Name:   path.Base(name),
+				Path:   name,
+				Origin: "local",
+				Refs:   FileRefs{Resource: resource.String(), Download: download.String()},
+			},
+		},
+		Done: true,
+	})
+}
+
+// fileCommand handles commands on a stored file. Only select is supported,
+// which makes the file the one the start command prints and, with "print"
+// set, starts it straight away.
+func (s *Server) fileCommand(w http.ResponseWriter, r *http.Request) {
+	cmd, ok := decodeCommand(w, r)
+	if !ok {
+		return
+	}
+	if cmd.Command != "select" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown command: %s", cmd.Command))
+		return
+	}
+	name := cleanFilePath(strings.TrimPrefix(r.URL.Path, "/api/files/local/"))
+	if name == "" {
+		writeError(w, http.StatusBadRequest, errors.New("invalid file name"))
+		return
+	}
+	objects, err := s.client.QueryPrinterObjects("webhooks", "print_stats")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	state := printerState(objects)
+	if state.Flags.Printing || state.Flags.Paused {
+		writeError(w, http.StatusConflict, errors.New("printer is already printing"))
+		return
+	}
+	if cmd.Print && !state.Flags.Operational {
+		writeError(w, http.StatusConflict, errors.New("printer is not operational"))
+		return
+	}
+	if _, err := s.client.GcodeMetadata(name); err != nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("file not found: %s", name))
+		return
+	}
+	s.selectFile(name)
+	if cmd.Print {
+		if err := s.client.Print(name); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
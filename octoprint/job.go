@@ -0,0 +1,277 @@
+package octoprint
+
+import (
+	"errors"
+	"fmt"
+	moonraker "github.com/derek-elliott/go-moonraker"
+	"net/http"
+	"strings"
+)
+
+var statusObjects = []string{"webhooks", "print_stats", "virtual_sdcard", "extruder", "heater_bed"}
+
+type StateFlags struct {
+	Operational   bool `json:"operational"`
+	Paused        bool `json:"paused"`
+	Printing      bool `json:"printing"`
+	Pausing       bool `json:"pausing"`
+	Cancelling    bool `json:"cancelling"`
+	SDReady       bool `json:"sdReady"`
+	Error         bool `json:"error"`
+	Ready         bool `json:"ready"`
+	ClosedOrError bool `json:"closedOrError"`
+}
+
+type PrinterState struct {
+	Text  string     `json:"text"`
+	Flags StateFlags `json:"flags"`
+}
+
+// printerState translates Klippy's and the print's state into OctoPrint's.
+func printerState(objects *moonraker.PrinterObjects) PrinterState {
+	klippy, job := "", ""
+	if objects.Webhooks != nil {
+		klippy = objects.Webhooks.State
+	}
+	if objects.PrintStats != nil {
+		job = objects.PrintStats.State
+	}
+	switch {
+	case klippy == "startup":
+		return PrinterState{Text: "Connecting", Flags: StateFlags{}}
+	case klippy != "ready":
+		return PrinterState{Text: "Error", Flags: StateFlags{Error: true, ClosedOrError: true}}
+	case job == "printing":
+		return PrinterState{Text: "Printing", Flags: StateFlags{Operational: true, Printing: true}}
+	case job == "paused":
+		return PrinterState{Text: "Paused", Flags: StateFlags{Operational: true, Paused: true}}
+	case job == "error":
+		return PrinterState{Text: "Operational", Flags: StateFlags{Operational: true, Ready: true, Error: true}}
+	default:
+		return PrinterState{Text: "Operational", Flags: StateFlags{Operational: true, Ready: true}}
+	}
+}
+
+type Temperature struct {
+	Actual float32 `json:"actual"`
+	Target float32 `json:"target"`
+	Offset float32 `json:"offset"`
+}
+
+type SDState struct {
+	Ready bool `json:"ready"`
+}
+
+type Printer struct {
+	Temperature map[string]Temperature `json:"temperature,omitempty"`
+	SD          *SDState               `json:"sd,omitempty"`
+	State       *PrinterState          `json:"state,omitempty"`
+}
+
+func (s *Server) printer(w http.ResponseWriter, r *http.Request) {
+	objects, err := s.client.QueryPrinterObjects(statusObjects...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	state := printerState(objects)
+	if !state.Flags.Operational {
+		// OctoPrint answers 409 while the printer isn't connected.
+		writeError(w, http.StatusConflict, errors.New("printer is not operational"))
+		return
+	}
+	exclude := make(map[string]bool)
+	for _, field := range strings.Split(r.URL.Query().Get("exclude"), ",") {
+		exclude[strings.TrimSpace(field)] = true
+	}
+
+	var resp Printer
+	if !exclude["temperature"] {
+		resp.Temperature = make(map[string]Temperature)
+		if h := objects.Extruder; h != nil {
+			resp.Temperature["tool0"] = Temperature{Actual: h.Temperature, Target: h.Target}
+		}
+		if h := objects.HeaterBed; h != nil {
+			resp.Temperature["bed"] = Temperature{Actual: h.Temperature, Target: h.Target}
+		}
+	}
+	if !exclude["sd"] {
+		resp.SD = &SDState{}
+	}
+	if !exclude["state"] {
+		resp.State = &state
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+type JobFile struct {
+	Name   *string `json:"name"`
+	Path   *string `json:"path"`
+	Origin *string `json:"origin"`
+	Size   *int    `json:"size"`
+	Date   *int64  `json:"date"`
+}
+
+type Filament struct {
+	Length float32 `json:"length"`
+	Volume float32 `json:"volume"`
+}
+
+type JobInfo struct {
+	File               JobFile             `json:"file"`
+	EstimatedPrintTime *float64            `json:"estimatedPrintTime"`
+	Filament           map[string]Filament `json:"filament"`
+	User               *string             `json:"user"`
+}
+
+type Progress struct {
+	Completion          *float64 `json:"completion"`
+	Filepos             *int     `json:"filepos"`
+	PrintTime           *float64 `json:"printTime"`
+	PrintTimeLeft       *float64 `json:"printTimeLeft"`
+	PrintTimeLeftOrigin *string  `json:"printTimeLeftOrigin"`
+}
+
+type Job struct {
+	Job      JobInfo  `json:"job"`
+	Progress Progress `json:"progress"`
+	State    string   `json:"state"`
+}
+
+func (s *Server) job(w http.ResponseWriter, r *http.Request) {
+	objects, err := s.client.QueryPrinterObjects(statusObjects...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	resp := Job{State: printerState(objects).Text}
+	// The job is the file being printed, otherwise the selected file, and
+	// failing that the last one printed.
+	stats := objects.PrintStats
+	name := s.selectedFile()
+	if stats != nil && (name == "" || stats.State == "printing" || stats.State == "paused") {
+		name = stats.Filename
+	}
+	if name == "" {
+		// OctoPrint reports every job field as null without a selected file.
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	path, origin := name, "local"
+	resp.Job.File = JobFile{Name: &path, Path: &path, Origin: &origin}
+	// Progress belongs to the file Klippy last printed, not a newly selected
+	// one.
+	onPrinter := stats != nil && stats.Filename == name
+	var printTime, completion float64
+	if onPrinter {
+		printTime = float64(stats.PrintDuration)
+		resp.Progress.PrintTime = &printTime
+		if sd := objects.VirtualSdcard; sd != nil {
+			completion = float64(sd.Progress)
+			filepos := sd.FilePosition
+			resp.Progress.Filepos = &filepos
+		}
+		percent := completion * 100
+		resp.Progress.Completion = &percent
+	}
+
+	// Metadata is best effort; files uploaded outside the gcodes root or
+	// still being scanned have none.
+	if meta, err := s.client.GcodeMetadata(name); err == nil {
+		size, date := meta.Size, int64(meta.Modified)
+		resp.Job.File.Size, resp.Job.File.Date = &size, &date
+		if meta.FilamentTotal > 0 {
+			resp.Job.Filament = map[string]Filament{"tool0": {Length: meta.FilamentTotal}}
+		}
+		if meta.EstimatedTime > 0 {
+			estimate := meta.EstimatedTime
+			resp.Job.EstimatedPrintTime = &estimate
+			if onPrinter {
+				left, origin := estimate-printTime, "estimate"
+				if left < 0 {
+					left = 0
+				}
+				resp.Progress.PrintTimeLeft, resp.Progress.PrintTimeLeftOrigin = &left, &origin
+			}
+		}
+	}
+	if onPrinter && resp.Progress.PrintTimeLeft == nil && completion > 0 {
+		left, origin := printTime/completion-printTime, "linear"
+		resp.Progress.PrintTimeLeft, resp.Progress.PrintTimeLeftOrigin = &left, &origin
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) jobCommand(w http.ResponseWriter, r *http.Request) {
+	cmd, ok := decodeCommand(w, r)
+	if !ok {
+		return
+	}
+	objects, err := s.client.QueryPrinterObjects("webhooks", "print_stats")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	state := printerState(objects)
+	if !state.Flags.Operational {
+		writeError(w, http.StatusConflict, errors.New("printer is not operational"))
+		return
+	}
+
+	switch cmd.Command {
+	case "start":
+		if state.Flags.Printing || state.Flags.Paused {
+			writeError(w, http.StatusConflict, errors.New("printer is already printing"))
+			return
+		}
+		// Without a selected file, the last print is started again.
+		name := s.selectedFile()
+		if name == "" && objects.PrintStats != nil {
+			name = objects.PrintStats.Filename
+		}
+		if name == "" {
+			writeError(w, http.StatusConflict, errors.New("no file selected"))
+			return
+		}
+		err = s.client.Print(name)
+	case "cancel":
+		if !state.Flags.Printing && !state.Flags.Paused {
+			writeError(w, http.StatusConflict, errors.New("no active job"))
+			return
+		}
+		err = s.client.CancelPrint()
+	case "pause":
+		if !state.Flags.Printing && !state.Flags.Paused {
+			writeError(w, http.StatusConflict, errors.New("no active job"))
+			return
+		}
+		switch cmd.Action {
+		case "pause":
+			if state.Flags.Printing {
+				err = s.client.PausePrint()
+			}
+		case "resume":
+			if state.Flags.Paused {
+				err = s.client.ResumePrint()
+			}
+		case "toggle", "":
+			if state.Flags.Printing {
+				err = s.client.PausePrint()
+			} else {
+				err = s.client.ResumePrint()
+			}
+		default:
+			writeError(w, http.StatusBadRequest, fmt.Errorf("unknown pause action: %s", cmd.Action))
+			return
+		}
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown command: %s", cmd.Command))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
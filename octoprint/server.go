@@ -0,0 +1,236 @@
+// Package octoprint serves the core of the OctoPrint REST API on top of a
+// Moonraker connection, so slicers and apps that only speak OctoPrint can
+// upload and control prints on a Klipper printer.
+//
+// Supported endpoints:
+//
+//	GET  /api/version
+//	GET  /api/connection
+//	POST /api/connection   {"command": "connect" | "disconnect"}
+//	GET  /api/printer      ?exclude=temperature,sd,state
+//	GET  /api/job
+//	POST /api/job          {"command": "start" | "cancel" | "pause", "action": "pause" | "resume" | "toggle"}
+//	POST /api/files/local         multipart upload with "file", "path", "select" and "print" fields
+//	POST /api/files/local/{path}  {"command": "select", "print": false}
+package octoprint
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	moonraker "github.com/derek-elliott/go-moonraker"
+	"net/http"
+	"sync"
+)
+
+// DefaultServerVersion is the OctoPrint version reported to clients. Some
+// clients refuse to talk to versions they don't know, so it is a real one.
+const DefaultServerVersion = "1.5.0"
+
+const apiVersion = "0.1"
+
+type Options struct {
+	// APIKey, when set, must be sent in the X-Api-Key header or the apikey
+	// query parameter of every request.
+	APIKey string
+	// ServerVersion is the OctoPrint version reported by /api/version,
+	// DefaultServerVersion by default.
+	ServerVersion string
+	// Printer profile and port names reported by /api/connection.
+	ProfileName string
+	Port        string
+}
+
+type Server struct {
+	client *moonraker.MoonClient
+	opts   Options
+	mux    *http.ServeMux
+
+	mu sync.Mutex
+	// selected is the file picked by an upload or a select command, which
+	// the start command prints. Moonraker has no notion of a selected file.
+	selected string
+}
+
+func New(client *moonraker.MoonClient, opts Options) *Server {
+	if opts.ServerVersion == "" {
+		opts.ServerVersion = DefaultServerVersion
+	}
+	if opts.ProfileName == "" {
+		opts.ProfileName = "Klipper"
+	}
+	if opts.Port == "" {
+		opts.Port = "moonraker"
+	}
+	s := &Server{client: client, opts: opts, mux: http.NewServeMux()}
+	s.handle("/api/version", map[string]http.HandlerFunc{"GET": s.version})
+	s.handle("/api/connection", map[string]http.HandlerFunc{"GET": s.connection, "POST": s.connectionCommand})
+	s.handle("/api/printer", map[string]http.HandlerFunc{"GET": s.printer})
+	s.handle("/api/job", map[string]http.HandlerFunc{"GET": s.job, "POST": s.jobCommand})
+	s.handle("/api/files/local", map[string]http.HandlerFunc{"POST": s.upload})
+	s.handle("/api/files/local/", map[string]http.HandlerFunc{"POST": s.fileCommand})
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handle(path string, methods map[string]http.HandlerFunc) {
+	s.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if !s.authorized(r) {
+			writeError(w, http.StatusForbidden, errors.New("invalid API key"))
+			return
+		}
+		h, ok := methods[r.Method]
+		if !ok {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+			return
+		}
+		h(w, r)
+	})
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	if s.opts.APIKey == "" {
+		return true
+	}
+	key := r.Header.Get("X-Api-Key")
+	if key == "" {
+		key = r.URL.Query().Get("apikey")
+	}
+	return subtle.ConstantTimeCompare([]byte(key), []byte(s.opts.APIKey)) == 1
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// command is the body of OctoPrint's POST command endpoints.
+type command struct {
+	Command string `json:"command"`
+	Action  string `json:"action"`
+	Print   bool   `json:"print"`
+}
+
+func decodeCommand(w http.ResponseWriter, r *http.Request) (command, bool) {
+	var cmd command
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&cmd); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return cmd, false
+	}
+	return cmd, true
+}
+
+func (s *Server) selectFile(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.selected = name
+}
+
+func (s *Server) selectedFile() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.selected
+}
+
+type Version struct {
+	API    string `json:"api"`
+	Server string `json:"server"`
+	Text   string `json:"text"`
+}
+
+func (s *Server) version(w http.ResponseWriter, r *http.Request) {
+	text := "OctoPrint " + s.opts.ServerVersion
+	if info, err := s.client.QueryServerInfo(); err == nil && info.MoonrakerVersion != "" {
+		text += " (Moonraker " + info.MoonrakerVersion + ")"
+	}
+	writeJSON(w, http.StatusOK, Version{API: apiVersion, Server: s.opts.ServerVersion, Text: text})
+}
+
+type ConnectionState struct {
+	State          string `json:"state"`
+	Port           string `json:"port"`
+	Baudrate       int    `json:"baudrate"`
+	PrinterProfile string `json:"printerProfile"`
+}
+
+type PrinterProfile struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type ConnectionOptions struct {
+	Ports                    []string         `json:"ports"`
+	Baudrates                []int            `json:"baudrates"`
+	PrinterProfiles          []PrinterProfile `json:"printerProfiles"`
+	PortPreference           string           `json:"portPreference"`
+	BaudratePreference       int              `json:"baudratePreference"`
+	PrinterProfilePreference string           `json:"printerProfilePreference"`
+	Autoconnect              bool             `json:"autoconnect"`
+}
+
+type Connection struct {
+	Current ConnectionState   `json:"current"`
+	Options ConnectionOptions `json:"options"`
+}
+
+const profileID = "_default"
+
+func (s *Server) connection(w http.ResponseWriter, r *http.Request) {
+	state := "Closed"
+	if info, err := s.client.QueryServerInfo(); err == nil && info.KlippyConnected {
+		state = "Operational"
+		if info.KlippyState != "ready" {
+			state = "Error"
+		}
+	}
+	writeJSON(w, http.StatusOK, Connection{
+		Current: ConnectionState{State: state, Port: s.opts.Port, Baudrate: 250000, PrinterProfile: profileID},
+		Options: ConnectionOptions{
+			Ports:                    []string{s.opts.Port},
+			Baudrates:                []int{250000},
+			PrinterProfiles:          []PrinterProfile{{ID: profileID, Name: s.opts.ProfileName}},
+			PortPreference:           s.opts.Port,
+			BaudratePreference:       250000,
+			PrinterProfilePreference: profileID,
+			Autoconnect:              true,
+		},
+	})
+}
+
+// connectionCommand maps OctoPrint's serial connection onto Klippy. The
+// connection is always up, so connecting only restarts the firmware when
+// Klippy is in an error state, and disconnecting does nothing.
+func (s *Server) connectionCommand(w http.ResponseWriter, r *http.Request) {
+	cmd, ok := decodeCommand(w, r)
+	if !ok {
+		return
+	}
+	switch cmd.Command {
+	case "connect":
+		info, err := s.client.QueryServerInfo()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if info.KlippyState == "shutdown" || info.KlippyState == "error" {
+			if err := s.client.FirmwareRestart(); err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+		}
+	case "disconnect", "fake_ack":
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown command: %s", cmd.Command))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,244 @@
+package octoprint
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/creachadair/jrpc2"
+	"github.com/creachadair/jrpc2/channel"
+	"github.com/creachadair/jrpc2/handler"
+	moonraker "github.com/derek-elliott/go-moonraker"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type fakeMoonraker struct {
+	klippy string
+	state  string
+	calls  []string
+	upload struct {
+		filename, print, content string
+	}
+}
+
+func (f *fakeMoonraker) record(call string) func(context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		f.calls = append(f.calls, call)
+		return "ok", nil
+	}
+}
+
+func (f *fakeMoonraker) server(t *testing.T, opts Options) *Server {
+	uploads := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, header, err := r.FormFile("file")
+		if !assert.NoError(t, err) {
+			return
+		}
+		content, _ := io.ReadAll(file)
+		f.upload.filename, f.upload.print, f.upload.content = header.Filename, r.FormValue("print"), string(content)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	t.Cleanup(uploads.Close)
+
+	cch, sch := channel.Direct()
+	srv := jrpc2.NewServer(handler.Map{
+		"server.info": handler.New(func(ctx context.Context) (moonraker.ServerInfo, error) {
+			return moonraker.ServerInfo{KlippyConnected: true, KlippyState: f.klippy, MoonrakerVersion: "v0.8.0-100"}, nil
+		}),
+		"printer.objects.query": handler.New(func(ctx context.Context, params moonraker.QueryObjectParams) (json.RawMessage, error) {
+			filename := ""
+			if f.state != "standby" {
+				filename = "benchy.gcode"
+			}
+			return json.RawMessage(`{"eventtime": 1, "status": {
+				"webhooks": {"state": "` + f.klippy + `"},
+				"print_stats": {"state": "` + f.state + `", "filename": "` + filename + `", "print_duration": 600},
+				"virtual_sdcard": {"progress": 0.25, "file_position": 4096},
+				"extruder": {"temperature": 214.5, "target": 215},
+				"heater_bed": {"temperature": 60, "target": 60}
+			}}`), nil
+		}),
+		"server.files.metadata": handler.New(func(ctx context.Context, params map[string]string) (json.RawMessage, error) {
+			if params["filename"] == "missing.gcode" {
+				return nil, jrpc2.Errorf(404, "Metadata not available for <missing.gcode>")
+			}
+			return json.RawMessage(`{"filename": "benchy.gcode", "size": 16384, "modified": 1700000000.5, "estimated_time": 3000, "filament_total": 1234.5}`), nil
+		}),
+		"printer.print.start": handler.New(func(ctx context.Context, params map[string]string) (string, error) {
+			f.calls = append(f.calls, "start "+params["filename"])
+			return "ok", nil
+		}),
+		"printer.print.pause":      handler.New(f.record("pause")),
+		"printer.print.resume":     handler.New(f.record("resume")),
+		"printer.print.cancel":     handler.New(f.record("cancel")),
+		"printer.firmware_restart": handler.New(f.record("firmware_restart")),
+	}, nil).Start(sch)
+	client := moonraker.NewClientFromChannel(cch, strings.TrimPrefix(uploads.URL, "http://"), nil)
+	t.Cleanup(func() {
+		client.Close()
+		srv.Stop()
+		srv.Wait()
+	})
+	return New(client, opts)
+}
+
+func do(s http.Handler, method, target, body string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestServer_VersionAndAuth(t *testing.T) {
+	assert := assert.New(t)
+	f := &fakeMoonraker{klippy: "ready", state: "standby"}
+	s := f.server(t, Options{APIKey: "secret"})
+
+	assert.Equal(http.StatusForbidden, do(s, "GET", "/api/version", "").Code)
+	assert.Equal(http.StatusForbidden, do(s, "GET", "/api/version", "", "X-Api-Key", "wrong").Code)
+	rec := do(s, "GET", "/api/version?apikey=secret", "")
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.JSONEq(`{"api": "0.1", "server": "1.5.0", "text": "OctoPrint 1.5.0 (Moonraker v0.8.0-100)"}`, rec.Body.String())
+
+	assert.Equal(http.StatusMethodNotAllowed, do(s, "DELETE", "/api/version", "", "X-Api-Key", "secret").Code)
+	assert.Equal(http.StatusNotFound, do(s, "GET", "/api/settings", "", "X-Api-Key", "secret").Code)
+}
+
+func TestServer_PrinterAndConnection(t *testing.T) {
+	assert := assert.New(t)
+	f := &fakeMoonraker{klippy: "ready", state: "printing"}
+	s := f.server(t, Options{})
+
+	rec := do(s, "GET", "/api/printer", "")
+	assert.Equal(http.StatusOK, rec.Code)
+	var printer Printer
+	assert.NoError(json.Unmarshal(rec.Body.Bytes(), &printer))
+	assert.Equal(Temperature{Actual: 214.5, Target: 215}, printer.Temperature["tool0"])
+	assert.Equal(Temperature{Actual: 60, Target: 60}, printer.Temperature["bed"])
+	assert.Equal("Printing", printer.State.Text)
+	assert.True(printer.State.Flags.Printing)
+
+	rec = do(s, "GET", "/api/printer?exclude=temperature,sd", "")
+	assert.NotContains(rec.Body.String(), "temperature")
+	assert.NotContains(rec.Body.String(), `"sd"`)
+
+	rec = do(s, "GET", "/api/connection", "")
+	assert.Contains(rec.Body.String(), `"current":{"state":"Operational"`)
+
+	f.klippy = "shutdown"
+	assert.Equal(http.StatusConflict, do(s, "GET", "/api/printer", "").Code)
+	rec = do(s, "GET", "/api/connection", "")
+	assert.Contains(rec.Body.String(), `"current":{"state":"Error"`)
+	assert.Equal(http.StatusNoContent, do(s, "POST", "/api/connection", `{"command": "connect"}`).Code)
+	assert.Equal(http.StatusNoContent, do(s, "POST", "/api/connection", `{"command": "disconnect"}`).Code)
+	assert.Equal(http.StatusBadRequest, do(s, "POST", "/api/connection", `{"command": "reboot"}`).Code)
+	assert.Equal([]string{"firmware_restart"}, f.calls)
+}
+
+func TestServer_Job(t *testing.T) {
+	assert := assert.New(t)
+	f := &fakeMoonraker{klippy: "ready", state: "printing"}
+	s := f.server(t, Options{})
+
+	rec := do(s, "GET", "/api/job", "")
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.JSONEq(`{
+		"job": {
+			"file": {"name": "benchy.gcode", "path": "benchy.gcode", "origin": "local", "size": 16384, "date": 1700000000},
+			"estimatedPrintTime": 3000,
+			"filament": {"tool0": {"length": 1234.5, "volume": 0}},
+			"user": null
+		},
+		"progress": {"completion": 25, "filepos": 4096, "printTime": 600, "printTimeLeft": 2400, "printTimeLeftOrigin": "estimate"},
+		"state": "Printing"
+	}`, rec.Body.String())
+
+	assert.Equal(http.StatusNoContent, do(s, "POST", "/api/job", `{"command": "pause", "action": "pause"}`).Code)
+	assert.Equal(http.StatusNoContent, do(s, "POST", "/api/job", `{"command": "pause", "action": "resume"}`).Code)
+	assert.Equal(http.StatusConflict, do(s, "POST", "/api/job", `{"command": "start"}`).Code)
+	assert.Equal(http.StatusNoContent, do(s, "POST", "/api/job", `{"command": "cancel"}`).Code)
+	f.state = "paused"
+	assert.Equal(http.StatusNoContent, do(s, "POST", "/api/job", `{"command": "pause", "action": "toggle"}`).Code)
+	f.state = "complete"
+	assert.Equal(http.StatusNoContent, do(s, "POST", "/api/job", `{"command": "start"}`).Code)
+	assert.Equal(http.StatusConflict, do(s, "POST", "/api/job", `{"command": "cancel"}`).Code)
+	assert.Equal(http.StatusBadRequest, do(s, "POST", "/api/job", `{"command": "restart"}`).Code)
+	// Pausing while printing ignores resume, and vice versa.
+	assert.Equal([]string{"pause", "cancel", "resume", "start benchy.gcode"}, f.calls)
+
+	f.state = "standby"
+	rec = do(s, "GET", "/api/job", "")
+	assert.Contains(rec.Body.String(), `"file":{"name":null`)
+	assert.Contains(rec.Body.String(), `"state":"Operational"`)
+}
+
+func TestServer_Upload(t *testing.T) {
+	assert := assert.New(t)
+	f := &fakeMoonraker{klippy: "ready", state: "standby"}
+	s := f.server(t, Options{})
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, _ := mw.CreateFormFile("file", "cube.gcode")
+	part.Write([]byte("G28\n"))
+	mw.WriteField("path", "voron")
+	mw.WriteField("print", "true")
+	mw.Close()
+
+	req := httptest.NewRequest("POST", "http://octopi.local/api/files/local", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("X-Forwarded-Proto", "https")
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	assert.Equal(http.StatusCreated, rec.Code, rec.Body.String())
+	assert.Equal("https://octopi.local/api/files/local/voron/cube.gcode", rec.Header().Get("Location"))
+	var resp UploadResponse
+	assert.NoError(json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.True(resp.Done)
+	assert.Equal("voron/cube.gcode", resp.Files["local"].Path)
+	assert.Equal("cube.gcode", resp.Files["local"].Name)
+	assert.Equal("G28\n", f.upload.content)
+	assert.Equal("true", f.upload.print)
+
+	assert.Equal("https://octopi.local/downloads/files/local/voron/cube.gcode", resp.Files["local"].Refs.Download)
+
+	assert.Equal(http.StatusBadRequest, do(s, "POST", "/api/files/local", "", "Content-Type", "application/json").Code)
+}
+
+func TestServer_SelectFile(t *testing.T) {
+	assert := assert.New(t)
+	f := &fakeMoonraker{klippy: "ready", state: "complete"}
+	s := f.server(t, Options{})
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, _ := mw.CreateFormFile("file", "cube.gcode")
+	part.Write([]byte("G28\n"))
+	mw.WriteField("select", "true")
+	mw.Close()
+	rec := do(s, "POST", "/api/files/local", body.String(), "Content-Type", mw.FormDataContentType())
+	assert.Equal(http.StatusCreated, rec.Code, rec.Body.String())
+	assert.Equal("http://example.com/api/files/local/cube.gcode", rec.Header().Get("Location"))
+	assert.Equal("false", f.upload.print)
+
+	// The selected file is the job, without the finished print's progress.
+	rec = do(s, "GET", "/api/job", "")
+	assert.Contains(rec.Body.String(), `"file":{"name":"cube.gcode"`)
+	assert.Contains(rec.Body.String(), `"progress":{"completion":null`)
+	assert.Equal(http.StatusNoContent, do(s, "POST", "/api/job", `{"command": "start"}`).Code)
+
+	assert.Equal(http.StatusNoContent, do(s, "POST", "/api/files/local/benchy.gcode", `{"command": "select", "print": true}`).Code)
+	assert.Equal(http.StatusNotFound, do(s, "POST", "/api/files/local/missing.gcode", `{"command": "select"}`).Code)
+	assert.Equal(http.StatusBadRequest, do(s, "POST", "/api/files/local/benchy.gcode", `{"command": "slice"}`).Code)
+	f.state = "printing"
+	assert.Equal(http.StatusConflict, do(s, "POST", "/api/files/local/cube.gcode", `{"command": "select"}`).Code)
+	assert.Equal([]string{"start cube.gcode", "start benchy.gcode"}, f.calls)
+}
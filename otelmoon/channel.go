@@ -0,0 +1,227 @@
+package otelmoon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/creachadair/jrpc2/channel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"sync"
+	"time"
+)
+
+// message holds the parts of a JSON-RPC request, response or notification
+// the instrumentation needs.
+type message struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Error  *rpcError       `json:"error"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func parseMessages(data []byte) []message {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var batch []message
+		json.Unmarshal(data, &batch)
+		return batch
+	}
+	var msg message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil
+	}
+	return []message{msg}
+}
+
+type pendingCall struct {
+	span   trace.Span
+	method string
+	start  time.Time
+}
+
+type tracedChannel struct {
+	channel.Channel
+	inst *instruments
+
+	mu      sync.Mutex
+	pending map[string]pendingCall
+	parents *parentContexts
+}
+
+func (inst *instruments) wrapChannel(ch channel.Channel) channel.Channel {
+	return &tracedChannel{
+		Channel: ch,
+		inst:    inst,
+		pending: make(map[string]pendingCall),
+		parents: &parentContexts{pending: make(map[string][]*parentContext)},
+	}
+}
+
+// Send starts a span for each call. jrpc2 doesn't pass the caller's context
+// to the channel, so the span is a root span unless the call was made with
+// Call.
+func (c *tracedChannel) Send(data []byte) error {
+	msgs := parseMessages(data)
+	var started []string
+	for _, msg := range msgs {
+		if msg.Method == "" || len(msg.ID) == 0 {
+			continue
+		}
+		_, span := c.inst.tracer.Start(c.parents.take(msg.Method, msg.Params), msg.Method,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				semconv.RPCSystemKey.String("jsonrpc"),
+				semconv.RPCMethodKey.String(msg.Method),
+				semconv.RPCJsonrpcVersionKey.String("2.0"),
+				semconv.RPCJsonrpcRequestIDKey.String(string(msg.ID)),
+				attribute.Int("moonraker.request.size", len(data)/len(msgs)),
+			))
+		id := string(msg.ID)
+		c.mu.Lock()
+		c.pending[id] = pendingCall{span, msg.Method, time.Now()}
+		c.mu.Unlock()
+		started = append(started, id)
+	}
+	if err := c.Channel.Send(data); err != nil {
+		for _, id := range started {
+			c.finish(id, 0, err, nil)
+		}
+		return err
+	}
+	return nil
+}
+
+func (c *tracedChannel) Recv() ([]byte, error) {
+	data, err := c.Channel.Recv()
+	if err != nil {
+		return data, err
+	}
+	msgs := parseMessages(data)
+	for _, msg := range msgs {
+		switch {
+		case msg.Method != "" && len(msg.ID) == 0:
+			c.inst.notifications.Add(context.Background(), 1, semconv.RPCMethodKey.String(msg.Method))
+		case len(msg.ID) > 0:
+			c.finish(string(msg.ID), len(data)/len(msgs), nil, msg.Error)
+		}
+	}
+	return data, nil
+}
+
+// finish ends the span of a call, either with its response or with the
+// transport error that prevented one.
+func (c *tracedChannel) finish(id string, size int, err error, rpcErr *rpcError) {
+	c.mu.Lock()
+	call, ok := c.pending[id]
+	delete(c.pending, id)
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+	attrs := []attribute.KeyValue{semconv.RPCMethodKey.String(call.method)}
+	switch {
+	case err != nil:
+		call.span.RecordError(err)
+		call.span.SetStatus(codes.Error, err.Error())
+		attrs = append(attrs, attribute.Bool("error", true))
+	case rpcErr != nil:
+		call.span.SetAttributes(
+			semconv.RPCJsonrpcErrorCodeKey.Int(rpcErr.Code),
+			semconv.RPCJsonrpcErrorMessageKey.String(rpcErr.Message),
+		)
+		call.span.SetStatus(codes.Error, rpcErr.Message)
+		attrs = append(attrs, semconv.RPCJsonrpcErrorCodeKey.Int(rpcErr.Code))
+	}
+	if size > 0 {
+		call.span.SetAttributes(attribute.Int("moonraker.response.size", size))
+	}
+	call.span.End()
+	c.inst.rpcDuration.Record(context.Background(), sinceMillis(call.start), attrs...)
+}
+
+var errChannelClosed = errors.New("channel closed before response")
+
+// Close ends the spans of calls that will never get a response.
+func (c *tracedChannel) Close() error {
+	err := c.Channel.Close()
+	c.mu.Lock()
+	ids := make([]string, 0, len(c.pending))
+	for id := range c.pending {
+		ids = append(ids, id)
+	}
+	c.mu.Unlock()
+	for _, id := range ids {
+		c.finish(id, 0, errChannelClosed, nil)
+	}
+	return err
+}
+
+// parentContexts holds the contexts of calls made with Call on one channel
+// until their request is sent, keyed by method and params. Concurrent calls
+// with identical requests on the same channel may swap parents, but each still
+// gets one of theirs.
+type parentContexts struct {
+	mu      sync.Mutex
+	pending map[string][]*parentContext
+}
+
+type parentContext struct {
+	ctx context.Context
+}
+
+func callKey(method string, params json.RawMessage) string {
+	return method + "\x00" + string(params)
+}
+
+func (p *parentContexts) add(key string, ctx context.Context) *parentContext {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entry := &parentContext{ctx}
+	p.pending[key] = append(p.pending[key], entry)
+	return entry
+}
+
+// remove drops entry if its request was never sent.
+func (p *parentContexts) remove(key string, entry *parentContext) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entries := p.pending[key]
+	for i, e := range entries {
+		if e == entry {
+			entries = append(entries[:i], entries[i+1:]...)
+			break
+		}
+	}
+	if len(entries) == 0 {
+		delete(p.pending, key)
+	} else {
+		p.pending[key] = entries
+	}
+}
+
+// take returns the context waiting for the given request, or
+// context.Background when it wasn't made with Call.
+func (p *parentContexts) take(method string, params json.RawMessage) context.Context {
+	key := callKey(method, params)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entries := p.pending[key]
+	if len(entries) == 0 {
+		return context.Background()
+	}
+	if len(entries) == 1 {
+		delete(p.pending, key)
+	} else {
+		p.pending[key] = entries[1:]
+	}
+	return entries[0].ctx
+}
@@ -0,0 +1,135 @@
+// Package otelmoon adds OpenTelemetry tracing and metrics to a MoonClient.
+//
+// Every JSON-RPC call gets a client span named after its method, ended when
+// the response arrives, and every file upload or download gets an HTTP span.
+// Call latency, HTTP latency and incoming notifications are recorded as
+// metrics. Instrumentation works on the transport, so no MoonClient method
+// needs to know about it:
+//
+//	client, err := otelmoon.NewClient("voron.local:7125", "/websocket", nil)
+//
+// MoonClient methods take no context, so their RPC spans are root spans. Use
+// Call to make a call whose span joins the trace in a context:
+//
+//	err := otelmoon.Call(ctx, client, "printer.print.pause", nil, nil)
+package otelmoon
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/creachadair/jrpc2"
+	"github.com/creachadair/jrpc2/channel"
+	"github.com/creachadair/wschannel"
+	moonraker "github.com/derek-elliott/go-moonraker"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/global"
+	"go.opentelemetry.io/otel/metric/instrument"
+	"go.opentelemetry.io/otel/metric/instrument/syncfloat64"
+	"go.opentelemetry.io/otel/metric/instrument/syncint64"
+	"go.opentelemetry.io/otel/metric/unit"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/url"
+)
+
+const instrumentationName = "github.com/derek-elliott/go-moonraker/otelmoon"
+
+type config struct {
+	tracerProvider trace.TracerProvider
+	meterProvider  metric.MeterProvider
+}
+
+type Option func(*config)
+
+// WithTracerProvider overrides the globally registered tracer provider.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(c *config) { c.tracerProvider = tp }
+}
+
+// WithMeterProvider overrides the globally registered meter provider.
+func WithMeterProvider(mp metric.MeterProvider) Option {
+	return func(c *config) { c.meterProvider = mp }
+}
+
+type instruments struct {
+	tracer        trace.Tracer
+	rpcDuration   syncfloat64.Histogram
+	httpDuration  syncfloat64.Histogram
+	notifications syncint64.Counter
+}
+
+func newInstruments(opts []Option) *instruments {
+	cfg := config{tracerProvider: otel.GetTracerProvider(), meterProvider: global.MeterProvider()}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	meter := cfg.meterProvider.Meter(instrumentationName)
+	inst := &instruments{tracer: cfg.tracerProvider.Tracer(instrumentationName)}
+	var err error
+	if inst.rpcDuration, err = meter.SyncFloat64().Histogram("moonraker.rpc.duration",
+		instrument.WithUnit(unit.Milliseconds), instrument.WithDescription("Duration of JSON-RPC calls.")); err != nil {
+		otel.Handle(err)
+	}
+	if inst.httpDuration, err = meter.SyncFloat64().Histogram("moonraker.http.duration",
+		instrument.WithUnit(unit.Milliseconds), instrument.WithDescription("Duration of file upload and download requests.")); err != nil {
+		otel.Handle(err)
+	}
+	if inst.notifications, err = meter.SyncInt64().Counter("moonraker.notifications",
+		instrument.WithDescription("Notifications received from Moonraker.")); err != nil {
+		otel.Handle(err)
+	}
+	return inst
+}
+
+// NewClient dials Moonraker like moonraker.NewClient and instruments both
+// the websocket channel and the HTTP client used for file transfers.
+func NewClient(host, path string, notifyHandler func(*jrpc2.Request), opts ...Option) (*moonraker.MoonClient, error) {
+	u := url.URL{Scheme: "ws", Host: host, Path: path}
+	ch, err := wschannel.Dial(u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	inst := newInstruments(opts)
+	client := moonraker.NewClientFromChannel(inst.wrapChannel(ch), host, notifyHandler)
+	client.HTTPClient = &http.Client{Transport: inst.wrapTransport(http.DefaultTransport)}
+	return client, nil
+}
+
+// WrapChannel instruments the JSON-RPC traffic on ch. Pass the result to
+// moonraker.NewClientFromChannel.
+func WrapChannel(ch channel.Channel, opts ...Option) channel.Channel {
+	return newInstruments(opts).wrapChannel(ch)
+}
+
+// Transport instruments HTTP requests made through base, or through
+// http.DefaultTransport if base is nil. Use it for MoonClient.HTTPClient.
+func Transport(base http.RoundTripper, opts ...Option) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return newInstruments(opts).wrapTransport(base)
+}
+
+// Call makes a JSON-RPC call like client.Conn.CallResult, with its span a
+// child of the span in ctx. ctx also bounds the call. The client's channel has
+// to be instrumented by this package, otherwise the call is made untraced.
+func Call(ctx context.Context, client *moonraker.MoonClient, method string, params, result interface{}) error {
+	// Marshal once so the key matches the params jrpc2 sends.
+	var raw json.RawMessage
+	if params != nil {
+		var err error
+		if raw, err = json.Marshal(params); err != nil {
+			return err
+		}
+		params = raw
+	}
+	tc, ok := client.Channel().(*tracedChannel)
+	if !ok {
+		return client.Conn.CallResult(ctx, method, params, result)
+	}
+	key := callKey(method, raw)
+	entry := tc.parents.add(key, ctx)
+	defer tc.parents.remove(key, entry)
+	return client.Conn.CallResult(ctx, method, params, result)
+}
@@ -0,0 +1,219 @@
+package otelmoon
+
+import (
+	"bytes"
+	"context"
+	"github.com/creachadair/jrpc2"
+	"github.com/creachadair/jrpc2/channel"
+	"github.com/creachadair/jrpc2/handler"
+	moonraker "github.com/derek-elliott/go-moonraker"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func spanAttr(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func collect(t *testing.T, reader sdkmetric.Reader) map[string]metricdata.Aggregation {
+	rm, err := reader.Collect(context.Background())
+	assert.NoError(t, err)
+	metrics := make(map[string]metricdata.Aggregation)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			metrics[m.Name] = m.Data
+		}
+	}
+	return metrics
+}
+
+func TestInstrumentation(t *testing.T) {
+	assert := assert.New(t)
+	spans := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	opts := []Option{WithTracerProvider(tp), WithMeterProvider(mp)}
+
+	cch, sch := channel.Direct()
+	srv := jrpc2.NewServer(handler.Map{
+		"server.info": handler.New(func(ctx context.Context) (moonraker.ServerInfo, error) {
+			return moonraker.ServerInfo{KlippyState: "ready"}, nil
+		}),
+		"printer.print.pause": handler.New(func(ctx context.Context) (string, error) {
+			return "", jrpc2.Errorf(400, "Print not in progress")
+		}),
+	}, &jrpc2.ServerOptions{AllowPush: true}).Start(sch)
+	defer func() {
+		srv.Stop()
+		srv.Wait()
+	}()
+
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		io.WriteString(w, "G28\nG1 X10\n")
+	}))
+	defer files.Close()
+
+	client := moonraker.NewClientFromChannel(WrapChannel(cch, opts...), strings.TrimPrefix(files.URL, "http://"), nil)
+	client.HTTPClient = &http.Client{Transport: Transport(nil, opts...)}
+	defer client.Close()
+
+	_, err := client.QueryServerInfo()
+	assert.NoError(err)
+	assert.Error(client.PausePrint())
+	received := make(chan struct{})
+	client.OnNotification("notify_klippy_ready", func(*jrpc2.Request) { close(received) })
+	srv.Notify(context.Background(), "notify_klippy_ready", nil)
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("notification not delivered")
+	}
+	assert.NoError(client.UploadFile("benchy.gcode", strings.NewReader("G28\n"), "false"))
+	var buf bytes.Buffer
+	assert.NoError(client.DownloadFile("gcodes/benchy.gcode", &buf))
+
+	ended := spans.Ended()
+	assert.Len(ended, 4)
+	byName := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range ended {
+		byName[span.Name()] = span
+	}
+	info := byName["server.info"]
+	assert.Equal("jsonrpc", spanAttr(info, "rpc.system").AsString())
+	assert.Greater(spanAttr(info, "moonraker.response.size").AsInt64(), int64(0))
+	assert.Equal(codes.Unset, info.Status().Code)
+	pause := byName["printer.print.pause"]
+	assert.Equal(codes.Error, pause.Status().Code)
+	assert.Equal(int64(400), spanAttr(pause, "rpc.jsonrpc.error_code").AsInt64())
+	upload := byName["HTTP POST /server/files/upload"]
+	assert.Equal(int64(201), spanAttr(upload, "http.status_code").AsInt64())
+	assert.Greater(spanAttr(upload, "http.request_content_length").AsInt64(), int64(0))
+	download := byName["HTTP GET /server/files/gcodes/benchy.gcode"]
+	assert.Equal(int64(len("G28\nG1 X10\n")), spanAttr(download, "http.response_content_length").AsInt64())
+
+	metrics := collect(t, reader)
+	rpc := metrics["moonraker.rpc.duration"].(metricdata.Histogram)
+	assert.Len(rpc.DataPoints, 2)
+	httpDuration := metrics["moonraker.http.duration"].(metricdata.Histogram)
+	assert.Len(httpDuration.DataPoints, 2)
+	notifications := metrics["moonraker.notifications"].(metricdata.Sum[int64])
+	assert.Len(notifications.DataPoints, 1)
+	assert.Equal(int64(1), notifications.DataPoints[0].Value)
+	method, _ := notifications.DataPoints[0].Attributes.Value("rpc.method")
+	assert.Equal("notify_klippy_ready", method.AsString())
+}
+
+func TestCall_ParentSpan(t *testing.T) {
+	assert := assert.New(t)
+	spans := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+	cch, sch := channel.Direct()
+	srv := jrpc2.NewServer(handler.Map{
+		"printer.gcode.script": handler.New(func(ctx context.Context, params map[string]string) (string, error) {
+			return "ok", nil
+		}),
+		"server.info": handler.New(func(ctx context.Context) (moonraker.ServerInfo, error) {
+			return moonraker.ServerInfo{KlippyState: "ready"}, nil
+		}),
+	}, nil).Start(sch)
+	defer func() {
+		srv.Stop()
+		srv.Wait()
+	}()
+	client := moonraker.NewClientFromChannel(WrapChannel(cch, WithTracerProvider(tp)), "printer.local", nil)
+	defer client.Close()
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "request")
+	var result string
+	assert.NoError(Call(ctx, client, "printer.gcode.script", map[string]string{"script": "G28"}, &result))
+	assert.Equal("ok", result)
+	var info moonraker.ServerInfo
+	assert.NoError(Call(ctx, client, "server.info", nil, &info))
+	_, err := client.QueryServerInfo()
+	assert.NoError(err)
+	parent.End()
+
+	byName := make(map[string][]sdktrace.ReadOnlySpan)
+	for _, span := range spans.Ended() {
+		byName[span.Name()] = append(byName[span.Name()], span)
+	}
+	gcode := byName["printer.gcode.script"][0]
+	assert.Equal(parent.SpanContext().TraceID(), gcode.SpanContext().TraceID())
+	assert.Equal(parent.SpanContext().SpanID(), gcode.Parent().SpanID())
+	if assert.Len(byName["server.info"], 2) {
+		assert.Equal(parent.SpanContext().SpanID(), byName["server.info"][0].Parent().SpanID())
+		assert.False(byName["server.info"][1].Parent().IsValid(), "MoonClient methods start root spans")
+	}
+	assert.Empty(client.Channel().(*tracedChannel).parents.pending)
+}
+
+func TestCall_ParentsPerClient(t *testing.T) {
+	assert := assert.New(t)
+	spans := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+	newClient := func(host string) *moonraker.MoonClient {
+		cch, sch := channel.Direct()
+		srv := jrpc2.NewServer(handler.Map{
+			"server.info": handler.New(func(ctx context.Context) (moonraker.ServerInfo, error) {
+				return moonraker.ServerInfo{KlippyState: "ready"}, nil
+			}),
+		}, nil).Start(sch)
+		client := moonraker.NewClientFromChannel(WrapChannel(cch, WithTracerProvider(tp)), host, nil)
+		t.Cleanup(func() {
+			client.Close()
+			srv.Stop()
+			srv.Wait()
+		})
+		return client
+	}
+	voron, prusa := newClient("voron.local"), newClient("prusa.local")
+
+	// A Call on voron waiting to be sent must not parent the same request
+	// made on prusa.
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "request")
+	waiting := voron.Channel().(*tracedChannel).parents
+	key := callKey("server.info", nil)
+	entry := waiting.add(key, ctx)
+	_, err := prusa.QueryServerInfo()
+	assert.NoError(err)
+	waiting.remove(key, entry)
+	var info moonraker.ServerInfo
+	assert.NoError(Call(ctx, voron, "server.info", nil, &info))
+	parent.End()
+
+	ended := spans.Ended()
+	if assert.Len(ended, 3) {
+		assert.False(ended[0].Parent().IsValid(), "prusa's call joined voron's trace")
+		assert.Equal(parent.SpanContext().SpanID(), ended[1].Parent().SpanID())
+	}
+}
+
+func TestParseMessages(t *testing.T) {
+	assert := assert.New(t)
+	msgs := parseMessages([]byte(` [{"jsonrpc":"2.0","id":1,"result":{}},{"jsonrpc":"2.0","method":"notify_gcode_response","params":["ok"]}]`))
+	assert.Len(msgs, 2)
+	assert.Equal("1", string(msgs[0].ID))
+	assert.Equal("notify_gcode_response", msgs[1].Method)
+	assert.Nil(parseMessages([]byte("not json")))
+}
@@ -0,0 +1,91 @@
+package otelmoon
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type tracedTransport struct {
+	base http.RoundTripper
+	inst *instruments
+}
+
+func (inst *instruments) wrapTransport(base http.RoundTripper) http.RoundTripper {
+	return &tracedTransport{base, inst}
+}
+
+func (t *tracedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	ctx, span := t.inst.tracer.Start(req.Context(), "HTTP "+req.Method+" "+req.URL.Path,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPMethodKey.String(req.Method),
+			semconv.HTTPURLKey.String(req.URL.String()),
+			semconv.HTTPTargetKey.String(req.URL.Path),
+		))
+	if req.ContentLength > 0 {
+		span.SetAttributes(semconv.HTTPRequestContentLengthKey.Int64(req.ContentLength))
+	}
+	attrs := []attribute.KeyValue{semconv.HTTPMethodKey.String(req.Method), semconv.HTTPTargetKey.String(req.URL.Path)}
+
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+		t.inst.httpDuration.Record(context.Background(), sinceMillis(start), append(attrs, attribute.Bool("error", true))...)
+		return nil, err
+	}
+	span.SetAttributes(semconv.HTTPStatusCodeKey.Int(resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+	attrs = append(attrs, semconv.HTTPStatusCodeKey.Int(resp.StatusCode))
+	// Downloads stream the body after RoundTrip returns, so the span stays
+	// open until the body is fully read or closed.
+	resp.Body = &tracedBody{ReadCloser: resp.Body, end: func(n int64, err error) {
+		span.SetAttributes(semconv.HTTPResponseContentLengthKey.Int64(n))
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+		t.inst.httpDuration.Record(context.Background(), sinceMillis(start), attrs...)
+	}}
+	return resp, nil
+}
+
+func sinceMillis(start time.Time) float64 {
+	return float64(time.Since(start)) / float64(time.Millisecond)
+}
+
+type tracedBody struct {
+	io.ReadCloser
+	n    int64
+	once sync.Once
+	end  func(n int64, err error)
+}
+
+func (b *tracedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if err == io.EOF {
+		b.once.Do(func() { b.end(b.n, nil) })
+	} else if err != nil {
+		b.once.Do(func() { b.end(b.n, err) })
+	}
+	return n, err
+}
+
+func (b *tracedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.end(b.n, nil) })
+	return err
+}
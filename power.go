@@ -0,0 +1,134 @@
+package go_moonraker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type PowerStatus string
+
+const (
+	PowerOn    PowerStatus = "on"
+	PowerOff   PowerStatus = "off"
+	PowerInit  PowerStatus = "init"
+	PowerError PowerStatus = "error"
+)
+
+type PowerAction string
+
+const (
+	PowerActionOn     PowerAction = "on"
+	PowerActionOff    PowerAction = "off"
+	PowerActionToggle PowerAction = "toggle"
+)
+
+type PowerDevice struct {
+	Device              string      `json:"device"`
+	Status              PowerStatus `json:"status"`
+	LockedWhilePrinting bool        `json:"locked_while_printing"`
+	Type                string      `json:"type"`
+}
+
+func (c *MoonClient) ListPowerDevices() ([]PowerDevice, error) {
+	ctx := context.Background()
+	var resp struct {
+		Devices []PowerDevice `json:"devices"`
+	}
+	if err := c.Conn.CallResult(ctx, "machine.device_power.devices", nil, &resp); err != nil {
+		return resp.Devices, err
+	}
+	return resp.Devices, nil
+}
+
+func (c *MoonClient) GetPowerDevice(device string) (PowerStatus, error) {
+	ctx := context.Background()
+	var resp map[string]PowerStatus
+	if err := c.Conn.CallResult(ctx, "machine.device_power.get_device", struct {
+		Device string `json:"device"`
+	}{device}, &resp); err != nil {
+		return "", err
+	}
+	return powerDeviceStatus(resp, device)
+}
+
+func (c *MoonClient) SetPowerDevice(device string, action PowerAction) (PowerStatus, error) {
+	ctx := context.Background()
+	var resp map[string]PowerStatus
+	if err := c.Conn.CallResult(ctx, "machine.device_power.post_device", struct {
+		Device string      `json:"device"`
+		Action PowerAction `json:"action"`
+	}{device, action}, &resp); err != nil {
+		return "", err
+	}
+	return powerDeviceStatus(resp, device)
+}
+
+func (c *MoonClient) TogglePowerDevice(device string) (PowerStatus, error) {
+	return c.SetPowerDevice(device, PowerActionToggle)
+}
+
+func devicesParams(devices []string) map[string]interface{} {
+	params := make(map[string]interface{}, len(devices))
+	for _, device := range devices {
+		params[device] = nil
+	}
+	return params
+}
+
+// Moonraker reports devices it refused to change, for instance ones locked
+// while printing, as an "error" status rather than failing the request.
+func powerDevicesError(statuses map[string]PowerStatus) error {
+	var failed []string
+	for device, status := range statuses {
+		if status == PowerError {
+			failed = append(failed, device)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	sort.Strings(failed)
+	return fmt.Errorf("power devices in error state: %s", strings.Join(failed, ", "))
+}
+
+// powerDeviceStatus picks the status of device out of a single device
+// response, failing like powerDevicesError when it is "error".
+func powerDeviceStatus(resp map[string]PowerStatus, device string) (PowerStatus, error) {
+	status, ok := resp[device]
+	if !ok {
+		return "", fmt.Errorf("no status returned for power device %s", device)
+	}
+	return status, powerDevicesError(map[string]PowerStatus{device: status})
+}
+
+func (c *MoonClient) GetPowerDevices(devices ...string) (map[string]PowerStatus, error) {
+	ctx := context.Background()
+	var resp map[string]PowerStatus
+	if err := c.Conn.CallResult(ctx, "machine.device_power.status", devicesParams(devices), &resp); err != nil {
+		return resp, err
+	}
+	return resp, nil
+}
+
+func (c *MoonClient) SetPowerDevices(action PowerAction, devices ...string) (map[string]PowerStatus, error) {
+	method := "machine.device_power.on"
+	switch action {
+	case PowerActionOn:
+	case PowerActionOff:
+		method = "machine.device_power.off"
+	default:
+		return nil, fmt.Errorf("unsupported action for multiple power devices: %s", action)
+	}
+	ctx := context.Background()
+	var resp map[string]PowerStatus
+	if err := c.Conn.CallResult(ctx, method, devicesParams(devices), &resp); err != nil {
+		return resp, err
+	}
+	return resp, powerDevicesError(resp)
+}
+
+func (c *MoonClient) OnPowerChanged(fn func(*PowerDevice)) func() {
+	return onTypedNotification(c, "notify_power_changed", fn)
+}
@@ -0,0 +1,96 @@
+package go_moonraker
+
+import (
+	"context"
+	"github.com/creachadair/jrpc2/handler"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestMoonClient_PowerDevices(t *testing.T) {
+	assert := assert.New(t)
+	states := map[string]PowerStatus{"printer": PowerOff, "lights": PowerOn}
+	locked := false
+	c, srv := newTestClient(t, handler.Map{
+		"machine.device_power.devices": handler.New(func(ctx context.Context) (map[string][]PowerDevice, error) {
+			return map[string][]PowerDevice{"devices": {
+				{Device: "printer", Status: states["printer"], LockedWhilePrinting: true, Type: "gpio"},
+				{Device: "lights", Status: states["lights"], Type: "tplink_smartplug"},
+			}}, nil
+		}),
+		"machine.device_power.get_device": handler.New(func(ctx context.Context, params map[string]string) (map[string]PowerStatus, error) {
+			device := params["device"]
+			if status, ok := states[device]; ok {
+				return map[string]PowerStatus{device: status}, nil
+			}
+			return map[string]PowerStatus{}, nil
+		}),
+		"machine.device_power.post_device": handler.New(func(ctx context.Context, params map[string]string) (map[string]PowerStatus, error) {
+			device := params["device"]
+			if locked {
+				return map[string]PowerStatus{device: PowerError}, nil
+			}
+			switch PowerAction(params["action"]) {
+			case PowerActionToggle:
+				if states[device] == PowerOn {
+					states[device] = PowerOff
+				} else {
+					states[device] = PowerOn
+				}
+			default:
+				states[device] = PowerStatus(params["action"])
+			}
+			return map[string]PowerStatus{device: states[device]}, nil
+		}),
+		"machine.device_power.off": handler.New(func(ctx context.Context, params map[string]interface{}) (map[string]PowerStatus, error) {
+			resp := make(map[string]PowerStatus)
+			for device := range params {
+				resp[device] = PowerOff
+				if device == "printer" {
+					resp[device] = PowerError
+				}
+			}
+			return resp, nil
+		}),
+	})
+
+	devices, err := c.ListPowerDevices()
+	assert.NoError(err)
+	assert.Len(devices, 2)
+	assert.True(devices[0].LockedWhilePrinting)
+	assert.Equal("tplink_smartplug", devices[1].Type)
+
+	status, err := c.TogglePowerDevice("lights")
+	assert.NoError(err)
+	assert.Equal(PowerOff, status)
+	status, err = c.SetPowerDevice("printer", PowerActionOn)
+	assert.NoError(err)
+	assert.Equal(PowerOn, status)
+	status, err = c.GetPowerDevice("printer")
+	assert.NoError(err)
+	assert.Equal(PowerOn, status)
+	_, err = c.GetPowerDevice("fan")
+	assert.EqualError(err, "no status returned for power device fan")
+	locked = true
+	status, err = c.SetPowerDevice("printer", PowerActionOff)
+	assert.EqualError(err, "power devices in error state: printer")
+	assert.Equal(PowerError, status)
+
+	statuses, err := c.SetPowerDevices(PowerActionOff, "printer", "lights")
+	assert.EqualError(err, "power devices in error state: printer")
+	assert.Equal(PowerOff, statuses["lights"])
+
+	events := make(chan *PowerDevice, 1)
+	c.OnPowerChanged(func(device *PowerDevice) { events <- device })
+	assert.NoError(srv.Notify(context.Background(), "notify_power_changed", []interface{}{
+		PowerDevice{Device: "lights", Status: PowerOn, Type: "tplink_smartplug"},
+	}))
+	select {
+	case event := <-events:
+		assert.Equal("lights", event.Device)
+		assert.Equal(PowerOn, event.Status)
+	case <-time.After(time.Second):
+		t.Fatal("no power event delivered")
+	}
+}
@@ -99,4 +99,24 @@ type BedMesh struct {
 	MeshMax      []float32   `json:"mesh_max"`
 	ProbedMatrix [][]float32 `json:"probed_matrix"`
 	MeshMatrix   [][]float32 `json:"mesh_matrix"`
+	// Profiles holds every stored profile by name.
+	Profiles map[string]BedMeshProfile `json:"profiles"`
+}
+
+type BedMeshProfile struct {
+	Points     [][]float32   `json:"points"`
+	MeshParams BedMeshParams `json:"mesh_params"`
+}
+
+type BedMeshParams struct {
+	MinX     float32 `json:"min_x"`
+	MaxX     float32 `json:"max_x"`
+	MinY     float32 `json:"min_y"`
+	MaxY     float32 `json:"max_y"`
+	XCount   int     `json:"x_count"`
+	YCount   int     `json:"y_count"`
+	MeshXPPS int     `json:"mesh_x_pps"`
+	MeshYPPS int     `json:"mesh_y_pps"`
+	Algo     string  `json:"algo"`
+	Tension  float32 `json:"tension"`
 }
@@ -0,0 +1,460 @@
+package go_moonraker
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type FarmPrinterState string
+
+const (
+	PrinterOffline       FarmPrinterState = "offline"
+	PrinterIdle          FarmPrinterState = "idle"
+	PrinterUploading     FarmPrinterState = "uploading"
+	PrinterPrinting      FarmPrinterState = "printing"
+	PrinterPaused        FarmPrinterState = "paused"
+	PrinterAwaitingClear FarmPrinterState = "awaiting_clear"
+	PrinterError         FarmPrinterState = "error"
+)
+
+type FarmJobStatus string
+
+const (
+	JobQueued    FarmJobStatus = "queued"
+	JobUploading FarmJobStatus = "uploading"
+	JobPrinting  FarmJobStatus = "printing"
+	JobCompleted FarmJobStatus = "completed"
+	JobFailed    FarmJobStatus = "failed"
+)
+
+type PrinterCapabilities struct {
+	NozzleSize float64    `json:"nozzle_size"`
+	BedSize    [3]float64 `json:"bed_size"`
+	Filaments  []string   `json:"filaments"`
+	Tags       []string   `json:"tags"`
+}
+
+type JobRequirements struct {
+	NozzleSize float64    `json:"nozzle_size"`
+	MinBedSize [3]float64 `json:"min_bed_size"`
+	Filament   string     `json:"filament"`
+	Tags       []string   `json:"tags"`
+}
+
+func (caps PrinterCapabilities) Satisfies(req JobRequirements) bool {
+	if req.NozzleSize > 0 && math.Abs(caps.NozzleSize-req.NozzleSize) > 0.001 {
+		return false
+	}
+	for i, size := range req.MinBedSize {
+		if size > caps.BedSize[i] {
+			return false
+		}
+	}
+	if req.Filament != "" && !containsString(caps.Filaments, req.Filament) {
+		return false
+	}
+	for _, tag := range req.Tags {
+		if !containsString(caps.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+type FarmPrinter struct {
+	Name         string
+	Client       *MoonClient
+	Capabilities PrinterCapabilities
+
+	state   FarmPrinterState
+	job     *FarmJob
+	started bool
+	// lastJob is the newest history job id before the current job was
+	// uploaded, used to spot prints that ran entirely between two polls.
+	lastJob     string
+	haveLastJob bool
+}
+
+type FarmJob struct {
+	Id           string                        `json:"id"`
+	Filename     string                        `json:"filename"`
+	Requirements JobRequirements               `json:"requirements"`
+	Open         func() (io.ReadCloser, error) `json:"-"`
+
+	Status    FarmJobStatus `json:"status"`
+	Printer   string        `json:"printer"`
+	Attempts  int           `json:"attempts"`
+	LastError string        `json:"last_error,omitempty"`
+	Submitted time.Time     `json:"submitted"`
+	Started   time.Time     `json:"started"`
+	Finished  time.Time     `json:"finished"`
+}
+
+func NewFileJob(path string, req JobRequirements) *FarmJob {
+	return &FarmJob{
+		Filename:     filepath.Base(path),
+		Requirements: req,
+		Open: func() (io.ReadCloser, error) {
+			return os.Open(path)
+		},
+	}
+}
+
+type SchedulerOptions struct {
+	PollInterval time.Duration
+	MaxAttempts  int
+	StartTimeout time.Duration
+	// When set, a printer that finished a job is not given another one until
+	// MarkCleared confirms the bed has been emptied.
+	RequireBedClear bool
+	OnJobUpdate     func(FarmJob)
+}
+
+type Scheduler struct {
+	opts     SchedulerOptions
+	mu       sync.Mutex
+	printers []*FarmPrinter
+	jobs     []*FarmJob
+	nextId   int
+	updates  []FarmJob
+}
+
+func NewScheduler(opts SchedulerOptions) *Scheduler {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = 10 * time.Second
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 3
+	}
+	if opts.StartTimeout <= 0 {
+		opts.StartTimeout = 2 * time.Minute
+	}
+	return &Scheduler{opts: opts}
+}
+
+func (s *Scheduler) AddPrinter(name string, client *MoonClient, caps PrinterCapabilities) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.printers = append(s.printers, &FarmPrinter{
+		Name:         name,
+		Client:       client,
+		Capabilities: caps,
+		state:        PrinterOffline,
+	})
+}
+
+func (s *Scheduler) Submit(job *FarmJob) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextId++
+	if job.Id == "" {
+		job.Id = fmt.Sprintf("%06d", s.nextId)
+	}
+	job.Status = JobQueued
+	job.Submitted = time.Now()
+	s.jobs = append(s.jobs, job)
+	return job.Id
+}
+
+func (s *Scheduler) Jobs() []FarmJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]FarmJob, len(s.jobs))
+	for i, job := range s.jobs {
+		jobs[i] = *job
+	}
+	return jobs
+}
+
+func (s *Scheduler) PrinterStates() map[string]FarmPrinterState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	states := make(map[string]FarmPrinterState, len(s.printers))
+	for _, p := range s.printers {
+		states[p.Name] = p.state
+	}
+	return states
+}
+
+func (s *Scheduler) MarkCleared(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.printers {
+		if p.Name == name {
+			if p.state == PrinterAwaitingClear {
+				p.state = PrinterIdle
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown printer: %s", name)
+}
+
+func (s *Scheduler) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.opts.PollInterval)
+	defer ticker.Stop()
+	for {
+		s.Tick()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Tick refreshes every printer's state, settles finished jobs and dispatches
+// queued jobs to idle printers. It returns once all started uploads are done.
+// OnJobUpdate is called from Tick without the scheduler's lock held.
+func (s *Scheduler) Tick() {
+	s.mu.Lock()
+	printers := append([]*FarmPrinter(nil), s.printers...)
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, p := range printers {
+		wg.Add(1)
+		go func(p *FarmPrinter) {
+			defer wg.Done()
+			s.refresh(p)
+		}(p)
+	}
+	wg.Wait()
+	s.flushUpdates()
+
+	assignments := s.assign()
+	s.flushUpdates()
+	for _, a := range assignments {
+		wg.Add(1)
+		go func(p *FarmPrinter, job *FarmJob) {
+			defer wg.Done()
+			s.start(p, job)
+		}(a.printer, a.job)
+	}
+	wg.Wait()
+	s.flushUpdates()
+}
+
+func (s *Scheduler) refresh(p *FarmPrinter) {
+	status, err := p.Client.QueryPrinterObjects("webhooks", "print_stats")
+	ranUnseen := false
+	if err == nil && s.mayHaveMissedPrint(p, status) {
+		ranUnseen = s.printedSinceUpload(p)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		log.WithError(err).WithField("printer", p.Name).Warn("printer status query failed")
+		p.state = PrinterOffline
+		return
+	}
+	if status.Webhooks == nil || status.Webhooks.State != "ready" {
+		p.state = PrinterOffline
+		return
+	}
+	printState := ""
+	if status.PrintStats != nil {
+		printState = status.PrintStats.State
+	}
+	job := p.job
+	switch printState {
+	case "printing", "paused":
+		p.state = PrinterPrinting
+		if printState == "paused" {
+			p.state = PrinterPaused
+		}
+		if job != nil && job.Status == JobPrinting {
+			p.started = true
+		}
+		return
+	case "complete", "error", "cancelled":
+		// print_stats keeps reporting the previous result until the new
+		// print is picked up, so only settle jobs that were seen running or
+		// that show up in the job history.
+		if job != nil && job.Status == JobPrinting && (p.started || ranUnseen) {
+			var err error
+			if printState != "complete" {
+				err = fmt.Errorf("print %s", printState)
+				if status.PrintStats.Message != "" {
+					err = fmt.Errorf("print %s: %s", printState, status.PrintStats.Message)
+				}
+			}
+			s.finish(p, job, err)
+			if s.opts.RequireBedClear {
+				p.state = PrinterAwaitingClear
+				return
+			}
+		}
+	}
+	if job != nil && job.Status == JobPrinting && !p.started {
+		if time.Since(job.Started) > s.opts.StartTimeout {
+			s.finish(p, job, fmt.Errorf("print did not start within %s", s.opts.StartTimeout))
+		} else {
+			p.state = PrinterPrinting
+			return
+		}
+	}
+	if p.state != PrinterAwaitingClear && p.state != PrinterUploading {
+		p.state = PrinterIdle
+	}
+}
+
+// mayHaveMissedPrint reports whether p's job has not been seen printing while
+// print_stats already shows a finished print of the same file.
+func (s *Scheduler) mayHaveMissedPrint(p *FarmPrinter, status *PrinterObjects) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job := p.job
+	if job == nil || job.Status != JobPrinting || p.started || !p.haveLastJob || status.PrintStats == nil {
+		return false
+	}
+	switch status.PrintStats.State {
+	case "complete", "error", "cancelled":
+		return status.PrintStats.Filename == job.Filename
+	}
+	return false
+}
+
+// printedSinceUpload reports whether the job history gained a print of p's
+// job since it was uploaded, which means the print started and finished
+// between two polls.
+func (s *Scheduler) printedSinceUpload(p *FarmPrinter) bool {
+	latest, err := latestHistoryJob(p.Client)
+	if err != nil {
+		log.WithError(err).WithField("printer", p.Name).Warn("job history query failed")
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return latest != nil && p.job != nil && latest.JobId != p.lastJob && latest.Filename == p.job.Filename
+}
+
+func latestHistoryJob(client *MoonClient) (*Job, error) {
+	page, err := client.JobHistoryList(1, 0, 0, 0, "desc")
+	if err != nil {
+		return nil, err
+	}
+	if len(page.Jobs) == 0 {
+		return nil, nil
+	}
+	return &page.Jobs[0], nil
+}
+
+// finish settles the job running on p. Failed jobs go back to the queue until
+// they have used up their attempts. The caller must hold s.mu.
+func (s *Scheduler) finish(p *FarmPrinter, job *FarmJob, err error) {
+	p.job = nil
+	p.started = false
+	job.Finished = time.Now()
+	if err == nil {
+		job.Status = JobCompleted
+		job.LastError = ""
+	} else {
+		job.LastError = err.Error()
+		if job.Attempts < s.opts.MaxAttempts {
+			job.Status = JobQueued
+			job.Printer = ""
+		} else {
+			job.Status = JobFailed
+		}
+	}
+	s.notify(job)
+}
+
+type assignment struct {
+	printer *FarmPrinter
+	job     *FarmJob
+}
+
+func (s *Scheduler) assign() []assignment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var assignments []assignment
+	for _, job := range s.jobs {
+		if job.Status != JobQueued {
+			continue
+		}
+		for _, p := range s.printers {
+			if p.state != PrinterIdle || p.job != nil || !p.Capabilities.Satisfies(job.Requirements) {
+				continue
+			}
+			p.state = PrinterUploading
+			p.job = job
+			job.Status = JobUploading
+			job.Printer = p.Name
+			job.Attempts++
+			assignments = append(assignments, assignment{p, job})
+			s.notify(job)
+			break
+		}
+	}
+	return assignments
+}
+
+func (s *Scheduler) start(p *FarmPrinter, job *FarmJob) {
+	latest, historyErr := latestHistoryJob(p.Client)
+	err := s.upload(p, job)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p.lastJob, p.haveLastJob = "", historyErr == nil
+	if latest != nil {
+		p.lastJob = latest.JobId
+	}
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{"printer": p.Name, "job": job.Id}).Warn("starting job failed")
+		p.state = PrinterError
+		s.finish(p, job, err)
+		return
+	}
+	p.state = PrinterPrinting
+	job.Status = JobPrinting
+	job.Started = time.Now()
+	s.notify(job)
+}
+
+func (s *Scheduler) upload(p *FarmPrinter, job *FarmJob) error {
+	if job.Open == nil {
+		return fmt.Errorf("job %s has no data source", job.Id)
+	}
+	data, err := job.Open()
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+	return p.Client.UploadFile(job.Filename, data, "true")
+}
+
+// notify queues a snapshot of job for OnJobUpdate. The caller must hold s.mu.
+func (s *Scheduler) notify(job *FarmJob) {
+	if s.opts.OnJobUpdate != nil {
+		s.updates = append(s.updates, *job)
+	}
+}
+
+// flushUpdates passes the queued job snapshots to OnJobUpdate. The caller must
+// not hold s.mu, so the callback may use the scheduler.
+func (s *Scheduler) flushUpdates() {
+	s.mu.Lock()
+	updates := s.updates
+	s.updates = nil
+	s.mu.Unlock()
+	for _, job := range updates {
+		s.opts.OnJobUpdate(job)
+	}
+}
@@ -0,0 +1,210 @@
+package go_moonraker
+
+import (
+	"context"
+	"fmt"
+	"github.com/creachadair/jrpc2/handler"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type fakeFarmPrinter struct {
+	mu       sync.Mutex
+	state    string
+	message  string
+	filename string
+	uploads  []string
+	history  []Job
+	failNext bool
+	// instant makes prints finish as soon as they are uploaded.
+	instant bool
+}
+
+func (f *fakeFarmPrinter) set(state, message string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.state, f.message = state, message
+}
+
+func newFakeFarmPrinter(t *testing.T) (*MoonClient, *fakeFarmPrinter) {
+	fake := &fakeFarmPrinter{state: "standby"}
+	c, _ := newTestClient(t, handler.Map{
+		"printer.objects.query": handler.New(func(ctx context.Context, params QueryObjectParams) (PrinterObjectsStatus, error) {
+			fake.mu.Lock()
+			defer fake.mu.Unlock()
+			return PrinterObjectsStatus{Status: PrinterObjects{
+				Webhooks:   &Webhooks{State: "ready"},
+				PrintStats: &PrintStats{State: fake.state, Message: fake.message, Filename: fake.filename},
+			}}, nil
+		}),
+		"server.history.list": handler.New(func(ctx context.Context, params struct {
+			Limit int `json:"limit"`
+		}) (JobHistory, error) {
+			fake.mu.Lock()
+			defer fake.mu.Unlock()
+			var jobs []Job
+			for i := len(fake.history) - 1; i >= 0 && len(jobs) < params.Limit; i-- {
+				jobs = append(jobs, fake.history[i])
+			}
+			return JobHistory{Count: len(fake.history), Jobs: jobs}, nil
+		}),
+	})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		if fake.failNext {
+			fake.failNext = false
+			http.Error(w, "disk full", http.StatusInternalServerError)
+			return
+		}
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		io.Copy(io.Discard, file)
+		fake.uploads = append(fake.uploads, header.Filename)
+		if r.FormValue("print") == "true" {
+			fake.state, fake.filename = "printing", header.Filename
+			if fake.instant {
+				fake.state = "complete"
+				fake.history = append(fake.history, Job{JobId: fmt.Sprintf("%06X", len(fake.history)+1), Filename: header.Filename, Status: "completed"})
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL)
+	c.Host = u.Host
+	return c, fake
+}
+
+func stringJob(name string, req JobRequirements) *FarmJob {
+	return &FarmJob{
+		Filename:     name,
+		Requirements: req,
+		Open: func() (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader("G28\n")), nil
+		},
+	}
+}
+
+func TestPrinterCapabilities_Satisfies(t *testing.T) {
+	assert := assert.New(t)
+	caps := PrinterCapabilities{NozzleSize: 0.4, BedSize: [3]float64{250, 210, 200}, Filaments: []string{"PLA", "PETG"}, Tags: []string{"enclosed"}}
+	assert.True(caps.Satisfies(JobRequirements{}))
+	assert.True(caps.Satisfies(JobRequirements{NozzleSize: 0.4, MinBedSize: [3]float64{200, 200, 100}, Filament: "PETG", Tags: []string{"enclosed"}}))
+	assert.False(caps.Satisfies(JobRequirements{NozzleSize: 0.6}))
+	assert.False(caps.Satisfies(JobRequirements{MinBedSize: [3]float64{300, 0, 0}}))
+	assert.False(caps.Satisfies(JobRequirements{Filament: "ABS"}))
+	assert.False(caps.Satisfies(JobRequirements{Tags: []string{"direct-drive"}}))
+}
+
+func TestScheduler_AssignsByCapability(t *testing.T) {
+	assert := assert.New(t)
+	small, smallFake := newFakeFarmPrinter(t)
+	big, bigFake := newFakeFarmPrinter(t)
+	s := NewScheduler(SchedulerOptions{MaxAttempts: 2})
+	s.AddPrinter("small", small, PrinterCapabilities{NozzleSize: 0.4, BedSize: [3]float64{180, 180, 180}})
+	s.AddPrinter("big", big, PrinterCapabilities{NozzleSize: 0.6, BedSize: [3]float64{350, 350, 350}, Filaments: []string{"ABS"}})
+
+	bigId := s.Submit(stringJob("big.gcode", JobRequirements{MinBedSize: [3]float64{300, 300, 10}, Filament: "ABS"}))
+	smallId := s.Submit(stringJob("small.gcode", JobRequirements{NozzleSize: 0.4}))
+	s.Tick()
+
+	assert.Equal([]string{"small.gcode"}, smallFake.uploads)
+	assert.Equal([]string{"big.gcode"}, bigFake.uploads)
+	jobs := s.Jobs()
+	assert.Equal(bigId, jobs[0].Id)
+	assert.Equal("big", jobs[0].Printer)
+	assert.Equal(JobPrinting, jobs[0].Status)
+	assert.Equal(smallId, jobs[1].Id)
+	assert.Equal("small", jobs[1].Printer)
+
+	s.Tick()
+	smallFake.set("complete", "")
+	bigFake.set("error", "Heater extruder not heating at expected rate")
+	s.Tick()
+
+	jobs = s.Jobs()
+	assert.Equal(JobCompleted, jobs[1].Status)
+	assert.Equal(JobPrinting, jobs[0].Status)
+	assert.Equal(2, jobs[0].Attempts)
+	assert.Contains(jobs[0].LastError, "not heating")
+	assert.Equal([]string{"big.gcode", "big.gcode"}, bigFake.uploads)
+}
+
+func TestScheduler_RetriesFailedUpload(t *testing.T) {
+	assert := assert.New(t)
+	c, fake := newFakeFarmPrinter(t)
+	fake.failNext = true
+	var updates []FarmJobStatus
+	s := NewScheduler(SchedulerOptions{
+		MaxAttempts:     2,
+		RequireBedClear: true,
+		OnJobUpdate:     func(job FarmJob) { updates = append(updates, job.Status) },
+	})
+	s.AddPrinter("p1", c, PrinterCapabilities{})
+	s.Submit(stringJob("part.gcode", JobRequirements{}))
+
+	s.Tick()
+	jobs := s.Jobs()
+	assert.Equal(JobQueued, jobs[0].Status)
+	assert.Contains(jobs[0].LastError, "500")
+
+	s.Tick()
+	s.Tick()
+	fake.set("complete", "")
+	s.Tick()
+	assert.Equal(JobCompleted, s.Jobs()[0].Status)
+	assert.Equal(PrinterAwaitingClear, s.PrinterStates()["p1"])
+	s.Tick()
+	assert.Equal(PrinterAwaitingClear, s.PrinterStates()["p1"])
+	assert.NoError(s.MarkCleared("p1"))
+	assert.Equal(PrinterIdle, s.PrinterStates()["p1"])
+	assert.Equal([]FarmJobStatus{JobUploading, JobQueued, JobUploading, JobPrinting, JobCompleted}, updates)
+}
+
+func TestScheduler_PrintFinishedBetweenPolls(t *testing.T) {
+	assert := assert.New(t)
+	c, fake := newFakeFarmPrinter(t)
+	fake.history = []Job{{JobId: "000001", Filename: "part.gcode", Status: "completed"}}
+	fake.state, fake.filename = "complete", "part.gcode"
+	fake.instant = true
+	s := NewScheduler(SchedulerOptions{})
+	s.AddPrinter("p1", c, PrinterCapabilities{})
+	s.Submit(stringJob("part.gcode", JobRequirements{}))
+
+	s.Tick()
+	assert.Equal(JobPrinting, s.Jobs()[0].Status)
+	s.Tick()
+	jobs := s.Jobs()
+	assert.Equal(JobCompleted, jobs[0].Status)
+	assert.Equal(1, jobs[0].Attempts)
+	assert.Equal([]string{"part.gcode"}, fake.uploads)
+}
+
+func TestScheduler_OnJobUpdateMayUseScheduler(t *testing.T) {
+	assert := assert.New(t)
+	c, _ := newFakeFarmPrinter(t)
+	var s *Scheduler
+	var seen []int
+	s = NewScheduler(SchedulerOptions{
+		OnJobUpdate: func(job FarmJob) {
+			seen = append(seen, len(s.Jobs()))
+			if job.Status == JobPrinting && len(seen) == 2 {
+				s.Submit(stringJob("next.gcode", JobRequirements{}))
+			}
+		},
+	})
+	s.AddPrinter("p1", c, PrinterCapabilities{})
+	s.Submit(stringJob("part.gcode", JobRequirements{}))
+	s.Tick()
+	assert.Equal([]int{1, 1}, seen)
+	assert.Len(s.Jobs(), 2)
+}
@@ -0,0 +1,160 @@
+package go_moonraker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+)
+
+var ErrNoThumbnail = errors.New("file has no thumbnails")
+
+// BestThumbnail picks the smallest thumbnail at least width x height, or the
+// largest one when none is big enough. A zero size asks for the largest.
+func BestThumbnail(thumbnails []Thumbnail, width, height int) (Thumbnail, bool) {
+	if len(thumbnails) == 0 {
+		return Thumbnail{}, false
+	}
+	var best, largest *Thumbnail
+	for i := range thumbnails {
+		t := &thumbnails[i]
+		if largest == nil || t.Width*t.Height > largest.Width*largest.Height {
+			largest = t
+		}
+		if (width > 0 || height > 0) && t.Width >= width && t.Height >= height &&
+			(best == nil || t.Width*t.Height < best.Width*best.Height) {
+			best = t
+		}
+	}
+	if best == nil {
+		best = largest
+	}
+	return *best, true
+}
+
+// ThumbnailPath returns the path of a thumbnail for use with DownloadFile.
+// Thumbnail paths are relative to the directory of the G-code file, which is
+// itself relative to the gcodes root.
+func ThumbnailPath(gcodeFile string, t Thumbnail) string {
+	return path.Join("gcodes", path.Dir(gcodeFile), t.RelativePath)
+}
+
+// ThumbnailCache keeps thumbnails in memory and, when Dir is set, on disk so
+// they survive restarts. Entries are keyed on the G-code file's modification
+// time, so re-uploaded files get fresh thumbnails.
+type ThumbnailCache struct {
+	Dir string
+
+	mu     sync.Mutex
+	memory map[string][]byte
+}
+
+func NewThumbnailCache(dir string) *ThumbnailCache {
+	return &ThumbnailCache{Dir: dir, memory: make(map[string][]byte)}
+}
+
+func thumbnailKey(host, thumbPath string, modified float64) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%f", host, thumbPath, modified)))
+	return hex.EncodeToString(sum[:])
+}
+
+func (tc *ThumbnailCache) get(key string) ([]byte, bool) {
+	tc.mu.Lock()
+	data, ok := tc.memory[key]
+	tc.mu.Unlock()
+	if ok || tc.Dir == "" {
+		return data, ok
+	}
+	data, err := os.ReadFile(filepath.Join(tc.Dir, key+".img"))
+	if err != nil {
+		return nil, false
+	}
+	tc.mu.Lock()
+	tc.memory[key] = data
+	tc.mu.Unlock()
+	return data, true
+}
+
+func (tc *ThumbnailCache) put(key string, data []byte) error {
+	tc.mu.Lock()
+	if tc.memory == nil {
+		tc.memory = make(map[string][]byte)
+	}
+	tc.memory[key] = data
+	tc.mu.Unlock()
+	if tc.Dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(tc.Dir, 0o755); err != nil {
+		return err
+	}
+	// Write and rename so readers never see a partial file.
+	tmp, err := os.CreateTemp(tc.Dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(tc.Dir, key+".img"))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// ThumbnailData downloads the thumbnail of a G-code file that best fits
+// width x height, as stored by the slicer. That is PNG for most slicers but
+// some can be configured to write JPEG. The file path is relative to the
+// gcodes root.
+func (c *MoonClient) ThumbnailData(gcodeFile string, width, height int) ([]byte, error) {
+	meta, err := c.GcodeMetadata(gcodeFile)
+	if err != nil {
+		return nil, err
+	}
+	t, ok := BestThumbnail(meta.Thumbnails, width, height)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", gcodeFile, ErrNoThumbnail)
+	}
+	thumbPath := ThumbnailPath(gcodeFile, t)
+	key := thumbnailKey(c.Host, thumbPath, meta.Modified)
+	if c.ThumbnailCache != nil {
+		if data, ok := c.ThumbnailCache.get(key); ok {
+			return data, nil
+		}
+	}
+	var buf bytes.Buffer
+	if err := c.DownloadFile(thumbPath, &buf); err != nil {
+		return nil, err
+	}
+	if c.ThumbnailCache != nil {
+		// A cache that can't be written only costs a download next time.
+		c.ThumbnailCache.put(key, buf.Bytes())
+	}
+	return buf.Bytes(), nil
+}
+
+// Thumbnail downloads and decodes the thumbnail of a G-code file that best
+// fits width x height.
+func (c *MoonClient) Thumbnail(gcodeFile string, width, height int) (image.Image, error) {
+	data, err := c.ThumbnailData(gcodeFile, width, height)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decoding thumbnail of %s: %w", gcodeFile, err)
+	}
+	return img, nil
+}
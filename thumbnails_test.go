@@ -0,0 +1,108 @@
+package go_moonraker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/creachadair/jrpc2/handler"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBestThumbnail(t *testing.T) {
+	assert := assert.New(t)
+	thumbs := []Thumbnail{
+		{Width: 300, Height: 300, RelativePath: ".thumbs/cube-300x300.png"},
+		{Width: 32, Height: 32, RelativePath: ".thumbs/cube-32x32.png"},
+		{Width: 400, Height: 300, RelativePath: ".thumbs/cube-400x300.png"},
+	}
+	best, ok := BestThumbnail(thumbs, 48, 48)
+	assert.True(ok)
+	assert.Equal(300, best.Width)
+	best, _ = BestThumbnail(thumbs, 16, 16)
+	assert.Equal(32, best.Width)
+	best, _ = BestThumbnail(thumbs, 1024, 1024)
+	assert.Equal(400, best.Width)
+	best, _ = BestThumbnail(thumbs, 0, 0)
+	assert.Equal(400, best.Width)
+	_, ok = BestThumbnail(nil, 32, 32)
+	assert.False(ok)
+
+	assert.Equal("gcodes/voron/.thumbs/cube-32x32.png", ThumbnailPath("voron/cube.gcode", thumbs[1]))
+	assert.Equal("gcodes/.thumbs/cube-32x32.png", ThumbnailPath("cube.gcode", thumbs[1]))
+}
+
+func TestMoonClient_Thumbnail(t *testing.T) {
+	assert := assert.New(t)
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(1, 1, color.RGBA{255, 0, 0, 255})
+	var encoded bytes.Buffer
+	png.Encode(&encoded, img)
+
+	var downloads []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads = append(downloads, r.URL.Path)
+		if r.URL.Path != "/server/files/gcodes/voron/.thumbs/cube-300x300.png" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(encoded.Bytes())
+	}))
+	defer srv.Close()
+
+	modified := 1700000000.0
+	newClient := func() *MoonClient {
+		c, _ := newTestClient(t, handler.Map{
+			"server.files.metadata": handler.New(func(ctx context.Context, params map[string]string) (json.RawMessage, error) {
+				if params["filename"] == "blank.gcode" {
+					return json.RawMessage(`{"filename": "blank.gcode"}`), nil
+				}
+				meta, _ := json.Marshal(GcodeMetadata{Filename: params["filename"], Modified: modified, Thumbnails: []Thumbnail{
+					{Width: 32, Height: 32, RelativePath: ".thumbs/cube-32x32.png"},
+					{Width: 300, Height: 300, RelativePath: ".thumbs/cube-300x300.png"},
+				}})
+				return meta, nil
+			}),
+		})
+		c.Host = strings.TrimPrefix(srv.URL, "http://")
+		return c
+	}
+	dir := t.TempDir()
+	c := newClient()
+	c.ThumbnailCache = NewThumbnailCache(dir)
+
+	decoded, err := c.Thumbnail("voron/cube.gcode", 100, 100)
+	assert.NoError(err)
+	if assert.NotNil(decoded) {
+		assert.Equal(image.Rect(0, 0, 2, 2), decoded.Bounds())
+		assert.Equal(color.RGBA{255, 0, 0, 255}, color.RGBAModel.Convert(decoded.At(1, 1)))
+	}
+	data, err := c.ThumbnailData("voron/cube.gcode", 100, 100)
+	assert.NoError(err)
+	assert.Equal(encoded.Bytes(), data)
+	assert.Len(downloads, 1)
+
+	// A new client with an empty memory cache still finds the file on disk.
+	c = newClient()
+	c.ThumbnailCache = NewThumbnailCache(dir)
+	_, err = c.ThumbnailData("voron/cube.gcode", 100, 100)
+	assert.NoError(err)
+	assert.Len(downloads, 1)
+
+	// A re-uploaded file has a new modification time and is fetched again.
+	modified++
+	_, err = c.ThumbnailData("voron/cube.gcode", 100, 100)
+	assert.NoError(err)
+	assert.Len(downloads, 2)
+
+	_, err = c.ThumbnailData("voron/cube.gcode", 16, 16)
+	assert.EqualError(err, "download of gcodes/voron/.thumbs/cube-32x32.png failed: 404 Not Found")
+	_, err = c.Thumbnail("blank.gcode", 32, 32)
+	assert.ErrorIs(err, ErrNoThumbnail)
+}
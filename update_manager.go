@@ -0,0 +1,186 @@
+package go_moonraker
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+type UpdateStatus struct {
+	Busy                    bool                      `json:"busy"`
+	GithubRateLimit         int                       `json:"github_rate_limit"`
+	GithubRequestsRemaining int                       `json:"github_requests_remaining"`
+	GithubLimitResetTime    float64                   `json:"github_limit_reset_time"`
+	System                  SystemUpdateInfo          `json:"-"`
+	Repos                   map[string]RepoUpdateInfo `json:"-"`
+}
+
+type SystemUpdateInfo struct {
+	PackageCount int      `json:"package_count"`
+	PackageList  []string `json:"package_list"`
+}
+
+type RepoUpdateInfo struct {
+	Name              string       `json:"name"`
+	ConfiguredType    string       `json:"configured_type"`
+	DetectedType      string       `json:"detected_type"`
+	Channel           string       `json:"channel"`
+	Owner             string       `json:"owner"`
+	RepoName          string       `json:"repo_name"`
+	Branch            string       `json:"branch"`
+	RemoteAlias       string       `json:"remote_alias"`
+	Version           string       `json:"version"`
+	RemoteVersion     string       `json:"remote_version"`
+	RollbackVersion   string       `json:"rollback_version"`
+	FullVersionString string       `json:"full_version_string"`
+	CurrentHash       string       `json:"current_hash"`
+	RemoteHash        string       `json:"remote_hash"`
+	IsValid           bool         `json:"is_valid"`
+	IsDirty           bool         `json:"is_dirty"`
+	Corrupt           bool         `json:"corrupt"`
+	DebugEnabled      bool         `json:"debug_enabled"`
+	CommitsBehind     []CommitInfo `json:"commits_behind"`
+	InfoTags          []string     `json:"info_tags"`
+	GitMessages       []string     `json:"git_messages"`
+	Anomalies         []string     `json:"anomalies"`
+	Warnings          []string     `json:"warnings"`
+}
+
+type CommitInfo struct {
+	Sha     string `json:"sha"`
+	Author  string `json:"author"`
+	Date    string `json:"date"`
+	Subject string `json:"subject"`
+	Message string `json:"message"`
+	Tag     string `json:"tag"`
+}
+
+func (r RepoUpdateInfo) UpdateAvailable() bool {
+	if r.ConfiguredType == "git_repo" || r.DetectedType == "git_repo" {
+		return len(r.CommitsBehind) > 0
+	}
+	return r.RemoteVersion != "" && r.RemoteVersion != "?" && r.Version != r.RemoteVersion
+}
+
+func (r RepoUpdateInfo) Invalid() bool {
+	return !r.IsValid || r.Corrupt
+}
+
+// version_info mixes the "system" package summary with one entry per
+// configured repo, so it is split into System and Repos here.
+func (s *UpdateStatus) UnmarshalJSON(data []byte) error {
+	type plain UpdateStatus
+	var raw struct {
+		plain
+		VersionInfo map[string]json.RawMessage `json:"version_info"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*s = UpdateStatus(raw.plain)
+	s.Repos = make(map[string]RepoUpdateInfo)
+	for name, info := range raw.VersionInfo {
+		if name == "system" {
+			if err := json.Unmarshal(info, &s.System); err != nil {
+				return err
+			}
+			continue
+		}
+		var repo RepoUpdateInfo
+		if err := json.Unmarshal(info, &repo); err != nil {
+			return err
+		}
+		if repo.Name == "" {
+			repo.Name = name
+		}
+		s.Repos[name] = repo
+	}
+	return nil
+}
+
+func (c *MoonClient) UpdateStatus() (*UpdateStatus, error) {
+	ctx := context.Background()
+	var resp UpdateStatus
+	if err := c.Conn.CallResult(ctx, "machine.update.status", nil, &resp); err != nil {
+		return &resp, err
+	}
+	return &resp, nil
+}
+
+func (c *MoonClient) RefreshUpdateStatus(name string) (*UpdateStatus, error) {
+	ctx := context.Background()
+	var resp UpdateStatus
+	if err := c.Conn.CallResult(ctx, "machine.update.refresh", updateParams{Name: name}, &resp); err != nil {
+		return &resp, err
+	}
+	return &resp, nil
+}
+
+type UpdateResponse struct {
+	Application string `json:"application"`
+	ProcId      int    `json:"proc_id"`
+	Message     string `json:"message"`
+	Complete    bool   `json:"complete"`
+}
+
+func (c *MoonClient) OnUpdateResponse(fn func(*UpdateResponse)) func() {
+	return onTypedNotification(c, "notify_update_response", fn)
+}
+
+type updateParams struct {
+	Name string `json:"name,omitempty"`
+	Hard bool   `json:"hard,omitempty"`
+}
+
+// How long to wait for the final notify_update_response after the update
+// request itself has returned.
+var updateCompleteGrace = 5 * time.Second
+
+// runUpdate calls an update endpoint and forwards every notify_update_response
+// to progress until Moonraker reports the update as complete.
+func (c *MoonClient) runUpdate(method string, params interface{}, progress func(*UpdateResponse)) error {
+	done := make(chan struct{})
+	var once sync.Once
+	cancel := c.OnUpdateResponse(func(resp *UpdateResponse) {
+		if progress != nil {
+			progress(resp)
+		}
+		if resp.Complete {
+			once.Do(func() { close(done) })
+		}
+	})
+	defer cancel()
+	if _, err := c.Conn.Call(context.Background(), method, params); err != nil {
+		return err
+	}
+	select {
+	case <-done:
+	case <-time.After(updateCompleteGrace):
+	}
+	return nil
+}
+
+func (c *MoonClient) UpgradeApplication(name string, progress func(*UpdateResponse)) error {
+	return c.runUpdate("machine.update.upgrade", updateParams{Name: name}, progress)
+}
+
+func (c *MoonClient) UpdateAll(progress func(*UpdateResponse)) error {
+	return c.runUpdate("machine.update.full", nil, progress)
+}
+
+func (c *MoonClient) UpdateClient(name string, progress func(*UpdateResponse)) error {
+	return c.runUpdate("machine.update.client", updateParams{Name: name}, progress)
+}
+
+func (c *MoonClient) UpdateSystem(progress func(*UpdateResponse)) error {
+	return c.runUpdate("machine.update.system", nil, progress)
+}
+
+func (c *MoonClient) RecoverApplication(name string, hard bool, progress func(*UpdateResponse)) error {
+	return c.runUpdate("machine.update.recover", updateParams{Name: name, Hard: hard}, progress)
+}
+
+func (c *MoonClient) RollbackApplication(name string, progress func(*UpdateResponse)) error {
+	return c.runUpdate("machine.update.rollback", updateParams{Name: name}, progress)
+}
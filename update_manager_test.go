@@ -0,0 +1,79 @@
+package go_moonraker
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/creachadair/jrpc2"
+	"github.com/creachadair/jrpc2/handler"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+const updateStatusJSON = `{
+	"busy": false,
+	"github_rate_limit": 60,
+	"github_requests_remaining": 57,
+	"github_limit_reset_time": 1615836932,
+	"version_info": {
+		"system": {"package_count": 2, "package_list": ["libtiff5", "raspberrypi-sys-mods"]},
+		"moonraker": {
+			"configured_type": "git_repo", "channel": "dev", "is_valid": true, "is_dirty": false, "corrupt": false,
+			"version": "v0.7.1-364", "remote_version": "v0.7.1-366",
+			"commits_behind": [{"sha": "a1b2", "author": "Arksine", "subject": "update_manager: fix"}, {"sha": "c3d4"}]
+		},
+		"mainsail": {"name": "mainsail", "configured_type": "web", "version": "v2.1.1", "remote_version": "v2.1.1", "is_valid": true},
+		"klipper": {"configured_type": "git_repo", "is_valid": false, "is_dirty": true}
+	}
+}`
+
+func TestUpdateStatus_UnmarshalJSON(t *testing.T) {
+	assert := assert.New(t)
+	var status UpdateStatus
+	assert.NoError(json.Unmarshal([]byte(updateStatusJSON), &status))
+	assert.Equal(57, status.GithubRequestsRemaining)
+	assert.Equal(2, status.System.PackageCount)
+	assert.Len(status.Repos, 3)
+
+	moonraker := status.Repos["moonraker"]
+	assert.Equal("moonraker", moonraker.Name)
+	assert.Len(moonraker.CommitsBehind, 2)
+	assert.Equal("Arksine", moonraker.CommitsBehind[0].Author)
+	assert.True(moonraker.UpdateAvailable())
+	assert.False(status.Repos["mainsail"].UpdateAvailable())
+	assert.True(status.Repos["klipper"].Invalid())
+	assert.True(status.Repos["klipper"].IsDirty)
+}
+
+func TestMoonClient_UpgradeApplication(t *testing.T) {
+	assert := assert.New(t)
+	var upgraded string
+	c, _ := newTestClient(t, handler.Map{
+		"machine.update.upgrade": handler.New(func(ctx context.Context, params updateParams) (string, error) {
+			upgraded = params.Name
+			srv := jrpc2.ServerFromContext(ctx)
+			for _, resp := range []UpdateResponse{
+				{Application: params.Name, ProcId: 1, Message: "Updating Repo..."},
+				{Application: params.Name, ProcId: 1, Message: "Update Finished...", Complete: true},
+			} {
+				if err := srv.Notify(ctx, "notify_update_response", []interface{}{resp}); err != nil {
+					return "", err
+				}
+			}
+			return "ok", nil
+		}),
+	})
+
+	var mu sync.Mutex
+	var messages []string
+	err := c.UpgradeApplication("moonraker", func(resp *UpdateResponse) {
+		mu.Lock()
+		defer mu.Unlock()
+		messages = append(messages, resp.Message)
+	})
+	assert.NoError(err)
+	assert.Equal("moonraker", upgraded)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Contains(messages, "Update Finished...")
+}